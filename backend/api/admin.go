@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	defaultAdminUsersLimit = 50
+	maxAdminUsersLimit     = 500
+)
+
+type adminApi struct {
+	db   database.DatabaseHandler
+	hub  *streamHub
+	live *liveHub
+}
+
+func (a *adminApi) AdminUsersGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(a.db, w, r, models.AdminRole); !ok {
+		return
+	}
+
+	params := r.URL.Query()
+
+	limit := defaultAdminUsersLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxAdminUsersLimit {
+			apierror.Error(w, "Query parameter 'limit' must be between 1 and "+strconv.Itoa(maxAdminUsersLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	offset := 0
+	if o := params.Get("offset"); o != "" {
+		var err error
+		offset, err = strconv.Atoi(o)
+		if err != nil || offset < 0 {
+			apierror.Error(w, "Query parameter 'offset' must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, err := a.db.GetUserStats(params.Get("search"), limit, offset)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(users)
+}
+
+func (a *adminApi) AdminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.AdminRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID", http.StatusBadRequest)
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can't change your own role", http.StatusBadRequest)
+		return
+	}
+
+	var change models.RoleChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := roleRanks[change.Role]; !ok {
+		apierror.Error(w, fmt.Sprintf("Role must be one of %v, %v, %v",
+			models.UserRole, models.ModeratorRole, models.AdminRole), http.StatusBadRequest)
+		return
+	}
+
+	// The new role is recorded as part of the reason in the audit trail
+	reason := change.Role
+	if change.Reason != "" {
+		reason += ": " + change.Reason
+	}
+
+	action, ok := fillModerationAction(w, models.ModerationAction{
+		TargetType: models.UserTarget,
+		TargetId:   id,
+		Reason:     reason,
+	}, u.Id, models.RoleAction)
+	if !ok {
+		return
+	}
+
+	if err := a.db.SetRole(action, change.Role); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// AdminUserPasswordResetPost replaces the user's password with a temporary
+// one and logs them out everywhere, the temporary password is returned so
+// that it can be passed on to the user
+func (a *adminApi) AdminUserPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.AdminRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID", http.StatusBadRequest)
+		return
+	}
+
+	password, err := generateTemporaryPassword()
+	if err != nil {
+		log.Printf("Error generating temporary password: %v\n", err.Error())
+		apierror.Error(w, "Error generating temporary password", http.StatusInternalServerError)
+		return
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v\n", err.Error())
+		apierror.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.PasswordResetAction)
+	if !ok {
+		return
+	}
+	action.TargetType = models.UserTarget
+	action.TargetId = id
+
+	if err = a.db.ForcePasswordReset(action, hash); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.TemporaryPassword{Password: password})
+}
+
+func (a *adminApi) AdminUserPostsDelete(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.AdminRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID", http.StatusBadRequest)
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.DeletePostsAction)
+	if !ok {
+		return
+	}
+	action.TargetType = models.UserTarget
+	action.TargetId = id
+
+	postIds, err := a.db.DeleteUserPosts(action)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	for _, postId := range postIds {
+		removeRecommendsNode(postId)
+		a.hub.expirePost(postId)
+		a.live.dropPost(postId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *adminApi) AdminRecommendsRebuildPost(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(a.db, w, r, models.AdminRole); !ok {
+		return
+	}
+
+	if err := rebuildRecommends(); err != nil {
+		log.Printf("Error rebuilding recommends graph: %v\n", err)
+		apierror.Error(w, "Could not rebuild recommends graph", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *adminApi) AdminStatsGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(a.db, w, r, models.AdminRole); !ok {
+		return
+	}
+
+	stats, err := a.db.GetSystemStats()
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// The rest of the stats are still useful if the recommends service is down
+	stats.Graph, err = getRecommendsStats()
+	if err != nil {
+		log.Printf("Error getting recommends stats: %v\n", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
@@ -2,24 +2,57 @@ package api
 
 import (
 	"net/http"
+	"os"
 
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/mail"
+	"github.com/jbrunsting/transient/backend/oidc"
 )
 
+const defaultAppUrl = "http://localhost"
+
 type Api interface {
 	SelfGet(w http.ResponseWriter, r *http.Request)
 	UserGet(w http.ResponseWriter, r *http.Request)
+	UserProfilePatch(w http.ResponseWriter, r *http.Request)
+	UserFollowersGet(w http.ResponseWriter, r *http.Request)
+	UserFollowingsGet(w http.ResponseWriter, r *http.Request)
+	UserKnownFollowersGet(w http.ResponseWriter, r *http.Request)
+	FollowingsExportGet(w http.ResponseWriter, r *http.Request)
+	FollowingsImportPost(w http.ResponseWriter, r *http.Request)
 	UserAuthenticatedGet(w http.ResponseWriter, r *http.Request)
 	UserPost(w http.ResponseWriter, r *http.Request)
+	UserPatch(w http.ResponseWriter, r *http.Request)
 	UserLoginPost(w http.ResponseWriter, r *http.Request)
+	UserLoginTwoFactorPost(w http.ResponseWriter, r *http.Request)
 	UserLogoutPost(w http.ResponseWriter, r *http.Request)
 	UserInvalidatePost(w http.ResponseWriter, r *http.Request)
 	UserDeletePost(w http.ResponseWriter, r *http.Request)
 	UserPasswordPost(w http.ResponseWriter, r *http.Request)
+	UserPasswordResetPost(w http.ResponseWriter, r *http.Request)
+	UserPasswordResetConfirmPost(w http.ResponseWriter, r *http.Request)
+	UserPrivacyPost(w http.ResponseWriter, r *http.Request)
+	UserTwoFactorEnrollPost(w http.ResponseWriter, r *http.Request)
+	UserTwoFactorConfirmPost(w http.ResponseWriter, r *http.Request)
+	UserTwoFactorDisablePost(w http.ResponseWriter, r *http.Request)
+	UserTokensPost(w http.ResponseWriter, r *http.Request)
+	UserTokensGet(w http.ResponseWriter, r *http.Request)
+	UserTokenDelete(w http.ResponseWriter, r *http.Request)
+	UserIdentitiesGet(w http.ResponseWriter, r *http.Request)
+	UserExportPost(w http.ResponseWriter, r *http.Request)
+	UserExportGet(w http.ResponseWriter, r *http.Request)
+	UserIdentityDelete(w http.ResponseWriter, r *http.Request)
+	OidcLoginGet(w http.ResponseWriter, r *http.Request)
+	OidcLinkGet(w http.ResponseWriter, r *http.Request)
+	OidcReauthGet(w http.ResponseWriter, r *http.Request)
+	OidcCallbackGet(w http.ResponseWriter, r *http.Request)
+	UserVerifyGet(w http.ResponseWriter, r *http.Request)
+	UserVerifyResendPost(w http.ResponseWriter, r *http.Request)
 	UsersSearchGet(w http.ResponseWriter, r *http.Request)
 	UsersExactGet(w http.ResponseWriter, r *http.Request)
 
 	PostsGet(w http.ResponseWriter, r *http.Request)
+	PostsSearchGet(w http.ResponseWriter, r *http.Request)
 	PostPost(w http.ResponseWriter, r *http.Request)
 	PostDelete(w http.ResponseWriter, r *http.Request)
 	PostVotePost(w http.ResponseWriter, r *http.Request)
@@ -29,19 +62,88 @@ type Api interface {
 	FollowingsGet(w http.ResponseWriter, r *http.Request)
 	FollowingPost(w http.ResponseWriter, r *http.Request)
 	FollowingDelete(w http.ResponseWriter, r *http.Request)
+	FollowRequestsGet(w http.ResponseWriter, r *http.Request)
+	FollowRequestAcceptPost(w http.ResponseWriter, r *http.Request)
+	FollowRequestRejectPost(w http.ResponseWriter, r *http.Request)
 	FollowingsPostsGet(w http.ResponseWriter, r *http.Request)
 
+	BlockPost(w http.ResponseWriter, r *http.Request)
+	BlockDelete(w http.ResponseWriter, r *http.Request)
+	MutePost(w http.ResponseWriter, r *http.Request)
+	MuteDelete(w http.ResponseWriter, r *http.Request)
+
+	ReportPost(w http.ResponseWriter, r *http.Request)
+	ModerationReportsGet(w http.ResponseWriter, r *http.Request)
+	ModerationReportDismissPost(w http.ResponseWriter, r *http.Request)
+	ModerationHidePost(w http.ResponseWriter, r *http.Request)
+	ModerationSuspendPost(w http.ResponseWriter, r *http.Request)
+	ModerationUnsuspendPost(w http.ResponseWriter, r *http.Request)
+	ModerationActionsGet(w http.ResponseWriter, r *http.Request)
+
+	AdminUsersGet(w http.ResponseWriter, r *http.Request)
+	AdminUserRolePost(w http.ResponseWriter, r *http.Request)
+	AdminUserPasswordResetPost(w http.ResponseWriter, r *http.Request)
+	AdminUserPostsDelete(w http.ResponseWriter, r *http.Request)
+	AdminRecommendsRebuildPost(w http.ResponseWriter, r *http.Request)
+	AdminStatsGet(w http.ResponseWriter, r *http.Request)
+
 	RecommendsPostsGet(w http.ResponseWriter, r *http.Request)
 	RecommendsFollowingsGet(w http.ResponseWriter, r *http.Request)
+
+	TagPostsGet(w http.ResponseWriter, r *http.Request)
+	TagsTrendingGet(w http.ResponseWriter, r *http.Request)
+
+	NotificationsGet(w http.ResponseWriter, r *http.Request)
+	NotificationsUnreadGet(w http.ResponseWriter, r *http.Request)
+	NotificationsReadPost(w http.ResponseWriter, r *http.Request)
+
+	StreamGet(w http.ResponseWriter, r *http.Request)
+	StreamWatchPost(w http.ResponseWriter, r *http.Request)
+
+	LiveGet(w http.ResponseWriter, r *http.Request)
 }
 
 type api struct {
 	userApi
+	exportApi
 	postApi
 	followingApi
+	blockApi
+	moderationApi
+	adminApi
 	recommendsApi
+	tagApi
+	notificationApi
+	streamApi
+	*liveApi
 }
 
-func NewApi(db database.DatabaseHandler) Api {
-	return &api{userApi: userApi{db: db}, postApi: postApi{db: db}, followingApi: followingApi{db: db}, recommendsApi: recommendsApi{db: db}}
+// NewApi creates the API, links in emails point to the frontend at APP_URL.
+// The identity provider is optional
+func NewApi(db database.DatabaseHandler, mailer mail.Mailer, idp *oidc.Provider) Api {
+	appUrl := os.Getenv("APP_URL")
+	if appUrl == "" {
+		appUrl = defaultAppUrl
+	}
+
+	previews := newPreviewFetcher(db)
+	hub := newStreamHub()
+	n := &notifier{db: db, hub: hub}
+	live := &liveApi{db: db, hub: newLiveHub()}
+	restricted := newRestrictions()
+	go purgeDeletedUsers(db, hub, live.hub)
+	return &api{
+		userApi:         userApi{db: db, mailer: mailer, hub: hub, idp: idp, appUrl: appUrl},
+		exportApi:       newExportApi(db),
+		postApi:         postApi{db: db, previews: previews, notifier: n, hub: hub, live: live, restrictions: restricted},
+		followingApi:    followingApi{db: db, notifier: n, restrictions: restricted, graph: recommendsService{}},
+		blockApi:        blockApi{db: db, live: live.hub},
+		moderationApi:   moderationApi{db: db, hub: hub, live: live.hub},
+		adminApi:        adminApi{db: db, hub: hub, live: live.hub},
+		recommendsApi:   recommendsApi{db: db},
+		tagApi:          tagApi{db: db},
+		notificationApi: notificationApi{db: db},
+		streamApi:       streamApi{db: db, hub: hub},
+		liveApi:         live,
+	}
 }
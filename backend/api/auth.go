@@ -2,14 +2,19 @@ package api
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/jbrunsting/transient/backend/database"
 	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
 const (
@@ -18,8 +23,19 @@ const (
 	bcryptCost      = 10
 	timeFormat      = time.RFC3339
 	expiryMinutes   = 86400
+
+	temporaryPasswordLength = 16
+	tokenLength             = 32
 )
 
+// roleRanks orders the roles, each role has the permissions of the roles
+// ranked below it
+var roleRanks = map[string]int{
+	models.UserRole:      0,
+	models.ModeratorRole: 1,
+	models.AdminRole:     2,
+}
+
 func hashPassword(password string) (string, error) {
 	p, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	return string(p), err
@@ -36,6 +52,29 @@ func generateSessionId() (string, error) {
 	return id, err
 }
 
+// generateToken returns a random token to send to the user, along with the
+// hash of it to store
+func generateToken() (string, string, error) {
+	b := make([]byte, tokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateTemporaryPassword() (string, error) {
+	b := make([]byte, temporaryPasswordLength)
+	_, err := rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)[:temporaryPasswordLength], err
+}
+
 func generateSession(id string) (models.Session, error) {
 	var s models.Session
 	var err error
@@ -63,6 +102,123 @@ func getSessionId(r *http.Request) (string, error) {
 	return "", errors.New("No session ID cookie")
 }
 
+// authError is a reason a request isn't authenticated that isn't a database
+// error
+type authError struct {
+	message string
+	code    int
+}
+
+func (e *authError) Error() string {
+	return e.message
+}
+
+func getBearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(parts[1]), true
+}
+
+// getRequestUser returns the user from the request's API token if it has one,
+// otherwise from its session cookie. Tokens must have the scope to be used
+func getRequestUser(db database.DatabaseHandler, r *http.Request, scope string) (models.User, error) {
+	token, ok := getBearerToken(r)
+	if !ok {
+		sessionId, err := getSessionId(r)
+		if err != nil {
+			return models.User{}, &authError{"Not logged in", http.StatusUnauthorized}
+		}
+
+		return db.GetUserFromSession(sessionId)
+	}
+
+	t, err := db.UseApiToken(hashToken(token))
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			return models.User{}, &authError{"API token is invalid or has expired", http.StatusUnauthorized}
+		}
+		return models.User{}, err
+	}
+
+	if !t.HasScope(scope) {
+		return models.User{}, &authError{"API token does not have the " + scope + " scope", http.StatusForbidden}
+	}
+
+	u, err := db.GetUserFromId(t.Id)
+	if err != nil {
+		return u, err
+	}
+
+	// Suspending a user deletes their sessions but leaves their tokens
+	if u.Suspended {
+		return models.User{}, &authError{"Account suspended", http.StatusForbidden}
+	}
+
+	return u, nil
+}
+
+// authenticate writes an error response and returns false if the request
+// isn't from a logged in user, or from an API token with the given scope
+func authenticate(db database.DatabaseHandler, w http.ResponseWriter, r *http.Request, scope string) (models.User, bool) {
+	u, err := getRequestUser(db, r, scope)
+	if err != nil {
+		if e, ok := err.(*authError); ok {
+			apierror.Error(w, e.message, e.code)
+		} else {
+			handleDbErr(err, w)
+		}
+		return u, false
+	}
+
+	return u, true
+}
+
+// getViewerId returns the ID of the logged in user, or an empty string for
+// anonymous requests
+func getViewerId(db database.DatabaseHandler, r *http.Request) string {
+	u, err := getRequestUser(db, r, models.ReadScope)
+	if err != nil {
+		return ""
+	}
+
+	return u.Id
+}
+
+func hasRole(u models.User, role string) bool {
+	return roleRanks[u.Role] >= roleRanks[role]
+}
+
+// requireRole writes an error response and returns false if the request isn't
+// from a logged in user with at least the given role
+func requireRole(db database.DatabaseHandler, w http.ResponseWriter, r *http.Request, role string) (models.User, bool) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return models.User{}, false
+	}
+
+	u, err := db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return u, false
+	}
+
+	if !hasRole(u, role) {
+		apierror.Error(w, "Must be a "+role, http.StatusForbidden)
+		return u, false
+	}
+
+	return u, true
+}
+
 func storeSessionCookie(w http.ResponseWriter, s models.Session) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionIdCookie,
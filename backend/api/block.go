@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+type blockApi struct {
+	db   database.DatabaseHandler
+	live *liveHub
+}
+
+// checkNotBlocked writes an error response and returns false if either user
+// has blocked the other
+func checkNotBlocked(db database.DatabaseHandler, w http.ResponseWriter, id, otherId string) bool {
+	blocked, err := db.IsBlocked(id, otherId)
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	if blocked {
+		apierror.Error(w, "User is blocked", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (a *blockApi) BlockPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to block", http.StatusBadRequest)
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can't block yourself", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := a.db.GetUserFromId(id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if err := a.db.CreateBlock(u.Id, id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	removeRecommendsEdge(&edgeResource{SourceId: u.Id, DestinationId: id, Type: followEdge})
+	removeRecommendsEdge(&edgeResource{SourceId: id, DestinationId: u.Id, Type: followEdge})
+	addRecommendsExclusion(&exclusionResource{Id: u.Id, ExcludedId: id, Type: blockExclusion})
+	a.live.dropBlocked(u.Id, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *blockApi) BlockDelete(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to unblock", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.db.DeleteBlock(u.Id, id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	removeRecommendsExclusion(&exclusionResource{Id: u.Id, ExcludedId: id, Type: blockExclusion})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *blockApi) MutePost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to mute", http.StatusBadRequest)
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can't mute yourself", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := a.db.GetUserFromId(id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if err := a.db.CreateMute(u.Id, id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	addRecommendsExclusion(&exclusionResource{Id: u.Id, ExcludedId: id, Type: muteExclusion})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *blockApi) MuteDelete(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to unmute", http.StatusBadRequest)
+		return
+	}
+
+	if err := a.db.DeleteMute(u.Id, id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	removeRecommendsExclusion(&exclusionResource{Id: u.Id, ExcludedId: id, Type: muteExclusion})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
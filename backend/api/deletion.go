@@ -0,0 +1,60 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+const (
+	accountDeletionGracePeriod = 14 * 24 * time.Hour
+	accountDeletionCheck       = time.Hour
+)
+
+// purgeDeletedUsers removes the users whose grace period has ended, along with
+// their nodes in the recommends graph
+func purgeDeletedUsers(db database.DatabaseHandler, hub *streamHub, live *liveHub) {
+	for now := range time.Tick(accountDeletionCheck) {
+		ids, err := db.GetDueUserDeletions(now)
+		if err != nil {
+			log.Printf("Error getting due account deletions: %v\n", err)
+			continue
+		}
+
+		for _, id := range ids {
+			postIds, err := db.PurgeUser(id, now)
+			if err != nil {
+				// The user logged in and cancelled the deletion
+				if _, ok := err.(*database.NotFoundError); !ok {
+					log.Printf("Error purging user %v: %v\n", id, err)
+				}
+				continue
+			}
+
+			for _, postId := range postIds {
+				removeRecommendsNode(postId)
+				hub.expirePost(postId)
+				live.dropPost(postId)
+			}
+			removeRecommendsNode(id)
+		}
+	}
+}
+
+// cancelDeletion keeps the account of a user who logs in during their grace
+// period, it writes an error response and returns false if it couldn't
+func (a *userApi) cancelDeletion(w http.ResponseWriter, u models.User) bool {
+	if u.DeletionScheduled.IsZero() {
+		return true
+	}
+
+	if err := a.db.CancelUserDeletion(u.Id); err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	return true
+}
@@ -1,54 +1,53 @@
 package api
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
-const (
-	CONNECTION           = "connection"
-	NOT_FOUND            = "not_found"
-	DATA_VIOLATION       = "data_volation"
-	UNIQUENESS_VIOLATION = "uniqueness_violation"
-	UNEXPECTED           = "unexpected"
-)
-
-type httpError struct {
-	Message string `json:"message"`
-	Kind    string `json:"kind"`
-}
-
-func sendError(w http.ResponseWriter, code int, e httpError) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(e)
+// invalidField sends a validation error for a single field of the request
+func invalidField(w http.ResponseWriter, field, message string) {
+	apierror.Invalid(w, message, apierror.Field{Field: field, Message: message})
 }
 
+// handleDbErr sends the error from the database, see the apierror package for
+// how the errors map to codes
 func handleDbErr(err error, w http.ResponseWriter) {
 	var code int
 	var kind string
+	requestId := w.Header().Get(apierror.RequestIdHeader)
 	switch e := err.(type) {
 	case *database.ConnectionError:
-		log.Printf("%v: %v", e.Error(), e.InternalError)
+		log.Printf("%v: %v (request %v)", e.Error(), e.InternalError, requestId)
 		code = http.StatusServiceUnavailable
-		kind = CONNECTION
+		kind = apierror.Connection
 	case *database.NotFoundError:
 		code = http.StatusNotFound
-		kind = NOT_FOUND
+		kind = apierror.NotFound
 	case *database.DataViolation:
 		code = http.StatusBadRequest
-		kind = DATA_VIOLATION
+		kind = apierror.DataViolation
 	case *database.UniquenessViolation:
 		code = http.StatusBadRequest
-		kind = UNIQUENESS_VIOLATION
+		kind = apierror.UniquenessViolation
+	case *database.ForeignKeyViolation:
+		code = http.StatusNotFound
+		kind = apierror.ForeignKeyViolation
+	case *database.CheckViolation:
+		code = http.StatusBadRequest
+		kind = apierror.CheckViolation
 	case *database.UnexpectedError:
-		log.Printf("%v: %v", e.Error(), e.InternalError)
+		log.Printf("%v: %v (request %v)", e.Error(), e.InternalError, requestId)
+		code = http.StatusInternalServerError
+		kind = apierror.Unexpected
+	default:
+		log.Printf("Unknown database error: %v (request %v)", err, requestId)
 		code = http.StatusInternalServerError
-		kind = UNEXPECTED
+		kind = apierror.Unexpected
 	}
 
-	sendError(w, code, httpError{Message: err.Error(), Kind: kind})
+	apierror.Write(w, code, apierror.Response{Code: kind, Message: err.Error()})
 }
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+func TestHandleDbErr(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{{
+		name:   "connection",
+		err:    &database.ConnectionError{InternalError: "connection refused"},
+		status: http.StatusServiceUnavailable,
+		code:   apierror.Connection,
+	}, {
+		name:   "not found",
+		err:    &database.NotFoundError{Object: "user"},
+		status: http.StatusNotFound,
+		code:   apierror.NotFound,
+	}, {
+		name:   "data violation",
+		err:    &database.DataViolation{Violation: "value too long"},
+		status: http.StatusBadRequest,
+		code:   apierror.DataViolation,
+	}, {
+		name:   "uniqueness violation",
+		err:    &database.UniquenessViolation{Object: "user"},
+		status: http.StatusBadRequest,
+		code:   apierror.UniquenessViolation,
+	}, {
+		name:   "foreign key violation",
+		err:    &database.ForeignKeyViolation{Object: "following"},
+		status: http.StatusNotFound,
+		code:   apierror.ForeignKeyViolation,
+	}, {
+		name:   "check violation",
+		err:    &database.CheckViolation{Object: "following", Constraint: "followings_not_self"},
+		status: http.StatusBadRequest,
+		code:   apierror.CheckViolation,
+	}, {
+		name:   "unexpected",
+		err:    &database.UnexpectedError{Action: "creating user", InternalError: "closed"},
+		status: http.StatusInternalServerError,
+		code:   apierror.Unexpected,
+	}, {
+		name:   "unknown",
+		err:    errors.New("closed"),
+		status: http.StatusInternalServerError,
+		code:   apierror.Unexpected,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set(apierror.RequestIdHeader, "request-id")
+			handleDbErr(test.err, rec)
+
+			if rec.Code != test.status {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, test.status, rec.Body)
+			}
+
+			var res apierror.Response
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("Could not decode response: %v", err)
+			}
+			want := apierror.Response{Code: test.code, Message: test.err.Error(), RequestId: "request-id"}
+			if res.Code != want.Code || res.Message != want.Message || res.RequestId != want.RequestId {
+				t.Errorf("Got response %+v, want %+v", res, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	exportExpiry      = 24 * time.Hour
+	exportTimeout     = 10 * time.Minute
+	exportExpiryCheck = time.Hour
+)
+
+type exportApi struct {
+	db database.DatabaseHandler
+}
+
+func newExportApi(db database.DatabaseHandler) exportApi {
+	a := exportApi{db: db}
+	go a.expireExports()
+	return a
+}
+
+func (a *exportApi) expireExports() {
+	for now := range time.Tick(exportExpiryCheck) {
+		if err := a.db.DeleteExpiredUserExports(now.Add(-exportTimeout)); err != nil {
+			log.Printf("Error deleting expired exports: %v\n", err)
+		}
+	}
+}
+
+// buildExport collects everything stored about the user into a ZIP with a
+// JSON file for each kind of data
+func (a *exportApi) buildExport(id string) ([]byte, error) {
+	u, err := a.db.GetUserFromId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := a.db.GetAllUserPosts(id)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := a.db.GetUserComments(id)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := a.db.GetUserVotes(id)
+	if err != nil {
+		return nil, err
+	}
+
+	followings, err := a.db.GetFollowings(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Other users' emails aren't the user's data
+	for i := range followings {
+		followings[i].Email = ""
+	}
+
+	sessions := []models.ExportSession{}
+	for _, s := range u.Sessions {
+		sessions = append(sessions, models.ExportSession{Expiry: s.Expiry})
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", models.User{
+			Id:             u.Id,
+			Identification: models.Identification{Username: u.Username},
+			Email:          u.Email,
+			Private:        u.Private,
+			Role:           u.Role,
+			Verified:       u.Verified,
+			TwoFactor:      u.TwoFactor,
+		}},
+		{"posts.json", posts},
+		{"comments.json", comments},
+		{"votes.json", votes},
+		{"followings.json", followings},
+		{"sessions.json", sessions},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := archive.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(f.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (a *exportApi) runExport(e models.UserExport) {
+	status := models.ExportReady
+	data, err := a.buildExport(e.Id)
+	if err != nil {
+		log.Printf("Error building export %v: %v\n", e.ExportId, err)
+		status = models.ExportFailed
+		data = nil
+	}
+
+	if err = a.db.FinishUserExport(e.ExportId, status, data); err != nil {
+		log.Printf("Error saving export %v: %v\n", e.ExportId, err)
+	}
+}
+
+// UserExportPost starts building an archive of the user's data, the status of
+// the export can be checked with UserExportGet until it is ready
+func (a *exportApi) UserExportPost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	exportId, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	e := models.UserExport{
+		ExportId: exportId.String(),
+		Id:       u.Id,
+		Status:   models.ExportPending,
+		Time:     now,
+		Expiry:   now.Add(exportExpiry),
+	}
+	if err = a.db.CreateUserExport(e); err != nil {
+		if _, ok := err.(*database.UniquenessViolation); ok {
+			apierror.Error(w, "An export is already being built", http.StatusTooManyRequests)
+			return
+		}
+		handleDbErr(err, w)
+		return
+	}
+
+	go a.runExport(e)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(e)
+}
+
+// UserExportGet downloads the export once it's ready, until then it responds
+// with the export's status
+func (a *exportApi) UserExportGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	exportId, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide an export ID", http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	e, err := a.db.GetUserExport(u.Id, exportId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if e.Status != models.ExportReady {
+		code := http.StatusAccepted
+		if e.Status == models.ExportFailed {
+			code = http.StatusInternalServerError
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="transient-`+u.Username+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(e.Data)
+}
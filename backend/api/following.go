@@ -1,28 +1,41 @@
 package api
 
 import (
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	defaultFollowLimit        = 50
+	defaultKnownFollowerLimit = 3
+	maxFollowLimit            = 100
+
+	importUsernameHeader = "username"
+	maxImportRows        = 1000
+	maxImportBytes       = 64 * 1024
 )
 
 type followingApi struct {
-	db database.DatabaseHandler
+	db           database.DatabaseHandler
+	notifier     *notifier
+	restrictions restrictions
+	graph        recommendsGraph
 }
 
 func (a *followingApi) FollowingsGet(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
-
-	u, err := a.db.GetUserFromSession(sessionId)
-	if err != nil {
-		handleDbErr(err, w)
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
 		return
 	}
 
@@ -37,65 +50,367 @@ func (a *followingApi) FollowingsGet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(followings)
 }
 
+// getFollowable writes an error response and returns false if the user
+// doesn't exist or is being deleted
+func (a *followingApi) getFollowable(w http.ResponseWriter, id string) (models.User, bool) {
+	u, err := a.db.GetUserFromId(id)
+	if err != nil {
+		handleDbErr(err, w)
+		return u, false
+	}
+
+	if !u.DeletionScheduled.IsZero() {
+		handleDbErr(&database.NotFoundError{Object: "user"}, w)
+		return u, false
+	}
+
+	return u, true
+}
+
 func (a *followingApi) FollowingPost(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to follow", http.StatusBadRequest)
+		return
+	}
+
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	if !a.restrictions.allow(w, u, followAction) {
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can not follow yourself", http.StatusBadRequest)
+		return
+	}
+
+	following, ok := a.getFollowable(w, id)
+	if !ok {
+		return
+	}
+
+	if !checkNotBlocked(a.db, w, u.Id, id) {
+		return
+	}
+
+	alreadyFollowing, err := a.db.IsFollowing(u.Id, id)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		handleDbErr(err, w)
 		return
 	}
 
+	// Private users have to approve their followers, so only a request is
+	// created until they accept it
+	if following.Private && !alreadyFollowing {
+		created, err := a.db.CreateFollowRequest(u.Id, id)
+		if err != nil {
+			handleDbErr(err, w)
+			return
+		}
+
+		// Requesting again while the request is pending doesn't notify again
+		if created {
+			a.notifier.notify(models.Notification{
+				Id:      id,
+				ActorId: u.Id,
+				Kind:    models.FollowRequestNotification,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(models.FollowStatus{Status: models.FollowPending})
+		return
+	}
+
+	created, err := a.db.CreateFollowing(u.Id, id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// Following again succeeds without doing anything
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		a.graph.addEdge(&edgeResource{
+			SourceId:      u.Id,
+			DestinationId: id,
+			Type:          followEdge,
+		}, false)
+
+		a.notifier.notify(models.Notification{
+			Id:      id,
+			ActorId: u.Id,
+			Kind:    models.FollowNotification,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.FollowStatus{Status: models.FollowAccepted})
+}
+
+func (a *followingApi) FollowingDelete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a user ID to follow", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a following to delete", http.StatusBadRequest)
+		return
+	}
+
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can not unfollow yourself", http.StatusBadRequest)
+		return
+	}
+
+	// Unfollowing someone who isn't followed succeeds, as long as they exist
+	if _, ok = a.getFollowable(w, id); !ok {
 		return
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	err := a.db.DeleteFollowing(u.Id, id)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
-	err = a.db.CreateFollowing(u.Id, id)
+	a.graph.removeEdge(&edgeResource{
+		SourceId:      u.Id,
+		DestinationId: id,
+		Type:          followEdge,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *followingApi) FollowRequestsGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	requests, err := a.db.GetFollowRequests(u.Id)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
-    addRecommendsEdge(&edgeResource{
-        SourceId: u.Id,
-        DestinationId: id,
-		Type: followEdge,
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(requests)
+}
+
+func (a *followingApi) FollowRequestAcceptPost(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to accept", http.StatusBadRequest)
+		return
+	}
+
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	if err := a.db.AcceptFollowRequest(id, u.Id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	a.graph.addEdge(&edgeResource{
+		SourceId:      id,
+		DestinationId: u.Id,
+		Type:          followEdge,
 	}, false)
 
+	a.notifier.notify(models.Notification{
+		Id:      id,
+		ActorId: u.Id,
+		Kind:    models.FollowAcceptedNotification,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *followingApi) FollowingDelete(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
+func (a *followingApi) FollowRequestRejectPost(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to reject", http.StatusBadRequest)
+		return
+	}
+
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	if err := a.db.RejectFollowRequest(id, u.Id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *followingApi) FollowingsPostsGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	posts, err := a.db.GetFollowingsPosts(u.Id)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		handleDbErr(err, w)
 		return
 	}
+	renderPosts(posts)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(posts)
+}
+
+func encodeFollowCursor(entry models.FollowEntry) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(entry.Username))
+}
+
+func decodeFollowCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(b), err
+}
+
+// followLimit reads the limit query parameter, writing an error response and
+// returning false if it's invalid
+func followLimit(w http.ResponseWriter, r *http.Request, defaultLimit int) (int, bool) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return defaultLimit, true
+	}
+
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 || limit > maxFollowLimit {
+		apierror.Error(w, "Query parameter 'limit' must be between 1 and "+strconv.Itoa(maxFollowLimit), http.StatusBadRequest)
+		return 0, false
+	}
 
+	return limit, true
+}
+
+// checkFollowsVisible writes an error response and returns false if the viewer
+// can't see who the user follows or is followed by, which is hidden for
+// private users unless the viewer follows them
+func (a *followingApi) checkFollowsVisible(w http.ResponseWriter, id, viewerId string) bool {
+	p, err := a.db.GetProfile(id, viewerId)
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	if p.Private && p.Id != viewerId && !p.IsFollowing {
+		apierror.Error(w, "User is private", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (a *followingApi) getFollowList(w http.ResponseWriter, r *http.Request, list func(id, after string, limit int) ([]models.FollowEntry, error)) {
 	vars := mux.Vars(r)
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a following to delete", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a user ID", http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := followLimit(w, r, defaultFollowLimit)
+	if !ok {
+		return
+	}
+
+	after := ""
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		after, err = decodeFollowCursor(cursor)
+		if err != nil {
+			apierror.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !a.checkFollowsVisible(w, id, getViewerId(a.db, r)) {
 		return
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	entries, err := list(id, after, limit)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
-	err = a.db.DeleteFollowing(u.Id, id)
+	response := models.FollowList{Users: entries}
+	if len(entries) == limit {
+		response.NextCursor = encodeFollowCursor(entries[len(entries)-1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *followingApi) UserFollowersGet(w http.ResponseWriter, r *http.Request) {
+	a.getFollowList(w, r, a.db.GetFollowerList)
+}
+
+func (a *followingApi) UserFollowingsGet(w http.ResponseWriter, r *http.Request) {
+	a.getFollowList(w, r, a.db.GetFollowingList)
+}
+
+// UserKnownFollowersGet lists the user's followers that the caller follows
+func (a *followingApi) UserKnownFollowersGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID", http.StatusBadRequest)
+		return
+	}
+
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	limit, ok := followLimit(w, r, defaultKnownFollowerLimit)
+	if !ok {
+		return
+	}
+
+	if !a.checkFollowsVisible(w, id, u.Id) {
+		return
+	}
+
+	known, err := a.db.GetKnownFollowers(id, u.Id, limit)
 	if err != nil {
 		handleDbErr(err, w)
 		return
@@ -103,28 +418,213 @@ func (a *followingApi) FollowingDelete(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(known)
 }
 
-func (a *followingApi) FollowingsPostsGet(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
+// FollowingsExportGet downloads the usernames the user follows, as CSV if the
+// format query parameter is csv and JSON otherwise
+func (a *followingApi) FollowingsExportGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "json" {
+		apierror.Error(w, "Query parameter 'format' must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	followings, err := a.db.GetFollowings(u.Id)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		handleDbErr(err, w)
+		return
+	}
+
+	usernames := []string{}
+	for _, f := range followings {
+		usernames = append(usernames, f.Username)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="followings.csv"`)
+		w.WriteHeader(http.StatusOK)
+
+		writer := csv.NewWriter(w)
+		writer.Write([]string{importUsernameHeader})
+		for _, username := range usernames {
+			writer.Write([]string{username})
+		}
+		writer.Flush()
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="followings.json"`)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.FollowImport{Usernames: usernames})
+}
+
+// readFollowImport reads the usernames from a CSV with a username in the first
+// column of each row, or from the JSON the export produces
+func readFollowImport(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	var usernames []string
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		reader := csv.NewReader(r.Body)
+		reader.FieldsPerRecord = -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			usernames = append(usernames, record[0])
+		}
+
+		if len(usernames) > 0 && strings.EqualFold(strings.TrimSpace(usernames[0]), importUsernameHeader) {
+			usernames = usernames[1:]
+		}
+	} else {
+		var i models.FollowImport
+		if err := json.NewDecoder(r.Body).Decode(&i); err != nil {
+			return nil, err
+		}
+		usernames = i.Usernames
+	}
+
+	cleaned := []string{}
+	for _, username := range usernames {
+		username = strings.TrimPrefix(strings.TrimSpace(username), "@")
+		if username != "" {
+			cleaned = append(cleaned, username)
+		}
+	}
+
+	return cleaned, nil
+}
+
+// FollowingsImportPost follows every user in the import, reporting what
+// happened for each username
+func (a *followingApi) FollowingsImportPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.FollowScope)
+	if !ok {
+		return
+	}
+
+	if !a.restrictions.allow(w, u, followAction) {
+		return
+	}
+
+	usernames, err := readFollowImport(w, r)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		apierror.Error(w, "Import must be at most "+strconv.Itoa(maxImportBytes/1024)+" KB", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(usernames) > maxImportRows {
+		apierror.Error(w, "Can import at most "+strconv.Itoa(maxImportRows)+" usernames at once", http.StatusBadRequest)
 		return
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	found, err := a.db.GetImportTargets(u.Id, usernames)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
-	posts, err := a.db.GetFollowingsPosts(u.Id)
+	targets := map[string]models.ImportTarget{}
+	for _, t := range found {
+		targets[t.Username] = t
+	}
+
+	results := make([]models.FollowImportResult, len(usernames))
+	ids := make([]string, len(usernames))
+	following := map[string]bool{}
+	followingIds := []string{}
+	requestIds := []string{}
+	for i, username := range usernames {
+		results[i].Username = username
+
+		f, ok := targets[username]
+		if !ok {
+			results[i].Status = models.ImportNotFound
+			continue
+		}
+		ids[i] = f.Id
+
+		if f.Id == u.Id {
+			results[i].Status = models.ImportSelf
+			continue
+		}
+
+		if f.Following || following[f.Id] {
+			results[i].Status = models.ImportAlreadyFollowing
+			continue
+		}
+
+		if f.Blocked {
+			results[i].Status = models.ImportBlocked
+			continue
+		}
+
+		following[f.Id] = true
+		if f.Private {
+			results[i].Status = models.ImportRequested
+			requestIds = append(requestIds, f.Id)
+		} else {
+			results[i].Status = models.ImportFollowed
+			followingIds = append(followingIds, f.Id)
+		}
+	}
+
+	followed, requested, err := a.db.ImportFollowings(u.Id, followingIds, requestIds)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
+	// Someone else may have followed in the meantime, so only the follows the
+	// import actually created are reported as new
+	created := map[string]bool{}
+	for _, id := range followed {
+		created[id] = true
+
+		a.graph.addEdge(&edgeResource{
+			SourceId:      u.Id,
+			DestinationId: id,
+			Type:          followEdge,
+		}, false)
+
+		a.notifier.notify(models.Notification{
+			Id:      id,
+			ActorId: u.Id,
+			Kind:    models.FollowNotification,
+		})
+	}
+
+	for _, id := range requested {
+		a.notifier.notify(models.Notification{
+			Id:      id,
+			ActorId: u.Id,
+			Kind:    models.FollowRequestNotification,
+		})
+	}
+
+	for i := range results {
+		if results[i].Status == models.ImportFollowed && !created[ids[i]] {
+			results[i].Status = models.ImportAlreadyFollowing
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(posts)
+	json.NewEncoder(w).Encode(results)
 }
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+// fakeFollowingDb keeps users, followings, requests and blocks in memory, any
+// other method panics through the nil embedded handler
+type fakeFollowingDb struct {
+	database.DatabaseHandler
+
+	mu            sync.Mutex
+	users         map[string]models.User
+	sessions      map[string]string
+	followings    map[[2]string]bool
+	requests      map[[2]string]bool
+	blocks        map[[2]string]bool
+	notifications []models.Notification
+}
+
+func newFakeFollowingDb(users ...models.User) *fakeFollowingDb {
+	db := &fakeFollowingDb{
+		users:      map[string]models.User{},
+		sessions:   map[string]string{},
+		followings: map[[2]string]bool{},
+		requests:   map[[2]string]bool{},
+		blocks:     map[[2]string]bool{},
+	}
+	for _, u := range users {
+		db.users[u.Id] = u
+		db.sessions["session-"+u.Id] = u.Id
+	}
+	return db
+}
+
+func (db *fakeFollowingDb) GetUserFromId(id string) (models.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[id]
+	if !ok {
+		return u, &database.NotFoundError{Object: "user"}
+	}
+	return u, nil
+}
+
+func (db *fakeFollowingDb) GetUserFromSession(sessionId string) (models.User, error) {
+	db.mu.Lock()
+	id, ok := db.sessions[sessionId]
+	db.mu.Unlock()
+	if !ok {
+		return models.User{}, &database.NotFoundError{Object: "user"}
+	}
+	return db.GetUserFromId(id)
+}
+
+func (db *fakeFollowingDb) IsBlocked(id, otherId string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.blocks[[2]string{id, otherId}] || db.blocks[[2]string{otherId, id}], nil
+}
+
+func (db *fakeFollowingDb) IsFollowing(id, followingId string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.followings[[2]string{id, followingId}], nil
+}
+
+func (db *fakeFollowingDb) CreateFollowing(id, followingId string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := [2]string{id, followingId}
+	if db.followings[key] {
+		return false, nil
+	}
+	db.followings[key] = true
+	return true, nil
+}
+
+func (db *fakeFollowingDb) CreateFollowRequest(id, followingId string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key := [2]string{id, followingId}
+	created := !db.requests[key]
+	db.requests[key] = true
+	return created, nil
+}
+
+func (db *fakeFollowingDb) DeleteFollowing(id, followingId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.followings, [2]string{id, followingId})
+	delete(db.requests, [2]string{id, followingId})
+	return nil
+}
+
+func (db *fakeFollowingDb) CreateNotification(n models.Notification) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.notifications = append(db.notifications, n)
+	return true, nil
+}
+
+// fakeGraph records the edges added to the recommends graph instead of calling
+// the recommends service
+type fakeGraph struct {
+	added   []edgeResource
+	removed []edgeResource
+}
+
+func (g *fakeGraph) addEdge(e *edgeResource, bidirectional bool) {
+	g.added = append(g.added, *e)
+}
+
+func (g *fakeGraph) removeEdge(e *edgeResource) {
+	g.removed = append(g.removed, *e)
+}
+
+func TestFollowingStatus(t *testing.T) {
+	const self = "self-id"
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		setup    func(db *fakeFollowingDb)
+		status   int
+		follows  bool
+		requests bool
+		notified bool
+		edge     bool
+	}{{
+		name:     "new follow",
+		method:   "POST",
+		target:   "public-id",
+		status:   http.StatusCreated,
+		follows:  true,
+		notified: true,
+		edge:     true,
+	}, {
+		name:   "already following",
+		method: "POST",
+		target: "public-id",
+		setup: func(db *fakeFollowingDb) {
+			db.followings[[2]string{self, "public-id"}] = true
+		},
+		status:  http.StatusOK,
+		follows: true,
+	}, {
+		name:   "self follow",
+		method: "POST",
+		target: self,
+		status: http.StatusBadRequest,
+	}, {
+		name:   "unknown user",
+		method: "POST",
+		target: "unknown-id",
+		status: http.StatusNotFound,
+	}, {
+		name:   "user being deleted",
+		method: "POST",
+		target: "deleting-id",
+		status: http.StatusNotFound,
+	}, {
+		name:     "private target",
+		method:   "POST",
+		target:   "private-id",
+		status:   http.StatusAccepted,
+		requests: true,
+		notified: true,
+	}, {
+		name:   "already requested private target",
+		method: "POST",
+		target: "private-id",
+		setup: func(db *fakeFollowingDb) {
+			db.requests[[2]string{self, "private-id"}] = true
+		},
+		status:   http.StatusAccepted,
+		requests: true,
+	}, {
+		name:   "already following private target",
+		method: "POST",
+		target: "private-id",
+		setup: func(db *fakeFollowingDb) {
+			db.followings[[2]string{self, "private-id"}] = true
+		},
+		status:  http.StatusOK,
+		follows: true,
+	}, {
+		name:   "blocked target",
+		method: "POST",
+		target: "public-id",
+		setup: func(db *fakeFollowingDb) {
+			db.blocks[[2]string{"public-id", self}] = true
+		},
+		status: http.StatusForbidden,
+	}, {
+		name:   "unfollow",
+		method: "DELETE",
+		target: "public-id",
+		setup: func(db *fakeFollowingDb) {
+			db.followings[[2]string{self, "public-id"}] = true
+		},
+		status: http.StatusOK,
+	}, {
+		name:   "unfollow of a non-follow",
+		method: "DELETE",
+		target: "public-id",
+		status: http.StatusOK,
+	}, {
+		name:   "unfollow cancels request",
+		method: "DELETE",
+		target: "private-id",
+		setup: func(db *fakeFollowingDb) {
+			db.requests[[2]string{self, "private-id"}] = true
+		},
+		status: http.StatusOK,
+	}, {
+		name:   "unfollow self",
+		method: "DELETE",
+		target: self,
+		status: http.StatusBadRequest,
+	}, {
+		name:   "unfollow unknown user",
+		method: "DELETE",
+		target: "unknown-id",
+		status: http.StatusNotFound,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newFakeFollowingDb(
+				models.User{Id: self, Verified: true},
+				models.User{Id: "public-id"},
+				models.User{Id: "private-id", Private: true},
+				models.User{Id: "deleting-id", DeletionScheduled: time.Now()},
+			)
+			if test.setup != nil {
+				test.setup(db)
+			}
+
+			graph := &fakeGraph{}
+			a := &followingApi{
+				db:           db,
+				notifier:     &notifier{db: db, hub: newStreamHub()},
+				restrictions: restrictions{},
+				graph:        graph,
+			}
+
+			r := httptest.NewRequest(test.method, "/following/"+test.target, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": test.target})
+			r.AddCookie(&http.Cookie{Name: sessionIdCookie, Value: "session-" + self})
+
+			rec := httptest.NewRecorder()
+			if test.method == "POST" {
+				a.FollowingPost(rec, r)
+			} else {
+				a.FollowingDelete(rec, r)
+			}
+
+			if rec.Code != test.status {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, test.status, rec.Body)
+			}
+
+			key := [2]string{self, test.target}
+			if db.followings[key] != test.follows {
+				t.Errorf("Following is %v, want %v", db.followings[key], test.follows)
+			}
+			if db.requests[key] != test.requests {
+				t.Errorf("Request is %v, want %v", db.requests[key], test.requests)
+			}
+			if notified := len(db.notifications) > 0; notified != test.notified {
+				t.Errorf("Notified is %v, want %v", notified, test.notified)
+			}
+			if edge := len(graph.added) > 0; edge != test.edge {
+				t.Errorf("Added edge is %v, want %v", edge, test.edge)
+			}
+		})
+	}
+}
+
+func TestFollowingsImportTooLarge(t *testing.T) {
+	const self = "self-id"
+
+	username := strings.Repeat("a", 30)
+	rows := strings.Repeat(username+"\n", maxImportBytes/len(username))
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{{
+		name:        "csv",
+		contentType: "text/csv",
+		body:        rows,
+	}, {
+		name:        "json",
+		contentType: "application/json",
+		body:        `{"usernames":["` + strings.Repeat(username+`","`, maxImportBytes/len(username)) + `"]}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newFakeFollowingDb(models.User{Id: self, Verified: true})
+			a := &followingApi{db: db, notifier: &notifier{db: db, hub: newStreamHub()}, graph: &fakeGraph{}}
+
+			r := httptest.NewRequest("POST", "/followings/import", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			r.AddCookie(&http.Cookie{Name: sessionIdCookie, Value: "session-" + self})
+
+			rec := httptest.NewRecorder()
+			a.FollowingsImportPost(rec, r)
+
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	commentMessage = "comment"
+	votesMessage   = "votes"
+	removedMessage = "removed"
+
+	liveSendBuffer       = 64
+	liveMaxMessageSize   = 16 * 1024
+	liveWriteWait        = 10 * time.Second
+	livePongWait         = 60 * time.Second
+	livePingPeriod       = livePongWait * 9 / 10
+	maxLiveSubscriptions = 100
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type liveMessage struct {
+	Type    string             `json:"type"`
+	PostId  string             `json:"postId"`
+	Comment *models.Comment    `json:"comment,omitempty"`
+	Votes   *models.VoteCounts `json:"votes,omitempty"`
+}
+
+type liveRequest struct {
+	Subscribe   []string `json:"subscribe"`
+	Unsubscribe []string `json:"unsubscribe"`
+}
+
+// liveClient queues messages for a single websocket connection. Comments can't
+// be dropped, so a client whose comment queue fills up is disconnected, but
+// vote counts only matter in their latest state and are coalesced per post
+type liveClient struct {
+	userId string
+	send   chan liveMessage
+	done   chan struct{}
+
+	mu           sync.Mutex
+	subscribed   map[string]string // post ID to author ID
+	pendingVotes map[string]models.VoteCounts
+	votesReady   chan struct{}
+	closed       bool
+	slow         bool
+}
+
+func newLiveClient(userId string) *liveClient {
+	return &liveClient{
+		userId:       userId,
+		send:         make(chan liveMessage, liveSendBuffer),
+		done:         make(chan struct{}),
+		subscribed:   map[string]string{},
+		pendingVotes: map[string]models.VoteCounts{},
+		votesReady:   make(chan struct{}, 1),
+	}
+}
+
+func (c *liveClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+}
+
+func (c *liveClient) queueComment(m liveMessage) {
+	select {
+	case c.send <- m:
+	default:
+		c.mu.Lock()
+		c.slow = true
+		c.mu.Unlock()
+		c.close()
+	}
+}
+
+func (c *liveClient) queueVotes(postId string, counts models.VoteCounts) {
+	c.mu.Lock()
+	c.pendingVotes[postId] = counts
+	c.mu.Unlock()
+
+	select {
+	case c.votesReady <- struct{}{}:
+	default:
+	}
+}
+
+func (c *liveClient) takeVotes() map[string]models.VoteCounts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	votes := c.pendingVotes
+	c.pendingVotes = map[string]models.VoteCounts{}
+	return votes
+}
+
+type liveHub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[*liveClient]bool
+	clients     map[string]map[*liveClient]bool
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{
+		subscribers: map[string]map[*liveClient]bool{},
+		clients:     map[string]map[*liveClient]bool{},
+	}
+}
+
+func (h *liveHub) add(c *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c.userId]; !ok {
+		h.clients[c.userId] = map[*liveClient]bool{}
+	}
+	h.clients[c.userId][c] = true
+}
+
+// disconnect closes every connection the user has open, they are removed
+// from the hub as their handlers return
+func (h *liveHub) disconnect(userId string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients[userId] {
+		c.close()
+	}
+}
+
+// subscribe subscribes to each post, which is mapped to its author, and
+// returns the post IDs that were newly subscribed to
+func (h *liveHub) subscribe(c *liveClient, posts map[string]string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := []string{}
+	for postId, authorId := range posts {
+		if _, ok := c.subscribed[postId]; ok || len(c.subscribed) >= maxLiveSubscriptions {
+			continue
+		}
+
+		c.subscribed[postId] = authorId
+		if _, ok := h.subscribers[postId]; !ok {
+			h.subscribers[postId] = map[*liveClient]bool{}
+		}
+		h.subscribers[postId][c] = true
+		added = append(added, postId)
+	}
+
+	return added
+}
+
+// drop unsubscribes the clients from the posts that match, and tells them the
+// posts were removed
+func (h *liveHub) drop(match func(c *liveClient, postId, authorId string) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for postId, clients := range h.subscribers {
+		for c := range clients {
+			c.mu.Lock()
+			authorId := c.subscribed[postId]
+			dropped := match(c, postId, authorId)
+			if dropped {
+				delete(c.subscribed, postId)
+			}
+			c.mu.Unlock()
+
+			if dropped {
+				delete(clients, c)
+				c.queueComment(liveMessage{Type: removedMessage, PostId: postId})
+			}
+		}
+
+		if len(clients) == 0 {
+			delete(h.subscribers, postId)
+		}
+	}
+}
+
+// dropPost unsubscribes everyone from a post that was hidden or deleted
+func (h *liveHub) dropPost(postId string) {
+	h.drop(func(c *liveClient, id, authorId string) bool {
+		return id == postId
+	})
+}
+
+// dropBlocked unsubscribes the users from each other's posts once one of them
+// blocks the other
+func (h *liveHub) dropBlocked(id, blockedId string) {
+	h.drop(func(c *liveClient, postId, authorId string) bool {
+		return (c.userId == id && authorId == blockedId) || (c.userId == blockedId && authorId == id)
+	})
+}
+
+func (h *liveHub) unsubscribe(c *liveClient, postIds []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, postId := range postIds {
+		delete(c.subscribed, postId)
+		if clients, ok := h.subscribers[postId]; ok {
+			delete(clients, c)
+			if len(clients) == 0 {
+				delete(h.subscribers, postId)
+			}
+		}
+	}
+}
+
+func (h *liveHub) remove(c *liveClient) {
+	c.mu.Lock()
+	postIds := []string{}
+	for postId := range c.subscribed {
+		postIds = append(postIds, postId)
+	}
+	c.mu.Unlock()
+
+	h.unsubscribe(c, postIds)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[c.userId], c)
+	if len(h.clients[c.userId]) == 0 {
+		delete(h.clients, c.userId)
+	}
+}
+
+func (h *liveHub) hasSubscribers(postId string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers[postId]) > 0
+}
+
+func (h *liveHub) publishComment(postId string, comment models.Comment) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.subscribers[postId] {
+		c.queueComment(liveMessage{Type: commentMessage, PostId: postId, Comment: &comment})
+	}
+}
+
+func (h *liveHub) publishVotes(postId string, counts models.VoteCounts) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.subscribers[postId] {
+		c.queueVotes(postId, counts)
+	}
+}
+
+type liveApi struct {
+	db  database.DatabaseHandler
+	hub *liveHub
+}
+
+// visiblePosts returns the posts the user is allowed to watch, mapped to
+// their authors. Posts they can't see or whose author they've blocked, or been
+// blocked by, are left out
+func (a *liveApi) visiblePosts(userId string, postIds []string) map[string]string {
+	posts := map[string]string{}
+	for _, postId := range postIds {
+		if _, ok := posts[postId]; ok {
+			continue
+		}
+
+		if err := a.db.CanViewPost(postId, userId); err != nil {
+			if _, ok := err.(*database.NotFoundError); !ok {
+				log.Printf("Error checking visibility of %v: %v\n", postId, err)
+			}
+			continue
+		}
+
+		post, err := a.db.GetPost(postId)
+		if err != nil {
+			log.Printf("Error getting post %v: %v\n", postId, err)
+			continue
+		}
+
+		blocked, err := a.db.IsBlocked(userId, post.Id)
+		if err != nil {
+			log.Printf("Error checking block for %v: %v\n", postId, err)
+			continue
+		}
+
+		if !blocked {
+			posts[postId] = post.Id
+		}
+	}
+
+	return posts
+}
+
+// publishVotes sends the current vote counts for postId to its subscribers,
+// the counts are only queried if someone is listening
+func (a *liveApi) publishVotes(postId string) {
+	if !a.hub.hasSubscribers(postId) {
+		return
+	}
+
+	counts, err := a.db.GetVoteCounts(postId)
+	if err != nil {
+		log.Printf("Error counting votes for %v: %v\n", postId, err)
+		return
+	}
+
+	a.hub.publishVotes(postId, counts)
+}
+
+func (a *liveApi) LiveGet(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded to the client
+		log.Printf("Error upgrading connection: %v\n", err)
+		return
+	}
+
+	c := newLiveClient(u.Id)
+	a.hub.add(c)
+	go a.writeLive(conn, c)
+	defer func() {
+		a.hub.remove(c)
+		c.close()
+	}()
+
+	conn.SetReadLimit(liveMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(livePongWait))
+		return nil
+	})
+
+	for {
+		var req liveRequest
+		if err = conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Error reading live request: %v\n", err)
+			}
+			return
+		}
+
+		a.hub.unsubscribe(c, req.Unsubscribe)
+		if len(req.Subscribe) > maxLiveSubscriptions {
+			req.Subscribe = req.Subscribe[:maxLiveSubscriptions]
+		}
+
+		for _, postId := range a.hub.subscribe(c, a.visiblePosts(u.Id, req.Subscribe)) {
+			counts, err := a.db.GetVoteCounts(postId)
+			if err != nil {
+				log.Printf("Error counting votes for %v: %v\n", postId, err)
+				continue
+			}
+			c.queueVotes(postId, counts)
+		}
+	}
+}
+
+func (a *liveApi) writeLive(conn *websocket.Conn, c *liveClient) {
+	ticker := time.NewTicker(livePingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		var err error
+		select {
+		case m := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			err = conn.WriteJSON(m)
+		case <-c.votesReady:
+			for postId, counts := range c.takeVotes() {
+				counts := counts
+				conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+				if err = conn.WriteJSON(liveMessage{Type: votesMessage, PostId: postId, Votes: &counts}); err != nil {
+					break
+				}
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			err = conn.WriteMessage(websocket.PingMessage, nil)
+		case <-c.done:
+			c.mu.Lock()
+			slow := c.slow
+			c.mu.Unlock()
+			if slow {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Client is too slow"),
+					time.Now().Add(liveWriteWait))
+			}
+			return
+		}
+
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+}
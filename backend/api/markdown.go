@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"html"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday/v2"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+const markdownExtensions = blackfriday.NoIntraEmphasis |
+	blackfriday.FencedCode |
+	blackfriday.Autolink |
+	blackfriday.Strikethrough |
+	blackfriday.SpaceHeadings |
+	blackfriday.HardLineBreak
+
+var contentPolicy = newContentPolicy()
+
+// newContentPolicy allows the small set of tags that markdown produces for
+// user content, anything else (including scripts, styles, images and raw
+// html) is stripped
+func newContentPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "em", "strong", "del", "code", "pre",
+		"blockquote", "ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowStandardURLs()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+func validateFormat(format *string) error {
+	switch *format {
+	case "":
+		*format = models.PlainFormat
+	case models.PlainFormat, models.MarkdownFormat:
+	default:
+		return fmt.Errorf("Format must be %v or %v", models.PlainFormat, models.MarkdownFormat)
+	}
+	return nil
+}
+
+func renderContent(format, content string) string {
+	if format == models.MarkdownFormat {
+		unsafe := blackfriday.Run([]byte(content), blackfriday.WithExtensions(markdownExtensions))
+		return string(contentPolicy.SanitizeBytes(unsafe))
+	}
+	return html.EscapeString(content)
+}
+
+func renderPosts(posts []models.Post) {
+	for i := range posts {
+		posts[i].RenderedContent = renderContent(posts[i].Format, posts[i].Content)
+	}
+}
+
+func renderComments(comments []models.Comment) {
+	for i := range comments {
+		comments[i].RenderedContent = renderContent(comments[i].Format, comments[i].Content)
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestRenderContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		want    string
+	}{{
+		name:    "plain text is escaped",
+		format:  models.PlainFormat,
+		content: "<script>alert(1)</script>",
+		want:    "&lt;script&gt;alert(1)&lt;/script&gt;",
+	}, {
+		name:    "script",
+		format:  models.MarkdownFormat,
+		content: "<script>alert(1)</script>hi",
+		want:    "<p>hi</p>\n",
+	}, {
+		name:    "javascript link",
+		format:  models.MarkdownFormat,
+		content: "[x](javascript:void)",
+		want:    "<p>x</p>\n",
+	}, {
+		name:    "javascript anchor",
+		format:  models.MarkdownFormat,
+		content: `<a href="javascript:alert(1)">x</a>`,
+		want:    "<p>x</p>\n",
+	}, {
+		name:    "event handler",
+		format:  models.MarkdownFormat,
+		content: `<p onclick="alert(1)">x</p>`,
+		want:    "<p><p>x</p></p>\n",
+	}, {
+		name:    "image with event handler",
+		format:  models.MarkdownFormat,
+		content: `<img src=x onerror=alert(1)>`,
+		want:    "<p></p>\n",
+	}, {
+		name:    "raw html",
+		format:  models.MarkdownFormat,
+		content: "**b** <b>raw</b>",
+		want:    "<p><strong>b</strong> raw</p>\n",
+	}, {
+		name:    "external link",
+		format:  models.MarkdownFormat,
+		content: "[x](https://example.com)",
+		want:    `<p><a href="https://example.com" rel="nofollow noopener" target="_blank">x</a></p>` + "\n",
+	}, {
+		name:    "relative link",
+		format:  models.MarkdownFormat,
+		content: "[x](/local)",
+		want:    `<p><a href="/local" rel="nofollow">x</a></p>` + "\n",
+	}, {
+		name:    "autolink",
+		format:  models.MarkdownFormat,
+		content: "http://example.com",
+		want:    `<p><a href="http://example.com" rel="nofollow noopener" target="_blank">http://example.com</a></p>` + "\n",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := renderContent(test.format, test.content); got != test.want {
+				t.Errorf("Got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
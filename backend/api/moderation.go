@@ -0,0 +1,329 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	maxReportDetailsLength = 2000
+	defaultModerationLimit = 50
+	maxModerationLimit     = 500
+)
+
+func validReportReason(reason string) bool {
+	for _, r := range models.ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newModerationAction decodes the optional reason and report ID from the
+// request body, and fills in the rest of the action
+func newModerationAction(w http.ResponseWriter, r *http.Request, moderatorId, action string) (models.ModerationAction, bool) {
+	var a models.ModerationAction
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			apierror.Error(w, err.Error(), http.StatusBadRequest)
+			return a, false
+		}
+	}
+
+	return fillModerationAction(w, a, moderatorId, action)
+}
+
+func fillModerationAction(w http.ResponseWriter, a models.ModerationAction, moderatorId, action string) (models.ModerationAction, bool) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		return a, false
+	}
+
+	a.ActionId = id.String()
+	a.ModeratorId = moderatorId
+	a.Action = action
+	a.Time = time.Now()
+	return a, true
+}
+
+func parseModerationLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := defaultModerationLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxModerationLimit {
+			apierror.Error(w, "Query parameter 'limit' must be between 1 and "+strconv.Itoa(maxModerationLimit), http.StatusBadRequest)
+			return 0, false
+		}
+	}
+
+	return limit, true
+}
+
+type moderationApi struct {
+	db   database.DatabaseHandler
+	hub  *streamHub
+	live *liveHub
+}
+
+func (a *moderationApi) ReportPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.PostScope)
+	if !ok {
+		return
+	}
+
+	var report models.Report
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if report.TargetType != models.PostTarget && report.TargetType != models.CommentTarget && report.TargetType != models.UserTarget {
+		invalidField(w, "targetType", "Target type must be one of "+strings.Join([]string{
+			models.PostTarget, models.CommentTarget, models.UserTarget}, ", "))
+		return
+	}
+
+	if !validReportReason(report.Reason) {
+		invalidField(w, "reason", "Reason must be one of "+strings.Join(models.ReportReasons, ", "))
+		return
+	}
+
+	if len(report.Details) > maxReportDetailsLength {
+		invalidField(w, "details", "Details must be at most "+strconv.Itoa(maxReportDetailsLength)+" characters")
+		return
+	}
+
+	if report.TargetType == models.UserTarget && report.TargetId == u.Id {
+		apierror.Error(w, "Can't report yourself", http.StatusBadRequest)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		return
+	}
+	report.ReportId = id.String()
+	report.Id = u.Id
+	report.Status = models.ReportOpen
+	report.Time = time.Now()
+
+	if err = a.db.CreateReport(report); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *moderationApi) ModerationReportsGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(a.db, w, r, models.ModeratorRole); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReportOpen
+	} else if status != models.ReportOpen && status != models.ReportResolved && status != models.ReportDismissed {
+		apierror.Error(w, "Query parameter 'status' must be one of "+strings.Join([]string{
+			models.ReportOpen, models.ReportResolved, models.ReportDismissed}, ", "), http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := parseModerationLimit(w, r)
+	if !ok {
+		return
+	}
+
+	reports, err := a.db.GetReports(status, limit)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reports)
+}
+
+func (a *moderationApi) ModerationReportDismissPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.ModeratorRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	reportId, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a report ID to dismiss", http.StatusBadRequest)
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.DismissAction)
+	if !ok {
+		return
+	}
+	action.ReportId = reportId
+
+	if err := a.db.DismissReport(action); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *moderationApi) ModerationHidePost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.ModeratorRole)
+	if !ok {
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.HideAction)
+	if !ok {
+		return
+	}
+
+	if action.TargetType != models.PostTarget && action.TargetType != models.CommentTarget {
+		apierror.Error(w, "Target type must be one of "+strings.Join([]string{
+			models.PostTarget, models.CommentTarget}, ", "), http.StatusBadRequest)
+		return
+	}
+
+	if err := a.db.HideContent(action); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if action.TargetType == models.PostTarget {
+		removeRecommendsNode(action.TargetId)
+		a.hub.expirePost(action.TargetId)
+		a.live.dropPost(action.TargetId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *moderationApi) ModerationSuspendPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.ModeratorRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to suspend", http.StatusBadRequest)
+		return
+	}
+
+	if id == u.Id {
+		apierror.Error(w, "Can't suspend yourself", http.StatusBadRequest)
+		return
+	}
+
+	target, err := a.db.GetUserFromId(id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// Moderators can only suspend users ranked below them
+	if roleRanks[target.Role] >= roleRanks[u.Role] {
+		apierror.Error(w, "Can't suspend a user with the same or a higher role", http.StatusForbidden)
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.SuspendAction)
+	if !ok {
+		return
+	}
+	action.TargetType = models.UserTarget
+	action.TargetId = id
+
+	if err = a.db.SuspendUser(action); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// Suspending deletes the user's sessions, but connections that are
+	// already open have to be closed as well
+	a.hub.disconnect(id)
+	a.live.disconnect(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *moderationApi) ModerationUnsuspendPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(a.db, w, r, models.ModeratorRole)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a user ID to unsuspend", http.StatusBadRequest)
+		return
+	}
+
+	action, ok := newModerationAction(w, r, u.Id, models.UnsuspendAction)
+	if !ok {
+		return
+	}
+	action.TargetType = models.UserTarget
+	action.TargetId = id
+
+	if err := a.db.UnsuspendUser(action); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *moderationApi) ModerationActionsGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(a.db, w, r, models.ModeratorRole); !ok {
+		return
+	}
+
+	limit, ok := parseModerationLimit(w, r)
+	if !ok {
+		return
+	}
+
+	actions, err := a.db.GetModerationActions(limit)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(actions)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+// fakeModerationDb records the users that were suspended
+type fakeModerationDb struct {
+	*fakeFollowingDb
+	suspended []string
+}
+
+func (db *fakeModerationDb) SuspendUser(a models.ModerationAction) error {
+	db.suspended = append(db.suspended, a.TargetId)
+	return nil
+}
+
+func TestModerationSuspend(t *testing.T) {
+	tests := []struct {
+		name      string
+		actor     string
+		target    string
+		status    int
+		suspended bool
+	}{{
+		name:      "moderator suspends user",
+		actor:     "mod-id",
+		target:    "user-id",
+		status:    http.StatusOK,
+		suspended: true,
+	}, {
+		name:      "admin suspends moderator",
+		actor:     "admin-id",
+		target:    "other-mod-id",
+		status:    http.StatusOK,
+		suspended: true,
+	}, {
+		name:   "moderator suspends moderator",
+		actor:  "mod-id",
+		target: "other-mod-id",
+		status: http.StatusForbidden,
+	}, {
+		name:   "moderator suspends admin",
+		actor:  "mod-id",
+		target: "admin-id",
+		status: http.StatusForbidden,
+	}, {
+		name:   "user suspends user",
+		actor:  "user-id",
+		target: "other-user-id",
+		status: http.StatusForbidden,
+	}, {
+		name:   "suspend self",
+		actor:  "mod-id",
+		target: "mod-id",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "unknown user",
+		actor:  "mod-id",
+		target: "unknown-id",
+		status: http.StatusNotFound,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := &fakeModerationDb{fakeFollowingDb: newFakeFollowingDb(
+				models.User{Id: "user-id", Role: models.UserRole},
+				models.User{Id: "other-user-id", Role: models.UserRole},
+				models.User{Id: "mod-id", Role: models.ModeratorRole},
+				models.User{Id: "other-mod-id", Role: models.ModeratorRole},
+				models.User{Id: "admin-id", Role: models.AdminRole},
+			)}
+			a := &moderationApi{db: db, hub: newStreamHub(), live: newLiveHub()}
+
+			stream, err := a.hub.subscribe(test.target)
+			if err != nil {
+				t.Fatalf("Could not subscribe: %v", err)
+			}
+			live := newLiveClient(test.target)
+			a.live.add(live)
+
+			r := httptest.NewRequest("POST", "/moderation/suspend/"+test.target, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": test.target})
+			r.AddCookie(&http.Cookie{Name: sessionIdCookie, Value: "session-" + test.actor})
+
+			rec := httptest.NewRecorder()
+			a.ModerationSuspendPost(rec, r)
+
+			if rec.Code != test.status {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, test.status, rec.Body)
+			}
+			if suspended := len(db.suspended) > 0; suspended != test.suspended {
+				t.Errorf("Suspended is %v, want %v", suspended, test.suspended)
+			}
+
+			// The suspended user's open connections are closed
+			select {
+			case <-stream.dropped:
+				if !test.suspended {
+					t.Errorf("Stream was dropped without suspending")
+				}
+			default:
+				if test.suspended {
+					t.Errorf("Stream wasn't dropped")
+				}
+			}
+			select {
+			case <-live.done:
+				if !test.suspended {
+					t.Errorf("Live connection was closed without suspending")
+				}
+			default:
+				if test.suspended {
+					t.Errorf("Live connection wasn't closed")
+				}
+			}
+		})
+	}
+}
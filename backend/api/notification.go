@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	maxMentions              = 10
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\w@])@(\w+)`)
+
+// extractMentions returns the unique usernames mentioned in the given texts
+func extractMentions(texts ...string) []string {
+	usernames := []string{}
+	seen := map[string]bool{}
+	for _, text := range texts {
+		for _, match := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+			if seen[match[1]] {
+				continue
+			}
+
+			seen[match[1]] = true
+			usernames = append(usernames, match[1])
+			if len(usernames) == maxMentions {
+				return usernames
+			}
+		}
+	}
+
+	return usernames
+}
+
+// notifier creates notifications as a side effect of other actions, failures
+// are logged rather than failing the action that caused them
+type notifier struct {
+	db  database.DatabaseHandler
+	hub *streamHub
+}
+
+func (n *notifier) notify(notification models.Notification) {
+	if notification.Id == notification.ActorId {
+		return
+	}
+
+	blocked, err := n.db.IsBlocked(notification.Id, notification.ActorId)
+	if err != nil {
+		log.Printf("Error checking block for %v notification: %v\n", notification.Kind, err)
+		return
+	} else if blocked {
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		return
+	}
+	notification.NotificationId = id.String()
+	notification.Time = time.Now()
+
+	created, err := n.db.CreateNotification(notification)
+	if err != nil {
+		log.Printf("Error creating %v notification: %v\n", notification.Kind, err)
+		return
+	} else if !created {
+		return
+	}
+
+	n.hub.publish(notificationEvent, notification, notification.Id)
+}
+
+func (n *notifier) notifyMentions(actorId, postId, commentId string, texts ...string) {
+	for _, username := range extractMentions(texts...) {
+		u, err := n.db.GetUserFromUsername(username)
+		if err != nil {
+			if _, ok := err.(*database.NotFoundError); !ok {
+				log.Printf("Error resolving mention of %v: %v\n", username, err)
+			}
+			continue
+		}
+
+		n.notify(models.Notification{
+			Id:        u.Id,
+			ActorId:   actorId,
+			Kind:      models.MentionNotification,
+			PostId:    postId,
+			CommentId: commentId,
+		})
+	}
+}
+
+// notifyPostOwner notifies the creator of postId about an action on their post
+func (n *notifier) notifyPostOwner(kind, actorId, postId, commentId string) {
+	post, err := n.db.GetPost(postId)
+	if err != nil {
+		log.Printf("Error getting post %v to notify owner: %v\n", postId, err)
+		return
+	}
+
+	n.notify(models.Notification{
+		Id:        post.Id,
+		ActorId:   actorId,
+		Kind:      kind,
+		PostId:    postId,
+		CommentId: commentId,
+	})
+}
+
+type notificationApi struct {
+	db database.DatabaseHandler
+}
+
+func (a *notificationApi) NotificationsGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+
+	limit := defaultNotificationLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxNotificationLimit {
+			apierror.Error(w, "Query parameter 'limit' must be between 1 and "+strconv.Itoa(maxNotificationLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	unreadOnly := strings.ToLower(params.Get("unread")) == "true"
+
+	notifications, err := a.db.GetNotifications(u.Id, unreadOnly, limit)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(notifications)
+}
+
+func (a *notificationApi) NotificationsUnreadGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	count, err := a.db.GetUnreadNotificationCount(u.Id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.UnreadCount{Count: count})
+}
+
+func (a *notificationApi) NotificationsReadPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	// An empty body marks every notification as read
+	var read models.NotificationsRead
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&read); err != nil {
+			apierror.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := a.db.MarkNotificationsRead(u.Id, read.NotificationIds); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,541 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/backend/oidc"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	oidcStateCookie = "oidcState"
+	oidcLoginExpiry = 10 * time.Minute
+	// How long signing in with the identity provider again stands in for the
+	// password of a user who doesn't have one
+	oidcReauthWindow = 5 * time.Minute
+
+	maxUsernameAttempts = 5
+)
+
+var nonWordRegexp = regexp.MustCompile(`\W+`)
+
+func getOidcState(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return "", err
+	}
+
+	return cookie.Value, nil
+}
+
+func storeOidcStateCookie(w http.ResponseWriter, state string, expiry time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		Value:    state,
+		Expires:  expiry,
+		HttpOnly: true,
+		Domain:   "",
+	})
+}
+
+func deleteOidcStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Domain:   "",
+	})
+}
+
+// requireIdp writes an error response and returns false if no identity
+// provider is configured
+func (a *userApi) requireIdp(w http.ResponseWriter) bool {
+	if a.idp == nil {
+		apierror.Error(w, "Identity provider login is not configured", http.StatusNotFound)
+		return false
+	}
+
+	return true
+}
+
+// startOidcLogin sends the user to the identity provider, linkId is the user
+// to link the identity to or empty to log in with it. If reauth is set the
+// linked user is confirming who they are instead
+func (a *userApi) startOidcLogin(w http.ResponseWriter, r *http.Request, linkId string, reauth bool) {
+	state, stateHash, err := generateToken()
+	if err != nil {
+		log.Printf("Error generating oidc state: %v\n", err.Error())
+		apierror.Error(w, "Error generating oidc state", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, _, err := generateToken()
+	if err != nil {
+		log.Printf("Error generating oidc nonce: %v\n", err.Error())
+		apierror.Error(w, "Error generating oidc nonce", http.StatusInternalServerError)
+		return
+	}
+
+	verifier, challenge, err := oidc.NewVerifier()
+	if err != nil {
+		log.Printf("Error generating pkce verifier: %v\n", err.Error())
+		apierror.Error(w, "Error generating pkce verifier", http.StatusInternalServerError)
+		return
+	}
+
+	authUrl, err := a.idp.AuthCodeUrl(state, nonce, challenge)
+	if err != nil {
+		log.Printf("Error building oidc authorization url: %v\n", err)
+		apierror.Error(w, "Could not reach the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	expiry := time.Now().Add(oidcLoginExpiry)
+	err = a.db.CreateOidcLogin(models.OidcLogin{
+		StateHash: stateHash,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		LinkId:    linkId,
+		Reauth:    reauth,
+		Expiry:    expiry,
+	})
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// The state is also kept in a cookie so that the callback only works in
+	// the browser that started the login
+	storeOidcStateCookie(w, state, expiry)
+	http.Redirect(w, r, authUrl, http.StatusFound)
+}
+
+func (a *userApi) OidcLoginGet(w http.ResponseWriter, r *http.Request) {
+	if !a.requireIdp(w) {
+		return
+	}
+
+	a.startOidcLogin(w, r, "", false)
+}
+
+func (a *userApi) OidcLinkGet(w http.ResponseWriter, r *http.Request) {
+	if !a.requireIdp(w) {
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	a.startOidcLogin(w, r, u.Id, false)
+}
+
+// OidcReauthGet has a logged in user sign in with their identity provider
+// again, which users without a password do to confirm sensitive changes
+func (a *userApi) OidcReauthGet(w http.ResponseWriter, r *http.Request) {
+	if !a.requireIdp(w) {
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	a.startOidcLogin(w, r, u.Id, true)
+}
+
+// OidcCallbackGet is where the identity provider sends the user back to, it
+// links the identity or logs the user in with it, creating a new user the
+// first time an identity is seen
+func (a *userApi) OidcCallbackGet(w http.ResponseWriter, r *http.Request) {
+	if !a.requireIdp(w) {
+		return
+	}
+
+	params := r.URL.Query()
+	if e := params.Get("error"); e != "" {
+		deleteOidcStateCookie(w)
+		apierror.Error(w, "Identity provider login failed: "+e, http.StatusBadRequest)
+		return
+	}
+
+	state := params.Get("state")
+	cookieState, err := getOidcState(r)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		apierror.Error(w, "Login state does not match", http.StatusBadRequest)
+		return
+	}
+	deleteOidcStateCookie(w)
+
+	login, err := a.db.UseOidcLogin(hashToken(state))
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			apierror.Error(w, "Login has expired, please try again", http.StatusBadRequest)
+			return
+		}
+		handleDbErr(err, w)
+		return
+	}
+
+	claims, err := a.idp.Exchange(params.Get("code"), login.Verifier, login.Nonce)
+	if err != nil {
+		log.Printf("Error completing oidc login: %v\n", err)
+		apierror.Error(w, "Could not verify the identity provider login", http.StatusUnauthorized)
+		return
+	}
+
+	identity := models.Identity{
+		Provider: a.idp.Name(),
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		Time:     time.Now(),
+	}
+
+	if login.Reauth {
+		a.completeOidcReauth(w, r, login.LinkId, identity)
+		return
+	}
+
+	if login.LinkId != "" {
+		identity.Id = login.LinkId
+		if err = a.db.CreateIdentity(identity); err != nil {
+			if _, ok := err.(*database.UniquenessViolation); ok {
+				apierror.Error(w, "That identity is already linked to an account", http.StatusConflict)
+				return
+			}
+			handleDbErr(err, w)
+			return
+		}
+
+		http.Redirect(w, r, a.appUrl+"/settings", http.StatusFound)
+		return
+	}
+
+	u, err := a.db.GetUserFromIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		a.completeOidcLogin(w, r, u)
+		return
+	} else if _, ok := err.(*database.NotFoundError); !ok {
+		handleDbErr(err, w)
+		return
+	}
+
+	a.createIdentityUser(w, r, identity, claims)
+}
+
+func (a *userApi) completeOidcLogin(w http.ResponseWriter, r *http.Request, u models.User) {
+	if u.Suspended {
+		apierror.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	// The identity provider replaces the password, not the second factor
+	if u.TwoFactor {
+		if a.createPendingLogin(w, u) {
+			http.Redirect(w, r, a.appUrl+"/?twoFactor=true", http.StatusFound)
+		}
+		return
+	}
+
+	if !a.cancelDeletion(w, u) {
+		return
+	}
+
+	s, err := generateSession(u.Id)
+	if err != nil {
+		log.Printf("Error generating session ID: %v\n", err.Error())
+		apierror.Error(w, "Error generating session ID", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.CreateSession(s); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+	storeSessionCookie(w, s)
+
+	http.Redirect(w, r, a.appUrl+"/", http.StatusFound)
+}
+
+// completeOidcReauth marks the session as reauthenticated if the identity is
+// linked to the user who started the reauthentication, and is still who the
+// session belongs to
+func (a *userApi) completeOidcReauth(w http.ResponseWriter, r *http.Request, id string, identity models.Identity) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	linked, err := a.db.GetUserFromIdentity(identity.Provider, identity.Subject)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); !ok {
+			handleDbErr(err, w)
+			return
+		}
+	}
+
+	if u.Id != id || linked.Id != id {
+		apierror.Error(w, "That identity isn't linked to your account", http.StatusUnauthorized)
+		return
+	}
+
+	if err = a.db.ReauthenticateSession(sessionId); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	http.Redirect(w, r, a.appUrl+"/settings?reauthenticated=true", http.StatusFound)
+}
+
+// confirmPassword writes an error response and returns false unless password
+// is the user's. Users who signed up through an identity provider don't have
+// one, so they confirm who they are by signing in with it again instead
+func (a *userApi) confirmPassword(w http.ResponseWriter, r *http.Request, u models.User, password string) bool {
+	if u.Password != "" {
+		if !passwordMatches(u.Password, password) {
+			apierror.Error(w, "Password does not match", http.StatusUnauthorized)
+			return false
+		}
+
+		return true
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return false
+	}
+
+	reauthenticated, err := a.db.SessionReauthenticated(sessionId, time.Now().Add(-oidcReauthWindow))
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	if !reauthenticated {
+		apierror.Error(w, "Must sign in with your identity provider again to confirm", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func (a *userApi) createIdentityUser(w http.ResponseWriter, r *http.Request, identity models.Identity, claims *oidc.Claims) {
+	if claims.Email == "" {
+		apierror.Error(w, "The identity provider didn't share an email", http.StatusBadRequest)
+		return
+	}
+
+	// Linking to an existing account by email would let anyone who controls
+	// an identity provider take over accounts, so the owner has to log in and
+	// link the identity themselves
+	if _, err := a.db.GetUserFromEmail(claims.Email); err == nil {
+		apierror.Error(w, "An account already uses this email, log in to link this identity to it", http.StatusConflict)
+		return
+	} else if _, ok := err.(*database.NotFoundError); !ok {
+		handleDbErr(err, w)
+		return
+	}
+
+	username, err := a.identityUsername(claims)
+	if err != nil {
+		log.Printf("Error choosing username for new identity: %v\n", err)
+		apierror.Error(w, "Could not choose a username", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		return
+	}
+
+	// The empty password never matches, the user can set one by resetting it
+	u := models.User{
+		Id:             id.String(),
+		Identification: models.Identification{Username: username},
+		Email:          claims.Email,
+		Verified:       claims.EmailVerified,
+	}
+
+	s, err := generateSession(u.Id)
+	if err != nil {
+		log.Printf("Error generating session ID: %v\n", err.Error())
+		apierror.Error(w, "Error generating session ID", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.CreateIdentityUser(u, identity, s); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+	storeSessionCookie(w, s)
+
+	if !u.Verified {
+		if err = a.sendVerification(u, u.Email); err != nil {
+			log.Printf("Error sending verification email: %v\n", err)
+		}
+	}
+
+	addRecommendsNode(&nodeResource{
+		Id:        u.Id,
+		Type:      userNode,
+		Timestamp: time.Now(),
+	})
+
+	http.Redirect(w, r, a.appUrl+"/", http.StatusFound)
+}
+
+// identityUsername picks an available username for a new user based on what
+// the identity provider calls them
+func (a *userApi) identityUsername(claims *oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	base = nonWordRegexp.ReplaceAllString(base, "_")
+	if len(base) > 27 {
+		base = base[:27]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	username := base
+	for i := 0; i < maxUsernameAttempts; i++ {
+		if !reservedUsernames[strings.ToLower(username)] {
+			_, err := a.db.GetUserFromUsername(username)
+			if _, ok := err.(*database.NotFoundError); ok {
+				return username, nil
+			} else if err != nil {
+				return "", err
+			}
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		username = fmt.Sprintf("%v%04d", base, n)
+	}
+
+	return "", errors.New("Could not find an available username")
+}
+
+func (a *userApi) UserIdentitiesGet(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	identities, err := a.db.GetIdentities(u.Id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+func (a *userApi) UserIdentityDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	provider, ok := vars["provider"]
+	if !ok {
+		apierror.Error(w, "Must provide a provider", http.StatusBadRequest)
+		return
+	}
+
+	subject, ok := vars["subject"]
+	if !ok {
+		apierror.Error(w, "Must provide a subject", http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	// Users who signed up through an identity provider have no password, so
+	// unlinking their last identity would lock them out
+	if u.Password == "" {
+		identities, err := a.db.GetIdentities(u.Id)
+		if err != nil {
+			handleDbErr(err, w)
+			return
+		}
+
+		if len(identities) <= 1 {
+			apierror.Error(w, "Must set a password before unlinking your last identity", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = a.db.DeleteIdentity(u.Id, provider, subject); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
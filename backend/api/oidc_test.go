@@ -0,0 +1,762 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/mail"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/backend/oidc"
+	"github.com/jbrunsting/transient/backend/oidc/devprovider"
+)
+
+const (
+	testIssuer   = "http://idp.test/oidc-dev"
+	testAppUrl   = "http://app.test"
+	testClientId = "transient"
+)
+
+// fakeOidcDb keeps the state the login flows touch in memory, any other
+// method panics through the nil embedded handler
+type fakeOidcDb struct {
+	database.DatabaseHandler
+
+	mu              sync.Mutex
+	users           map[string]models.User
+	sessions        map[string]string
+	reauthenticated map[string]time.Time
+	logins          map[string]models.OidcLogin
+	identities      map[string]models.Identity
+	pendingLogins   map[string]string
+	usedSteps       map[int64]bool
+	deletions       map[string]time.Time
+}
+
+func newFakeOidcDb() *fakeOidcDb {
+	return &fakeOidcDb{
+		users:           map[string]models.User{},
+		sessions:        map[string]string{},
+		reauthenticated: map[string]time.Time{},
+		logins:          map[string]models.OidcLogin{},
+		identities:      map[string]models.Identity{},
+		pendingLogins:   map[string]string{},
+		usedSteps:       map[int64]bool{},
+		deletions:       map[string]time.Time{},
+	}
+}
+
+func (db *fakeOidcDb) findUser(match func(u models.User) bool) (models.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, u := range db.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return models.User{}, &database.NotFoundError{Object: "user"}
+}
+
+func (db *fakeOidcDb) GetUserFromId(id string) (models.User, error) {
+	return db.findUser(func(u models.User) bool { return u.Id == id })
+}
+
+func (db *fakeOidcDb) GetUserFromUsername(username string) (models.User, error) {
+	return db.findUser(func(u models.User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+func (db *fakeOidcDb) GetUserFromEmail(email string) (models.User, error) {
+	return db.findUser(func(u models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (db *fakeOidcDb) GetUserFromSession(sessionId string) (models.User, error) {
+	db.mu.Lock()
+	id, ok := db.sessions[sessionId]
+	db.mu.Unlock()
+	if !ok {
+		return models.User{}, &database.NotFoundError{Object: "user"}
+	}
+	return db.GetUserFromId(id)
+}
+
+func (db *fakeOidcDb) GetUserFromIdentity(provider, subject string) (models.User, error) {
+	db.mu.Lock()
+	i, ok := db.identities[provider+"/"+subject]
+	db.mu.Unlock()
+	if !ok {
+		return models.User{}, &database.NotFoundError{Object: "user"}
+	}
+	return db.GetUserFromId(i.Id)
+}
+
+func (db *fakeOidcDb) CreateSession(s models.Session) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.sessions[s.SessionId] = s.Id
+	return nil
+}
+
+func (db *fakeOidcDb) CreateOidcLogin(l models.OidcLogin) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.logins[l.StateHash] = l
+	return nil
+}
+
+func (db *fakeOidcDb) UseOidcLogin(stateHash string) (models.OidcLogin, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	l, ok := db.logins[stateHash]
+	delete(db.logins, stateHash)
+	if !ok || time.Now().After(l.Expiry) {
+		return models.OidcLogin{}, &database.NotFoundError{Object: "login"}
+	}
+	return l, nil
+}
+
+// updateLogins changes the stored logins, to simulate a different browser
+// or a tampered login finishing the flow
+func (db *fakeOidcDb) updateLogins(update func(l *models.OidcLogin)) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for hash, l := range db.logins {
+		update(&l)
+		db.logins[hash] = l
+	}
+}
+
+func (db *fakeOidcDb) ReauthenticateSession(sessionId string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.sessions[sessionId]; !ok {
+		return &database.NotFoundError{Object: "session"}
+	}
+	db.reauthenticated[sessionId] = time.Now()
+	return nil
+}
+
+func (db *fakeOidcDb) SessionReauthenticated(sessionId string, since time.Time) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	t, ok := db.reauthenticated[sessionId]
+	return ok && t.After(since), nil
+}
+
+func (db *fakeOidcDb) CreateIdentity(i models.Identity) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := i.Provider + "/" + i.Subject
+	if _, ok := db.identities[key]; ok {
+		return &database.UniquenessViolation{Object: "identity"}
+	}
+	db.identities[key] = i
+	return nil
+}
+
+func (db *fakeOidcDb) CreateIdentityUser(u models.User, i models.Identity, s models.Session) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	i.Id = u.Id
+	db.users[u.Id] = u
+	db.identities[i.Provider+"/"+i.Subject] = i
+	db.sessions[s.SessionId] = u.Id
+	return nil
+}
+
+func (db *fakeOidcDb) GetIdentities(id string) ([]models.Identity, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	identities := []models.Identity{}
+	for _, i := range db.identities {
+		if i.Id == id {
+			identities = append(identities, i)
+		}
+	}
+	return identities, nil
+}
+
+func (db *fakeOidcDb) DeleteIdentity(id, provider, subject string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	key := provider + "/" + subject
+	if i, ok := db.identities[key]; !ok || i.Id != id {
+		return &database.NotFoundError{Object: "identity"}
+	}
+	delete(db.identities, key)
+	return nil
+}
+
+func (db *fakeOidcDb) CreatePendingLogin(id, tokenHash string, expiry time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.pendingLogins[tokenHash] = id
+	return nil
+}
+
+func (db *fakeOidcDb) GetPendingLogin(tokenHash string, maxAttempts int) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id, ok := db.pendingLogins[tokenHash]
+	if !ok {
+		return "", &database.NotFoundError{Object: "login"}
+	}
+	return id, nil
+}
+
+func (db *fakeOidcDb) FailPendingLogin(tokenHash string) error {
+	return nil
+}
+
+func (db *fakeOidcDb) CompletePendingLogin(tokenHash string, s models.Session) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.pendingLogins, tokenHash)
+	db.sessions[s.SessionId] = s.Id
+	return nil
+}
+
+func (db *fakeOidcDb) UseTotpStep(id string, step int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.usedSteps[step] {
+		return &database.NotFoundError{Object: "totp step"}
+	}
+	db.usedSteps[step] = true
+	return nil
+}
+
+func (db *fakeOidcDb) UseRecoveryCode(id, codeHash string) error {
+	return &database.NotFoundError{Object: "recovery code"}
+}
+
+func (db *fakeOidcDb) DisableTotp(id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u := db.users[id]
+	u.TwoFactor = false
+	u.TotpSecret = ""
+	db.users[id] = u
+	return nil
+}
+
+func (db *fakeOidcDb) ScheduleUserDeletion(id string, purgeTime time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.deletions[id] = purgeTime
+	return nil
+}
+
+// keyOverride serves its own key set in place of the provider's, so that
+// tokens signed by the provider fail verification
+type keyOverride struct {
+	provider http.RoundTripper
+	keys     oidc.KeySet
+}
+
+func (k keyOverride) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(r.URL.Path, "/jwks") {
+		return k.provider.RoundTrip(r)
+	}
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rec).Encode(k.keys)
+	return rec.Result(), nil
+}
+
+type oidcTest struct {
+	t   *testing.T
+	db  *fakeOidcDb
+	api *userApi
+	// Talks to the provider in process without following the redirect back
+	// to the app
+	browser *http.Client
+}
+
+// newOidcTest runs the flows against a development provider, which signs
+// tokens that are checked against keys if they are set
+func newOidcTest(t *testing.T, keys *oidc.KeySet) *oidcTest {
+	config := oidc.Config{
+		Name:        "dev",
+		Issuer:      testIssuer,
+		ClientId:    testClientId,
+		RedirectUrl: testAppUrl + "/api/oidc/callback",
+	}
+
+	dev, err := devprovider.New(config)
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	var transport http.RoundTripper = dev
+	if keys != nil {
+		transport = keyOverride{provider: dev, keys: *keys}
+	}
+	config.Client = &http.Client{Transport: transport}
+
+	db := newFakeOidcDb()
+	return &oidcTest{
+		t:  t,
+		db: db,
+		api: &userApi{
+			db:     db,
+			mailer: mail.NewLogMailer("test@example.com"),
+			idp:    oidc.NewProvider(config),
+			appUrl: testAppUrl,
+		},
+		browser: &http.Client{
+			Transport: dev,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// addUser stores a user with a session and the identity the provider gives
+// username, and returns the session
+func (o *oidcTest) addUser(u models.User, username string) *http.Cookie {
+	o.db.users[u.Id] = u
+	if username != "" {
+		o.db.identities["dev/dev-"+username] = models.Identity{
+			Provider: "dev",
+			Subject:  "dev-" + username,
+			Id:       u.Id,
+		}
+	}
+
+	sessionId := "session-" + u.Id
+	o.db.sessions[sessionId] = u.Id
+	return &http.Cookie{Name: sessionIdCookie, Value: sessionId}
+}
+
+func cookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// start calls the handler that begins a flow, and returns the provider's
+// authorization url along with the state cookie
+func (o *oidcTest) start(handler http.HandlerFunc, cookies ...*http.Cookie) (string, *http.Cookie) {
+	r := httptest.NewRequest("GET", testAppUrl+"/api/oidc/login", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("Starting login got status %v: %v", rec.Code, rec.Body)
+	}
+
+	state := cookie(rec, oidcStateCookie)
+	if state == nil {
+		o.t.Fatal("Starting login didn't set the state cookie")
+	}
+
+	return rec.Header().Get("Location"), state
+}
+
+// authorize logs in to the provider as username, and returns the url the
+// provider redirects back to
+func (o *oidcTest) authorize(authUrl, username string) string {
+	resp, err := o.browser.PostForm(authUrl, url.Values{"username": {username}})
+	if err != nil {
+		o.t.Fatalf("Error authorizing: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		o.t.Fatalf("Authorizing got status %v", resp.StatusCode)
+	}
+
+	return resp.Header.Get("Location")
+}
+
+func (o *oidcTest) callback(callbackUrl string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", callbackUrl, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	rec := httptest.NewRecorder()
+	o.api.OidcCallbackGet(rec, r)
+	return rec
+}
+
+// login runs the whole login flow as username
+func (o *oidcTest) login(username string) *httptest.ResponseRecorder {
+	authUrl, state := o.start(o.api.OidcLoginGet)
+	return o.callback(o.authorize(authUrl, username), state)
+}
+
+func (o *oidcTest) reauth(session *http.Cookie, username string) *httptest.ResponseRecorder {
+	authUrl, state := o.start(o.api.OidcReauthGet, session)
+	return o.callback(o.authorize(authUrl, username), session, state)
+}
+
+func post(handler http.HandlerFunc, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	r := httptest.NewRequest("POST", testAppUrl+"/api/user", strings.NewReader(string(b)))
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+func TestOidcLoginCreatesUser(t *testing.T) {
+	o := newOidcTest(t, nil)
+
+	rec := o.login("alice")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != testAppUrl+"/" {
+		t.Fatalf("Got status %v to %v: %v", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	session := cookie(rec, sessionIdCookie)
+	if session == nil {
+		t.Fatal("Login didn't set a session cookie")
+	}
+
+	u, err := o.db.GetUserFromSession(session.Value)
+	if err != nil {
+		t.Fatalf("Session isn't stored: %v", err)
+	}
+	if u.Username != "alice" || u.Email != "alice@example.com" || u.Password != "" {
+		t.Errorf("Created user %+v", u)
+	}
+
+	if linked, err := o.db.GetUserFromIdentity("dev", "dev-alice"); err != nil || linked.Id != u.Id {
+		t.Errorf("Identity isn't linked to the new user: %v", err)
+	}
+}
+
+func TestOidcLoginExistingUser(t *testing.T) {
+	o := newOidcTest(t, nil)
+	o.addUser(models.User{Id: "bob-id", Identification: models.Identification{Username: "bob"}}, "bob")
+
+	rec := o.login("bob")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Got status %v: %v", rec.Code, rec.Body)
+	}
+
+	session := cookie(rec, sessionIdCookie)
+	if session == nil {
+		t.Fatal("Login didn't set a session cookie")
+	}
+	if u, _ := o.db.GetUserFromSession(session.Value); u.Id != "bob-id" {
+		t.Errorf("Logged in as %v, want bob-id", u.Id)
+	}
+	if len(o.db.users) != 1 {
+		t.Errorf("Got %v users, want 1", len(o.db.users))
+	}
+}
+
+func TestOidcLoginFailures(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// keys replaces the provider's signing keys
+		keys *oidc.KeySet
+		// tamper changes the login before the callback
+		tamper func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie)
+		status int
+	}{{
+		name: "state cookie missing",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			return callbackUrl, &http.Cookie{Name: "other", Value: state.Value}
+		},
+		status: http.StatusBadRequest,
+	}, {
+		name: "state doesn't match cookie",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			return callbackUrl, &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+		},
+		status: http.StatusBadRequest,
+	}, {
+		name: "state wasn't issued",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			u, _ := url.Parse(callbackUrl)
+			q := u.Query()
+			q.Set("state", "forged")
+			u.RawQuery = q.Encode()
+			return u.String(), &http.Cookie{Name: oidcStateCookie, Value: "forged"}
+		},
+		status: http.StatusBadRequest,
+	}, {
+		name: "pkce verifier mismatch",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			o.db.updateLogins(func(l *models.OidcLogin) {
+				l.Verifier, _, _ = oidc.NewVerifier()
+			})
+			return callbackUrl, state
+		},
+		status: http.StatusUnauthorized,
+	}, {
+		name: "nonce mismatch",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			o.db.updateLogins(func(l *models.OidcLogin) {
+				l.Nonce = "other"
+			})
+			return callbackUrl, state
+		},
+		status: http.StatusUnauthorized,
+	}, {
+		name:   "bad signature",
+		keys:   &oidc.KeySet{Keys: []oidc.Jwk{oidc.NewJwk("dev", &otherKey.PublicKey)}},
+		status: http.StatusUnauthorized,
+	}, {
+		name:   "unknown key id",
+		keys:   &oidc.KeySet{Keys: []oidc.Jwk{oidc.NewJwk("other", &otherKey.PublicKey)}},
+		status: http.StatusUnauthorized,
+	}, {
+		name: "provider error",
+		tamper: func(o *oidcTest, callbackUrl string, state *http.Cookie) (string, *http.Cookie) {
+			return testAppUrl + "/api/oidc/callback?error=access_denied&state=" + url.QueryEscape(state.Value), state
+		},
+		status: http.StatusBadRequest,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newOidcTest(t, test.keys)
+
+			authUrl, state := o.start(o.api.OidcLoginGet)
+			callbackUrl := o.authorize(authUrl, "alice")
+			if test.tamper != nil {
+				callbackUrl, state = test.tamper(o, callbackUrl, state)
+			}
+
+			rec := o.callback(callbackUrl, state)
+			if rec.Code != test.status {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, test.status, rec.Body)
+			}
+			if cookie(rec, sessionIdCookie) != nil {
+				t.Error("Failed login set a session cookie")
+			}
+			if len(o.db.users) != 0 {
+				t.Errorf("Failed login created %v users", len(o.db.users))
+			}
+		})
+	}
+}
+
+func TestOidcLoginTwoFactor(t *testing.T) {
+	o := newOidcTest(t, nil)
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatalf("Error generating secret: %v", err)
+	}
+	o.addUser(models.User{
+		Id:             "carol-id",
+		Identification: models.Identification{Username: "carol"},
+		TwoFactor:      true,
+		TotpSecret:     secret,
+	}, "carol")
+
+	rec := o.login("carol")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != testAppUrl+"/?twoFactor=true" {
+		t.Fatalf("Got status %v to %v: %v", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	if cookie(rec, sessionIdCookie) != nil {
+		t.Fatal("Login set a session cookie before the second factor")
+	}
+
+	pending := cookie(rec, pendingLoginCookie)
+	if pending == nil {
+		t.Fatal("Login didn't set a pending login cookie")
+	}
+
+	rec = post(o.api.UserLoginTwoFactorPost, models.TwoFactorCode{Code: "000000x"}, pending)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong code got status %v: %v", rec.Code, rec.Body)
+	}
+
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	rec = post(o.api.UserLoginTwoFactorPost, models.TwoFactorCode{Code: code}, pending)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %v: %v", rec.Code, rec.Body)
+	}
+
+	session := cookie(rec, sessionIdCookie)
+	if session == nil {
+		t.Fatal("Completing the login didn't set a session cookie")
+	}
+	if u, _ := o.db.GetUserFromSession(session.Value); u.Id != "carol-id" {
+		t.Errorf("Logged in as %v, want carol-id", u.Id)
+	}
+}
+
+func TestOidcLink(t *testing.T) {
+	o := newOidcTest(t, nil)
+	session := o.addUser(models.User{Id: "dave-id", Identification: models.Identification{Username: "dave"}}, "")
+
+	authUrl, state := o.start(o.api.OidcLinkGet, session)
+	rec := o.callback(o.authorize(authUrl, "dave_sso"), session, state)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != testAppUrl+"/settings" {
+		t.Fatalf("Got status %v to %v: %v", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	if u, err := o.db.GetUserFromIdentity("dev", "dev-dave_sso"); err != nil || u.Id != "dave-id" {
+		t.Errorf("Identity isn't linked: %v", err)
+	}
+
+	// Linking the same identity again, even to the same user, is refused
+	authUrl, state = o.start(o.api.OidcLinkGet, session)
+	rec = o.callback(o.authorize(authUrl, "dave_sso"), session, state)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Linking twice got status %v: %v", rec.Code, rec.Body)
+	}
+}
+
+func TestOidcLinkRequiresSession(t *testing.T) {
+	o := newOidcTest(t, nil)
+
+	rec := httptest.NewRecorder()
+	o.api.OidcLinkGet(rec, httptest.NewRequest("GET", testAppUrl+"/api/oidc/link", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Got status %v: %v", rec.Code, rec.Body)
+	}
+}
+
+func TestOidcUnlink(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		identities []string
+		status     int
+	}{
+		{"password user", "hash", []string{"erin"}, http.StatusOK},
+		{"last identity without password", "", []string{"erin"}, http.StatusBadRequest},
+		{"other identity without password", "", []string{"erin", "erin2"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newOidcTest(t, nil)
+			session := o.addUser(models.User{
+				Id:             "erin-id",
+				Identification: models.Identification{Username: "erin", Password: test.password},
+			}, "")
+			for _, username := range test.identities {
+				o.db.identities["dev/dev-"+username] = models.Identity{Provider: "dev", Subject: "dev-" + username, Id: "erin-id"}
+			}
+
+			r := httptest.NewRequest("DELETE", testAppUrl+"/api/user/identities/dev/dev-erin", nil)
+			r = mux.SetURLVars(r, map[string]string{"provider": "dev", "subject": "dev-erin"})
+			r.AddCookie(session)
+			rec := httptest.NewRecorder()
+			o.api.UserIdentityDelete(rec, r)
+			if rec.Code != test.status {
+				t.Errorf("Got status %v, want %v: %v", rec.Code, test.status, rec.Body)
+			}
+
+			_, err := o.db.GetUserFromIdentity("dev", "dev-erin")
+			if removed := err != nil; removed != (test.status == http.StatusOK) {
+				t.Errorf("Identity removed is %v", removed)
+			}
+		})
+	}
+}
+
+func TestOidcReauthConfirmsPasswordlessUser(t *testing.T) {
+	o := newOidcTest(t, nil)
+	session := o.addUser(models.User{Id: "frank-id", Identification: models.Identification{Username: "frank"}}, "frank")
+
+	// Without a password there is nothing to confirm with until they sign in
+	// with the provider again
+	rec := post(o.api.UserDeletePost, models.Confirmation{}, session)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Delete without reauth got status %v: %v", rec.Code, rec.Body)
+	}
+
+	// Someone else's identity doesn't count
+	o.addUser(models.User{Id: "grace-id", Identification: models.Identification{Username: "grace"}}, "grace")
+	rec = o.reauth(session, "grace")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Reauth as another user got status %v: %v", rec.Code, rec.Body)
+	}
+
+	rec = o.reauth(session, "frank")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != testAppUrl+"/settings?reauthenticated=true" {
+		t.Fatalf("Reauth got status %v to %v: %v", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+
+	rec = post(o.api.UserDeletePost, models.Confirmation{}, session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Delete after reauth got status %v: %v", rec.Code, rec.Body)
+	}
+	if _, ok := o.db.deletions["frank-id"]; !ok {
+		t.Error("Deletion wasn't scheduled")
+	}
+}
+
+func TestOidcReauthDisablesTwoFactor(t *testing.T) {
+	o := newOidcTest(t, nil)
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatalf("Error generating secret: %v", err)
+	}
+	session := o.addUser(models.User{
+		Id:             "heidi-id",
+		Identification: models.Identification{Username: "heidi"},
+		TwoFactor:      true,
+		TotpSecret:     secret,
+	}, "heidi")
+
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+
+	rec := post(o.api.UserTwoFactorDisablePost, models.Confirmation{Code: code}, session)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Disable without reauth got status %v: %v", rec.Code, rec.Body)
+	}
+
+	if rec = o.reauth(session, "heidi"); rec.Code != http.StatusFound {
+		t.Fatalf("Reauth got status %v: %v", rec.Code, rec.Body)
+	}
+
+	rec = post(o.api.UserTwoFactorDisablePost, models.Confirmation{Code: code}, session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Disable after reauth got status %v: %v", rec.Code, rec.Body)
+	}
+	if u, _ := o.db.GetUserFromId("heidi-id"); u.TwoFactor {
+		t.Error("Two factor is still enabled")
+	}
+}
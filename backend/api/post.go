@@ -1,10 +1,14 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -12,10 +16,27 @@ import (
 
 	"github.com/jbrunsting/transient/backend/database"
 	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+type searchCursor struct {
+	Rank   float32 `json:"r"`
+	PostId string  `json:"p"`
+}
+
 type postApi struct {
-	db database.DatabaseHandler
+	db       database.DatabaseHandler
+	previews *previewFetcher
+	notifier *notifier
+	hub      *streamHub
+	live     *liveApi
+
+	restrictions restrictions
 }
 
 func (a *postApi) PostsGet(w http.ResponseWriter, r *http.Request) {
@@ -23,38 +44,123 @@ func (a *postApi) PostsGet(w http.ResponseWriter, r *http.Request) {
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a user ID to get the posts for", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a user ID to get the posts for", http.StatusBadRequest)
 		return
 	}
 
-	posts, err := a.db.GetUserPosts(id)
+	posts, err := a.db.GetUserPosts(id, getViewerId(a.db, r))
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
+	renderPosts(posts)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(posts)
 }
 
-func (a *postApi) PostPost(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
+func encodeSearchCursor(result models.SearchResult) string {
+	b, _ := json.Marshal(searchCursor{Rank: result.Rank, PostId: result.PostId})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSearchCursor(cursor string) (*models.SearchResult, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return nil, err
+	}
+
+	var c searchCursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+
+	var result models.SearchResult
+	result.Rank = c.Rank
+	result.PostId = c.PostId
+	return &result, nil
+}
+
+// highlightSnippet escapes a snippet returned by the database and replaces the
+// highlight markers around matched terms with mark tags
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.Replace(snippet, database.HighlightStart, "<mark>", -1)
+	return strings.Replace(snippet, database.HighlightStop, "</mark>", -1)
+}
+
+func (a *postApi) PostsSearchGet(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	q := strings.TrimSpace(params.Get("q"))
+	if q == "" {
+		apierror.Error(w, "Query parameter 'q' required", http.StatusBadRequest)
 		return
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	limit := defaultSearchLimit
+	if l := params.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			apierror.Error(w, fmt.Sprintf("Query parameter 'limit' must be between 1 and %v", maxSearchLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var after *models.SearchResult
+	if cursor := params.Get("cursor"); cursor != "" {
+		var err error
+		after, err = decodeSearchCursor(cursor)
+		if err != nil {
+			apierror.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := a.db.SearchPosts(q, getViewerId(a.db, r), after, limit)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
+	for i := range results {
+		results[i].RenderedContent = renderContent(results[i].Format, results[i].Content)
+		results[i].Snippet = highlightSnippet(results[i].Snippet)
+	}
+
+	response := models.SearchResults{Posts: results}
+	if len(results) == limit {
+		response.NextCursor = encodeSearchCursor(results[len(results)-1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (a *postApi) PostPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.PostScope)
+	if !ok {
+		return
+	}
+
+	if !a.restrictions.allow(w, u, postAction) {
+		return
+	}
+
 	var p models.Post
-	err = json.NewDecoder(r.Body).Decode(&p)
+	err := json.NewDecoder(r.Body).Decode(&p)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.RenderedContent = ""
+
+	if err = validateFormat(&p.Format); err != nil {
+		invalidField(w, "format", err.Error())
 		return
 	}
 
@@ -63,18 +169,34 @@ func (a *postApi) PostPost(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.NewV4()
 	if err != nil {
 		log.Printf("Error generating UUID: %v\n", err.Error())
-		http.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
 		return
 	}
 	p.PostId = id.String()
 
 	p.Time = time.Now()
+	p.Tags = extractTags(p.Title, p.Content)
 
 	if err = a.db.CreatePost(p); err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
+	if p.PostUrl != "" {
+		go a.previews.fetchForPost(p.PostId, p.PostUrl)
+	}
+
+	a.notifier.notifyMentions(u.Id, p.PostId, "", p.Title, p.Content)
+
+	followerIds, err := a.db.GetFollowerIds(u.Id)
+	if err != nil {
+		log.Printf("Error getting followers to publish post: %v\n", err)
+	} else {
+		p.Username = u.Username
+		p.RenderedContent = renderContent(p.Format, p.Content)
+		a.hub.publish(postEvent, p, followerIds...)
+	}
+
     // TODO: Run concurrently, first must terminate first
     addRecommendsNode(&nodeResource{
         Id: p.PostId,
@@ -89,20 +211,28 @@ func (a *postApi) PostPost(w http.ResponseWriter, r *http.Request) {
 		Timestamp: p.Time,
 	}, true)
 
+	for _, tag := range p.Tags {
+		addRecommendsNode(&nodeResource{
+			Id:        tagNodeId(tag),
+			Type:      tagNode,
+			Timestamp: p.Time,
+		})
+
+		addRecommendsEdge(&edgeResource{
+			SourceId:      p.PostId,
+			DestinationId: tagNodeId(tag),
+			Type:          tagEdge,
+			Timestamp:     p.Time,
+		}, true)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (a *postApi) PostDelete(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
-
-	u, err := a.db.GetUserFromSession(sessionId)
-	if err != nil {
-		handleDbErr(err, w)
+	u, ok := authenticate(a.db, w, r, models.PostScope)
+	if !ok {
 		return
 	}
 
@@ -110,7 +240,7 @@ func (a *postApi) PostDelete(w http.ResponseWriter, r *http.Request) {
 
 	postId, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a post ID to delete", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a post ID to delete", http.StatusBadRequest)
 		return
 	}
 
@@ -121,7 +251,7 @@ func (a *postApi) PostDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if post.Id != u.Id {
-		http.Error(w, "Currently logged in user is not the owner of the post", http.StatusUnauthorized)
+		apierror.Error(w, "Currently logged in user is not the owner of the post", http.StatusUnauthorized)
 		return
 	}
 
@@ -131,20 +261,20 @@ func (a *postApi) PostDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.hub.expirePost(postId)
+	a.live.hub.dropPost(postId)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (a *postApi) PostVotePost(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	u, ok := authenticate(a.db, w, r, models.VoteScope)
+	if !ok {
 		return
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
-	if err != nil {
-		handleDbErr(err, w)
+	if !a.restrictions.allow(w, u, voteAction) {
 		return
 	}
 
@@ -152,24 +282,28 @@ func (a *postApi) PostVotePost(w http.ResponseWriter, r *http.Request) {
 
 	postId, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a post ID to vote on", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a post ID to vote on", http.StatusBadRequest)
 		return
 	}
 
 	var v models.Vote
-	err = json.NewDecoder(r.Body).Decode(&v)
+	err := json.NewDecoder(r.Body).Decode(&v)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if v.Vote != models.UPVOTE && v.Vote != models.DOWNVOTE {
-		http.Error(w, fmt.Sprintf("Vote must be %v or %v", models.UPVOTE, models.DOWNVOTE), http.StatusBadRequest)
+		invalidField(w, "vote", fmt.Sprintf("Vote must be %v or %v", models.UPVOTE, models.DOWNVOTE))
 		return
 	}
 
 	v.Time = time.Now()
 
+	if !a.checkCanInteract(w, postId, u.Id) {
+		return
+	}
+
 	err = a.db.CreateVote(u.Id, postId, v.Vote)
 	if err != nil {
 		handleDbErr(err, w)
@@ -190,20 +324,40 @@ func (a *postApi) PostVotePost(w http.ResponseWriter, r *http.Request) {
 		Timestamp: v.Time,
 	}, true)
 
+	if v.Vote == models.UPVOTE {
+		a.notifier.notifyPostOwner(models.UpvoteNotification, u.Id, postId, "")
+	}
+
+	a.live.publishVotes(postId)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *postApi) PostCommentPost(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
+// checkCanInteract writes an error response and returns false if the user
+// can't see the post, or if they have a block with its creator
+func (a *postApi) checkCanInteract(w http.ResponseWriter, postId, id string) bool {
+	if err := a.db.CanViewPost(postId, id); err != nil {
+		handleDbErr(err, w)
+		return false
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	post, err := a.db.GetPost(postId)
 	if err != nil {
 		handleDbErr(err, w)
+		return false
+	}
+
+	return checkNotBlocked(a.db, w, id, post.Id)
+}
+
+func (a *postApi) PostCommentPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.PostScope)
+	if !ok {
+		return
+	}
+
+	if !a.restrictions.allow(w, u, commentAction) {
 		return
 	}
 
@@ -211,14 +365,21 @@ func (a *postApi) PostCommentPost(w http.ResponseWriter, r *http.Request) {
 
 	postId, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a post ID to comment on", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a post ID to comment on", http.StatusBadRequest)
 		return
 	}
 
 	var c models.Comment
-	err = json.NewDecoder(r.Body).Decode(&c)
+	err := json.NewDecoder(r.Body).Decode(&c)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.RenderedContent = ""
+
+	if err = validateFormat(&c.Format); err != nil {
+		invalidField(w, "format", err.Error())
 		return
 	}
 
@@ -227,18 +388,30 @@ func (a *postApi) PostCommentPost(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.NewV4()
 	if err != nil {
 		log.Printf("Error generating UUID: %v\n", err.Error())
-		http.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
 		return
 	}
 	c.CommentId = id.String()
 
 	c.Time = time.Now()
 
+	if !a.checkCanInteract(w, postId, u.Id) {
+		return
+	}
+
 	err = a.db.CreateComment(postId, c)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
+
+	a.notifier.notifyPostOwner(models.CommentNotification, u.Id, postId, c.CommentId)
+	a.notifier.notifyMentions(u.Id, postId, c.CommentId, c.Content)
+
+	c.PostId = postId
+	c.RenderedContent = renderContent(c.Format, c.Content)
+	a.live.hub.publishComment(postId, c)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
@@ -248,15 +421,16 @@ func (a *postApi) PostCommentsGet(w http.ResponseWriter, r *http.Request) {
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide a post ID to get the posts for", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a post ID to get the posts for", http.StatusBadRequest)
 		return
 	}
 
-	comments, err := a.db.GetComments(id)
+	comments, err := a.db.GetComments(id, getViewerId(a.db, r))
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
+	renderComments(comments)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,334 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+const (
+	previewTimeout        = 5 * time.Second
+	previewMaxBytes       = 1 << 20
+	previewMaxRedirects   = 3
+	previewCacheTTL       = time.Hour
+	previewCacheSize      = 1000
+	previewMaxTitle       = 300
+	previewMaxDescription = 1000
+)
+
+var errPrivateAddress = errors.New("Refusing to connect to a non-public address")
+
+// reservedNets are the special purpose ranges that the net.IP methods don't
+// cover
+var reservedNets = parseCIDRs(
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// isPublicIP reports whether ip is safe to fetch previews from, i.e. it is
+// not a loopback, private, link local or otherwise reserved address
+func isPublicIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialControl only lets connections to addresses that allowIP accepts through.
+// The check happens when dialing rather than when parsing the URL so that DNS
+// responses and redirects can't be used to reach internal services
+func dialControl(allowIP func(net.IP) bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		if !allowIP(net.ParseIP(host)) {
+			return errPrivateAddress
+		}
+
+		return nil
+	}
+}
+
+type previewCacheEntry struct {
+	preview *models.LinkPreview
+	expiry  time.Time
+}
+
+type previewFetcher struct {
+	db     database.DatabaseHandler
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]previewCacheEntry
+}
+
+func newPreviewFetcher(db database.DatabaseHandler) *previewFetcher {
+	return newPreviewFetcherWithCheck(db, isPublicIP)
+}
+
+// newPreviewFetcherWithCheck creates a fetcher that only connects to the
+// addresses allowIP accepts
+func newPreviewFetcherWithCheck(db database.DatabaseHandler, allowIP func(net.IP) bool) *previewFetcher {
+	dialer := &net.Dialer{
+		Timeout: previewTimeout,
+		Control: dialControl(allowIP),
+	}
+
+	transport := &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout:   previewTimeout,
+		ResponseHeaderTimeout: previewTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   previewTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= previewMaxRedirects {
+				return errors.New("Too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("Unsupported redirect scheme %v", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &previewFetcher{
+		db:     db,
+		client: client,
+		cache:  map[string]previewCacheEntry{},
+	}
+}
+
+// fetchForPost fetches the preview for postUrl and stores it with the post,
+// it is intended to be run in its own goroutine
+func (f *previewFetcher) fetchForPost(postId, postUrl string) {
+	preview, err := f.get(postUrl)
+	if err != nil {
+		log.Printf("Error fetching preview for %v: %v\n", postUrl, err)
+		return
+	}
+	if preview == nil {
+		return
+	}
+
+	if err = f.db.UpdatePostPreview(postId, *preview); err != nil {
+		log.Printf("Error storing preview for post %v: %v\n", postId, err)
+	}
+}
+
+// get returns the preview for rawUrl, using the cache when possible. A nil
+// preview with a nil error means the page has no preview metadata
+func (f *previewFetcher) get(rawUrl string) (*models.LinkPreview, error) {
+	now := time.Now()
+
+	f.mu.Lock()
+	entry, ok := f.cache[rawUrl]
+	f.mu.Unlock()
+	if ok && now.Before(entry.expiry) {
+		return entry.preview, nil
+	}
+
+	preview, err := f.fetch(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	if len(f.cache) >= previewCacheSize {
+		for k, e := range f.cache {
+			if now.After(e.expiry) || len(f.cache) >= previewCacheSize {
+				delete(f.cache, k)
+			}
+		}
+	}
+	f.cache[rawUrl] = previewCacheEntry{preview: preview, expiry: now.Add(previewCacheTTL)}
+	f.mu.Unlock()
+
+	return preview, nil
+}
+
+func (f *previewFetcher) fetch(rawUrl string) (*models.LinkPreview, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme %v", u.Scheme)
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "transient-preview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Got status %v", resp.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "text/html" {
+		return nil, nil
+	}
+
+	return parsePreview(io.LimitReader(resp.Body, previewMaxBytes), resp.Request.URL), nil
+}
+
+// parsePreview extracts OpenGraph and Twitter card metadata from the head of
+// an html document, OpenGraph values take priority
+func parsePreview(r io.Reader, base *url.URL) *models.LinkPreview {
+	meta := map[string]string{}
+	var title string
+
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		if tt == html.TextToken && inTitle && title == "" {
+			title = strings.TrimSpace(string(z.Text()))
+			continue
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken && tt != html.EndTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		tag := string(name)
+		if tt == html.EndTagToken {
+			if tag == "title" {
+				inTitle = false
+			} else if tag == "head" {
+				break
+			}
+			continue
+		}
+
+		if tag == "body" {
+			break
+		} else if tag == "title" {
+			inTitle = true
+		} else if tag == "meta" && hasAttr {
+			var key, content string
+			for {
+				k, v, more := z.TagAttr()
+				switch string(k) {
+				case "property", "name":
+					key = strings.ToLower(string(v))
+				case "content":
+					content = strings.TrimSpace(string(v))
+				}
+				if !more {
+					break
+				}
+			}
+			if _, ok := meta[key]; !ok && key != "" && content != "" {
+				meta[key] = content
+			}
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v, ok := meta[k]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	preview := models.LinkPreview{
+		Title:       truncate(first("og:title", "twitter:title"), previewMaxTitle),
+		Description: truncate(first("og:description", "twitter:description", "description"), previewMaxDescription),
+	}
+	if preview.Title == "" {
+		preview.Title = truncate(title, previewMaxTitle)
+	}
+
+	if image := first("og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if imageUrl, err := base.Parse(image); err == nil && (imageUrl.Scheme == "http" || imageUrl.Scheme == "https") {
+			preview.ImageUrl = imageUrl.String()
+		}
+	}
+
+	if preview.Title == "" && preview.Description == "" && preview.ImageUrl == "" {
+		return nil
+	}
+
+	return &preview
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// loopbackOnly lets the fetcher reach httptest servers while still refusing
+// every other address
+func loopbackOnly(ip net.IP) bool {
+	return ip != nil && ip.IsLoopback()
+}
+
+func newTestFetcher() *previewFetcher {
+	return newPreviewFetcherWithCheck(nil, loopbackOnly)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.255", false},
+		{"100.128.0.1", true},
+		{"192.0.0.8", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublicIP(net.ParseIP(test.ip)); got != test.public {
+			t.Errorf("isPublicIP(%v) = %v, want %v", test.ip, got, test.public)
+		}
+	}
+}
+
+func TestPreviewExtraction(t *testing.T) {
+	tests := []struct {
+		name        string
+		html        string
+		title       string
+		description string
+		imageUrl    string
+		empty       bool
+	}{
+		{
+			name: "opengraph",
+			html: `<html><head><title>Page</title>
+<meta property="og:title" content="OG title">
+<meta property="og:description" content="OG description">
+<meta property="og:image" content="/image.png">
+</head><body></body></html>`,
+			title:       "OG title",
+			description: "OG description",
+			imageUrl:    "/image.png",
+		},
+		{
+			name: "twitter",
+			html: `<html><head>
+<meta name="twitter:title" content="Card title">
+<meta name="twitter:description" content="Card description">
+<meta name="twitter:image" content="https://cdn.example.com/card.png">
+</head></html>`,
+			title:       "Card title",
+			description: "Card description",
+			imageUrl:    "https://cdn.example.com/card.png",
+		},
+		{
+			name:        "title fallback",
+			html:        `<html><head><title> Just a title </title><meta name="description" content="Described"></head></html>`,
+			title:       "Just a title",
+			description: "Described",
+		},
+		{
+			name:  "metadata in body is ignored",
+			html:  `<html><head></head><body><title>Late</title></body></html>`,
+			empty: true,
+		},
+		{
+			name:  "no metadata",
+			html:  `<html><head></head><body>Hello</body></html>`,
+			empty: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				fmt.Fprint(w, test.html)
+			}))
+			defer server.Close()
+
+			preview, err := newTestFetcher().get(server.URL)
+			if err != nil {
+				t.Fatalf("Got error %v", err)
+			}
+
+			if test.empty {
+				if preview != nil {
+					t.Fatalf("Expected no preview, got %+v", preview)
+				}
+				return
+			}
+
+			if preview == nil {
+				t.Fatal("Expected a preview")
+			}
+
+			imageUrl := test.imageUrl
+			if strings.HasPrefix(imageUrl, "/") {
+				imageUrl = server.URL + imageUrl
+			}
+
+			if preview.Title != test.title || preview.Description != test.description || preview.ImageUrl != imageUrl {
+				t.Errorf("Got %+v, want title %q description %q image %q",
+					preview, test.title, test.description, imageUrl)
+			}
+		})
+	}
+}
+
+func TestPreviewSizeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><!--")
+		fmt.Fprint(w, strings.Repeat("x", previewMaxBytes))
+		fmt.Fprint(w, `--><meta property="og:title" content="Too far"></head></html>`)
+	}))
+	defer server.Close()
+
+	preview, err := newTestFetcher().get(server.URL)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+
+	if preview != nil {
+		t.Errorf("Read past the size limit, got %+v", preview)
+	}
+}
+
+func TestPreviewNonHtml(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title": "<title>Not html</title>"}`)
+	}))
+	defer server.Close()
+
+	preview, err := newTestFetcher().get(server.URL)
+	if err != nil {
+		t.Fatalf("Got error %v", err)
+	}
+
+	if preview != nil {
+		t.Errorf("Expected no preview for json, got %+v", preview)
+	}
+}
+
+func TestPreviewRedirectToPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/admin", http.StatusFound)
+	}))
+	defer server.Close()
+
+	_, err := newTestFetcher().get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), errPrivateAddress.Error()) {
+		t.Errorf("Expected the redirect to be refused, got %v", err)
+	}
+}
+
+func TestPreviewRefusesPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<title>Internal</title>")
+	}))
+	defer server.Close()
+
+	_, err := newPreviewFetcher(nil).get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), errPrivateAddress.Error()) {
+		t.Errorf("Expected loopback to be refused, got %v", err)
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Cached</title></head></html>")
+	}))
+	defer server.Close()
+
+	f := newTestFetcher()
+	for i := 0; i < 3; i++ {
+		preview, err := f.get(server.URL)
+		if err != nil {
+			t.Fatalf("Got error %v", err)
+		}
+		if preview == nil || preview.Title != "Cached" {
+			t.Fatalf("Got preview %+v", preview)
+		}
+	}
+
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("Expected one request, got %v", n)
+	}
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 500
+	maxProfileUrlLength  = 2048
+)
+
+func validateProfileUrl(name, u string) string {
+	if u == "" {
+		return ""
+	}
+
+	if len(u) > maxProfileUrlLength {
+		return fmt.Sprintf("%v must be at most %v characters", name, maxProfileUrlLength)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Sprintf("%v must be an http or https URL", name)
+	}
+
+	return ""
+}
+
+// validateProfileUpdate returns a problem for each invalid field
+func validateProfileUpdate(update models.ProfileUpdate) []apierror.Field {
+	fields := []apierror.Field{}
+
+	if update.DisplayName != nil && utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLength {
+		fields = append(fields, apierror.Field{
+			Field:   "displayName",
+			Message: fmt.Sprintf("Display name must be at most %v characters", maxDisplayNameLength),
+		})
+	}
+
+	if update.Bio != nil && utf8.RuneCountInString(*update.Bio) > maxBioLength {
+		fields = append(fields, apierror.Field{
+			Field:   "bio",
+			Message: fmt.Sprintf("Bio must be at most %v characters", maxBioLength),
+		})
+	}
+
+	if update.AvatarUrl != nil {
+		if message := validateProfileUrl("Avatar", *update.AvatarUrl); message != "" {
+			fields = append(fields, apierror.Field{Field: "avatarUrl", Message: message})
+		}
+	}
+
+	if update.Link != nil {
+		if message := validateProfileUrl("Link", *update.Link); message != "" {
+			fields = append(fields, apierror.Field{Field: "link", Message: message})
+		}
+	}
+
+	return fields
+}
+
+func (a *userApi) UserProfilePatch(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	var update models.ProfileUpdate
+	if err = json.NewDecoder(r.Body).Decode(&update); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if update.DisplayName == nil && update.Bio == nil && update.AvatarUrl == nil && update.Link == nil {
+		apierror.Error(w, "Must change at least one field", http.StatusBadRequest)
+		return
+	}
+
+	if fields := validateProfileUpdate(update); len(fields) > 0 {
+		apierror.Invalid(w, fields[0].Message, fields...)
+		return
+	}
+
+	if err = a.db.UpdateProfile(u.Id, update); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	p, err := a.db.GetProfile(u.Id, u.Id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(p)
+}
@@ -3,11 +3,14 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
 type recommendsApi struct {
@@ -17,11 +20,16 @@ type recommendsApi struct {
 const (
 	userNode = 0
 	postNode = 1
+	tagNode  = 2
 
 	upvoteEdge   = 0
 	downvoteEdge = 1
 	creationEdge = 2
 	followEdge   = 3
+	tagEdge      = 4
+
+	blockExclusion = "block"
+	muteExclusion  = "mute"
 )
 
 type edgeResource struct {
@@ -31,12 +39,41 @@ type edgeResource struct {
 	Timestamp     time.Time `json:"timestamp"`
 }
 
+type privacyResource struct {
+	Id      string `json:"id"`
+	Private bool   `json:"private"`
+}
+
+type exclusionResource struct {
+	Id         string `json:"id"`
+	ExcludedId string `json:"excludedId"`
+	Type       string `json:"type"`
+}
+
 type nodeResource struct {
 	Id        string    `json:"id"`
 	Type      int       `json:"type"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// recommendsGraph adds and removes edges in the recommends service's graph,
+// handlers that take one can have the service stubbed out in tests
+type recommendsGraph interface {
+	addEdge(e *edgeResource, bidirectional bool)
+	removeEdge(e *edgeResource)
+}
+
+// recommendsService is the recommendsGraph backed by the recommends service
+type recommendsService struct{}
+
+func (recommendsService) addEdge(e *edgeResource, bidirectional bool) {
+	addRecommendsEdge(e, bidirectional)
+}
+
+func (recommendsService) removeEdge(e *edgeResource) {
+	removeRecommendsEdge(e)
+}
+
 func addRecommendsEdge(e *edgeResource, bidirectional bool) {
 	body, err := json.Marshal(e)
 	if err != nil {
@@ -55,35 +92,162 @@ func addRecommendsEdge(e *edgeResource, bidirectional bool) {
 	}
 }
 
-func addRecommendsNode(n *nodeResource) {
-	body, err := json.Marshal(n)
+func removeRecommendsEdge(e *edgeResource) {
+	body, err := json.Marshal(e)
 	if err != nil {
 		log.Printf("Error marshalling object as json: %v\n", err)
 	}
 
-	_, err = http.Post("http://dev-recommends:4001/node", "application/json", bytes.NewBuffer(body))
+	req, err := http.NewRequest("DELETE", "http://dev-recommends:4001/edge", bytes.NewBuffer(body))
 	if err != nil {
-		log.Printf("Error adding recommends edge: %v\n", err)
+		log.Printf("Error creating request: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error removing recommends edge: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error removing recommends edge: got status %v\n", resp.StatusCode)
 	}
 }
 
-func (a *recommendsApi) RecommendsPostsGet(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
+func setRecommendsPrivacy(p *privacyResource) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error marshalling object as json: %v\n", err)
+	}
+
+	resp, err := http.Post("http://dev-recommends:4001/privacy", "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		log.Printf("Error setting recommends privacy: %v\n", err)
 		return
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error setting recommends privacy: got status %v\n", resp.StatusCode)
+	}
+}
+
+func addRecommendsExclusion(e *exclusionResource) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling object as json: %v\n", err)
+	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
+	resp, err := http.Post("http://dev-recommends:4001/exclusion", "application/json", bytes.NewBuffer(body))
 	if err != nil {
-		handleDbErr(err, w)
+		log.Printf("Error adding recommends exclusion: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error adding recommends exclusion: got status %v\n", resp.StatusCode)
+	}
+}
+
+func removeRecommendsExclusion(e *exclusionResource) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Error marshalling object as json: %v\n", err)
+	}
+
+	req, err := http.NewRequest("DELETE", "http://dev-recommends:4001/exclusion", bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("Error creating request: %v\n", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error removing recommends exclusion: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error removing recommends exclusion: got status %v\n", resp.StatusCode)
+	}
+}
+
+func removeRecommendsNode(id string) {
+	req, err := http.NewRequest("DELETE", "http://dev-recommends:4001/node/"+id, nil)
+	if err != nil {
+		log.Printf("Error creating request: %v\n", err)
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Error removing recommends node: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Error removing recommends node: got status %v\n", resp.StatusCode)
+	}
+}
+
+func rebuildRecommends() error {
+	resp, err := http.Post("http://dev-recommends:4001/rebuild", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Got status %v", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func getRecommendsStats() (*models.GraphStats, error) {
+	resp, err := http.Get("http://dev-recommends:4001/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stats models.GraphStats
+	if err = json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func addRecommendsNode(n *nodeResource) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		log.Printf("Error marshalling object as json: %v\n", err)
+	}
+
+	_, err = http.Post("http://dev-recommends:4001/node", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("Error adding recommends edge: %v\n", err)
+	}
+}
+
+func (a *recommendsApi) RecommendsPostsGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
 		return
 	}
 
     resp, err := http.Get("http://dev-recommends:4001/posts/" + u.Id)
 	if err != nil {
 		log.Printf("Error getting recommended posts, %v\n", err)
-		http.Error(w, "Could not generate post recommendations", http.StatusServiceUnavailable)
+		apierror.Error(w, "Could not generate post recommendations", http.StatusServiceUnavailable)
 		return
 	}
 	defer resp.Body.Close()
@@ -92,15 +256,16 @@ func (a *recommendsApi) RecommendsPostsGet(w http.ResponseWriter, r *http.Reques
 	err = json.NewDecoder(resp.Body).Decode(&postIds)
 	if err != nil {
 		log.Printf("Error decoding recommended posts, %v\n", err)
-		http.Error(w, "Could not generate post recommendations", http.StatusServiceUnavailable)
+		apierror.Error(w, "Could not generate post recommendations", http.StatusServiceUnavailable)
 		return
 	}
 
-	posts, err := a.db.GetPosts(postIds)
+	posts, err := a.db.GetPosts(postIds, u.Id)
 	if err != nil {
 		handleDbErr(err, w)
 		return
 	}
+	renderPosts(posts)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -108,22 +273,15 @@ func (a *recommendsApi) RecommendsPostsGet(w http.ResponseWriter, r *http.Reques
 }
 
 func (a *recommendsApi) RecommendsFollowingsGet(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
-
-	u, err := a.db.GetUserFromSession(sessionId)
-	if err != nil {
-		handleDbErr(err, w)
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
 		return
 	}
 
     resp, err := http.Get("http://dev-recommends:4001/followings/" + u.Id)
 	if err != nil {
 		log.Printf("Error getting recommended users, %v\n", err)
-		http.Error(w, "Could not generate user recommendations", http.StatusServiceUnavailable)
+		apierror.Error(w, "Could not generate user recommendations", http.StatusServiceUnavailable)
 		return
 	}
 	defer resp.Body.Close()
@@ -132,7 +290,7 @@ func (a *recommendsApi) RecommendsFollowingsGet(w http.ResponseWriter, r *http.R
 	err = json.NewDecoder(resp.Body).Decode(&userIds)
 	if err != nil {
 		log.Printf("Error decoding recommended users, %v\n", err)
-		http.Error(w, "Could not generate user recommendations", http.StatusServiceUnavailable)
+		apierror.Error(w, "Could not generate user recommendations", http.StatusServiceUnavailable)
 		return
 	}
 
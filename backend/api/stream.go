@@ -0,0 +1,433 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	postEvent         = "post"
+	notificationEvent = "notification"
+	expireEvent       = "expire"
+	resetEvent        = "reset"
+	connectedEvent    = "connected"
+
+	streamHistorySize  = 4096
+	streamClientBuffer = 32
+	streamHeartbeat    = 15 * time.Second
+	streamExpiryCheck  = 10 * time.Second
+	maxWatchedPosts    = 100
+)
+
+type streamEvent struct {
+	id         uint64
+	kind       string
+	data       []byte
+	recipients map[string]bool
+}
+
+type streamClient struct {
+	// sent to the client when it connects, so that it can say which of its
+	// streams it is watching posts on
+	id     string
+	userId string
+	events chan *streamEvent
+	// closed by the hub when the client can't keep up, the client is expected
+	// to reconnect and resume from the last event it received
+	dropped chan struct{}
+}
+
+type watchedPost struct {
+	expiry  time.Time
+	clients map[*streamClient]bool
+}
+
+// streamHub fans events out to the connected clients of each recipient, and
+// keeps a bounded history of events so that clients can resume after
+// reconnecting
+type streamHub struct {
+	mu      sync.RWMutex
+	nextId  uint64
+	history [streamHistorySize]*streamEvent
+	clients map[string]map[*streamClient]bool
+	streams map[string]*streamClient
+
+	watchMu       sync.Mutex
+	watched       map[string]*watchedPost
+	clientWatches map[*streamClient][]string
+}
+
+func newStreamHub() *streamHub {
+	h := &streamHub{
+		// Seeding with the current time means IDs from before a restart are
+		// always older than anything in the history
+		nextId:        uint64(time.Now().UnixNano()),
+		clients:       map[string]map[*streamClient]bool{},
+		streams:       map[string]*streamClient{},
+		watched:       map[string]*watchedPost{},
+		clientWatches: map[*streamClient][]string{},
+	}
+	go h.expireWatched()
+	return h
+}
+
+func (h *streamHub) subscribe(userId string) (*streamClient, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &streamClient{
+		id:      id.String(),
+		userId:  userId,
+		events:  make(chan *streamEvent, streamClientBuffer),
+		dropped: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if _, ok := h.clients[userId]; !ok {
+		h.clients[userId] = map[*streamClient]bool{}
+	}
+	h.clients[userId][c] = true
+	h.streams[c.id] = c
+	h.mu.Unlock()
+
+	return c, nil
+}
+
+func (h *streamHub) unsubscribe(c *streamClient) {
+	h.mu.Lock()
+	h.removeClient(c)
+	h.mu.Unlock()
+
+	h.watch(c, nil)
+}
+
+// stream returns userId's connected client with the stream ID given, or nil
+// if there isn't one
+func (h *streamHub) stream(userId, streamId string) *streamClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	c, ok := h.streams[streamId]
+	if !ok || c.userId != userId {
+		return nil
+	}
+	return c
+}
+
+// disconnect drops every stream the user has open
+func (h *streamHub) disconnect(userId string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients[userId] {
+		h.removeClient(c)
+		close(c.dropped)
+	}
+}
+
+// removeClient must be called with mu held
+func (h *streamHub) removeClient(c *streamClient) {
+	clients, ok := h.clients[c.userId]
+	if !ok || !clients[c] {
+		return
+	}
+
+	delete(clients, c)
+	delete(h.streams, c.id)
+	if len(clients) == 0 {
+		delete(h.clients, c.userId)
+	}
+}
+
+// publish sends an event to every connected client of the recipients, and
+// records it for clients that reconnect later
+func (h *streamHub) publish(kind string, v interface{}, recipients ...string) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling %v event: %v\n", kind, err)
+		return
+	}
+
+	e := &streamEvent{kind: kind, data: data, recipients: map[string]bool{}}
+	for _, r := range recipients {
+		e.recipients[r] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e.id = h.nextId
+	h.nextId++
+	h.history[e.id%streamHistorySize] = e
+
+	for r := range e.recipients {
+		for c := range h.clients[r] {
+			select {
+			case c.events <- e:
+			default:
+				h.removeClient(c)
+				close(c.dropped)
+			}
+		}
+	}
+}
+
+// since returns the events for userId after lastId, or false if some of the
+// events have already been dropped from the history
+func (h *streamHub) since(userId string, lastId uint64) ([]*streamEvent, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	events := []*streamEvent{}
+	if lastId+1 >= h.nextId {
+		return events, lastId < h.nextId
+	}
+	if h.nextId-(lastId+1) > streamHistorySize {
+		return events, false
+	}
+
+	for id := lastId + 1; id < h.nextId; id++ {
+		e := h.history[id%streamHistorySize]
+		if e != nil && e.id == id && e.recipients[userId] {
+			events = append(events, e)
+		}
+	}
+
+	return events, true
+}
+
+// watch replaces the set of posts a client is watching for expiry, each of a
+// user's streams watches its own posts, and only while it is connected
+func (h *streamHub) watch(c *streamClient, posts []models.Post) {
+	h.mu.RLock()
+	connected := h.clients[c.userId][c]
+	h.mu.RUnlock()
+	if !connected {
+		posts = nil
+	}
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+
+	for _, postId := range h.clientWatches[c] {
+		if w, ok := h.watched[postId]; ok {
+			delete(w.clients, c)
+			if len(w.clients) == 0 {
+				delete(h.watched, postId)
+			}
+		}
+	}
+	delete(h.clientWatches, c)
+
+	if len(posts) == 0 {
+		return
+	}
+
+	postIds := []string{}
+	for _, p := range posts {
+		w, ok := h.watched[p.PostId]
+		if !ok {
+			w = &watchedPost{expiry: p.Time.Add(models.PostLifetime), clients: map[*streamClient]bool{}}
+			h.watched[p.PostId] = w
+		}
+		w.clients[c] = true
+		postIds = append(postIds, p.PostId)
+	}
+	h.clientWatches[c] = postIds
+}
+
+// expirePost tells everyone watching postId that it is gone, either because
+// it expired or because it was deleted
+func (h *streamHub) expirePost(postId string) {
+	h.watchMu.Lock()
+	w, ok := h.watched[postId]
+	delete(h.watched, postId)
+	h.watchMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	userIds := map[string]bool{}
+	for c := range w.clients {
+		userIds[c.userId] = true
+	}
+	users := []string{}
+	for u := range userIds {
+		users = append(users, u)
+	}
+	h.publish(expireEvent, struct {
+		PostId string `json:"postId"`
+	}{postId}, users...)
+}
+
+func (h *streamHub) expireWatched() {
+	for now := range time.Tick(streamExpiryCheck) {
+		expired := []string{}
+		h.watchMu.Lock()
+		for postId, w := range h.watched {
+			if now.After(w.expiry) {
+				expired = append(expired, postId)
+			}
+		}
+		h.watchMu.Unlock()
+
+		for _, postId := range expired {
+			h.expirePost(postId)
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e *streamEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.kind, e.data)
+	return err
+}
+
+type streamApi struct {
+	db  database.DatabaseHandler
+	hub *streamHub
+}
+
+func (a *streamApi) StreamGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apierror.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// EventSource sends the Last-Event-ID header when it reconnects, the query
+	// parameter allows clients to resume a stream they opened themselves
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+
+	client, err := a.hub.subscribe(u.Id)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer a.hub.unsubscribe(client)
+
+	if postIds := r.URL.Query().Get("posts"); postIds != "" {
+		if err := a.watch(client, strings.Split(postIds, ",")); err != nil {
+			log.Printf("Error watching posts: %v\n", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "event: %s\ndata: {\"streamId\":%q}\n\n", connectedEvent, client.id)
+
+	var lastSent uint64
+	if lastEventId != "" {
+		lastId, err := strconv.ParseUint(lastEventId, 10, 64)
+		events, complete := a.hub.since(u.Id, lastId)
+		if err != nil || !complete {
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", resetEvent)
+		}
+		for _, e := range events {
+			if err = writeEvent(w, e); err != nil {
+				return
+			}
+			lastSent = e.id
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case e := <-client.events:
+			if e.id <= lastSent {
+				continue
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			lastSent = e.id
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-client.dropped:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (a *streamApi) watch(c *streamClient, postIds []string) error {
+	if len(postIds) > maxWatchedPosts {
+		postIds = postIds[:maxWatchedPosts]
+	}
+
+	posts, err := a.db.GetPosts(postIds, c.userId)
+	if err != nil {
+		return err
+	}
+
+	a.hub.watch(c, posts)
+	return nil
+}
+
+func (a *streamApi) StreamWatchPost(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
+		return
+	}
+
+	var watch struct {
+		StreamId string   `json:"streamId"`
+		PostIds  []string `json:"postIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&watch); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := a.hub.stream(u.Id, watch.StreamId)
+	if client == nil {
+		apierror.Error(w, "Stream not connected", http.StatusNotFound)
+		return
+	}
+
+	if err := a.watch(client, watch.PostIds); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestStreamWatch(t *testing.T) {
+	hub := newStreamHub()
+	first, err := hub.subscribe("alice-id")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+	second, err := hub.subscribe("alice-id")
+	if err != nil {
+		t.Fatalf("Could not subscribe: %v", err)
+	}
+
+	if c := hub.stream("alice-id", first.id); c != first {
+		t.Errorf("Got stream %v, want the first stream", c)
+	}
+	if c := hub.stream("bob-id", first.id); c != nil {
+		t.Errorf("Got stream %v for another user, want none", c)
+	}
+
+	// Each stream keeps its own posts, so a second tab doesn't stop the
+	// first one hearing about its posts expiring
+	hub.watch(first, []models.Post{{PostId: "first-post", Time: time.Now()}})
+	hub.watch(second, []models.Post{{PostId: "second-post", Time: time.Now()}})
+	if _, ok := hub.watched["first-post"]; !ok {
+		t.Errorf("First stream's post isn't watched")
+	}
+	if _, ok := hub.watched["second-post"]; !ok {
+		t.Errorf("Second stream's post isn't watched")
+	}
+
+	hub.unsubscribe(first)
+	if _, ok := hub.watched["first-post"]; ok {
+		t.Errorf("Post is still watched after its stream closed")
+	}
+	if _, ok := hub.watched["second-post"]; !ok {
+		t.Errorf("Second stream's post stopped being watched")
+	}
+	if c := hub.stream("alice-id", first.id); c != nil {
+		t.Errorf("Got stream %v after it closed, want none", c)
+	}
+
+	hub.watch(first, []models.Post{{PostId: "first-post", Time: time.Now()}})
+	if _, ok := hub.watched["first-post"]; ok {
+		t.Errorf("Closed stream could watch a post")
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	maxTagLength      = 64
+	maxTagsPerPost    = 10
+	trendingTagsLimit = 20
+
+	defaultTrendingHours = 24
+	maxTrendingHours     = 24 * 7
+)
+
+// A hashtag must start at the beginning of the text or after a character that
+// can't be part of a word, so that things like url fragments are ignored
+var tagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/#])#([\p{L}_][\p{L}\p{N}_]*)`)
+
+type tagApi struct {
+	db database.DatabaseHandler
+}
+
+// extractTags returns the unique, lowercased hashtags in the given texts in
+// the order they first appear
+func extractTags(texts ...string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, text := range texts {
+		for _, match := range tagRegexp.FindAllStringSubmatch(text, -1) {
+			tag := strings.ToLower(match[1])
+			if len(tag) > maxTagLength || seen[tag] {
+				continue
+			}
+
+			seen[tag] = true
+			tags = append(tags, tag)
+			if len(tags) == maxTagsPerPost {
+				return tags
+			}
+		}
+	}
+
+	return tags
+}
+
+func tagNodeId(tag string) string {
+	return "tag:" + tag
+}
+
+func (a *tagApi) TagPostsGet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	tag, ok := vars["tag"]
+	if !ok {
+		apierror.Error(w, "Must provide a tag", http.StatusBadRequest)
+		return
+	}
+
+	posts, err := a.db.GetTagPosts(strings.ToLower(strings.TrimPrefix(tag, "#")), getViewerId(a.db, r))
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+	renderPosts(posts)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(posts)
+}
+
+func (a *tagApi) TagsTrendingGet(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	hours := defaultTrendingHours
+	if h := params.Get("hours"); h != "" {
+		var err error
+		hours, err = strconv.Atoi(h)
+		if err != nil || hours < 1 || hours > maxTrendingHours {
+			apierror.Error(w, "Query parameter 'hours' must be between 1 and "+strconv.Itoa(maxTrendingHours), http.StatusBadRequest)
+			return
+		}
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	tags, err := a.db.GetTrendingTags(since, trendingTagsLimit)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tags)
+}
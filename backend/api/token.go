@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	maxApiTokens          = 20
+	maxApiTokenNameLength = 64
+	defaultApiTokenDays   = 30
+	maxApiTokenDays       = 365
+)
+
+// validScopes returns the scopes without duplicates, or false if any of them
+// aren't known
+func validScopes(scopes []string) ([]string, bool) {
+	known := map[string]bool{}
+	for _, s := range models.ApiTokenScopes {
+		known[s] = true
+	}
+
+	valid := []string{}
+	seen := map[string]bool{}
+	for _, s := range scopes {
+		if !known[s] {
+			return nil, false
+		}
+		if !seen[s] {
+			seen[s] = true
+			valid = append(valid, s)
+		}
+	}
+
+	return valid, len(valid) > 0
+}
+
+// UserTokensPost creates an API token, the token itself is only ever returned
+// here. Tokens can only be managed from a session, not with other tokens
+func (a *userApi) UserTokensPost(w http.ResponseWriter, r *http.Request) {
+	var req models.ApiTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if req.Name == "" || len(req.Name) > maxApiTokenNameLength {
+		invalidField(w, "name", "Token name must be between 1 and "+strconv.Itoa(maxApiTokenNameLength)+" characters")
+		return
+	}
+
+	scopes, ok := validScopes(req.Scopes)
+	if !ok {
+		invalidField(w, "scopes", "Token scopes must be some of read, post, vote, and follow")
+		return
+	}
+
+	if req.ExpiryDays == 0 {
+		req.ExpiryDays = defaultApiTokenDays
+	} else if req.ExpiryDays < 1 || req.ExpiryDays > maxApiTokenDays {
+		invalidField(w, "expiryDays", "Token expiry must be between 1 and "+strconv.Itoa(maxApiTokenDays)+" days")
+		return
+	}
+
+	count, err := a.db.CountApiTokens(u.Id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if count >= maxApiTokens {
+		apierror.Error(w, "Can't have more than "+strconv.Itoa(maxApiTokens)+" tokens", http.StatusBadRequest)
+		return
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		log.Printf("Error generating api token: %v\n", err.Error())
+		apierror.Error(w, "Error generating api token", http.StatusInternalServerError)
+		return
+	}
+
+	tokenId, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("Error generating UUID: %v\n", err.Error())
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	t := models.ApiToken{
+		TokenId:   tokenId.String(),
+		Id:        u.Id,
+		Name:      req.Name,
+		Scopes:    scopes,
+		Time:      now,
+		Expiry:    now.Add(time.Duration(req.ExpiryDays) * 24 * time.Hour),
+		TokenHash: tokenHash,
+	}
+	if err = a.db.CreateApiToken(t); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+	t.Token = token
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (a *userApi) UserTokensGet(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	tokens, err := a.db.GetApiTokens(u.Id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (a *userApi) UserTokenDelete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	tokenId, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide a token ID", http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if err = a.db.DeleteApiToken(u.Id, tokenId); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,442 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	totpIssuer     = "Transient"
+	totpSecretSize = 20
+	totpDigits     = 6
+	totpModulus    = 1000000
+	totpPeriod     = 30
+	// Codes from the steps either side of the current one are accepted to
+	// allow for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 5
+
+	pendingLoginCookie      = "pendingLogin"
+	pendingLoginExpiry      = 5 * time.Minute
+	maxPendingLoginAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTotpSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	_, err := rand.Read(b)
+	return totpEncoding.EncodeToString(b), err
+}
+
+func totpUri(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// totpCode is the code for the given time step as described in RFC 6238, which
+// is HOTP from RFC 4226 with the step as the counter
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// checkTotp returns the time step that the code is for, or false if the code
+// isn't valid around the given time
+func checkTotp(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// generateRecoveryCodes returns the codes to show the user, along with the
+// hashes of them to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes without the dash or in
+// a different case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor returns whether the code is a valid, unused TOTP code or
+// recovery code for the user, using it up if it is
+func (a *userApi) checkSecondFactor(u models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	var err error
+	if step, ok := checkTotp(u.TotpSecret, code, time.Now()); ok {
+		err = a.db.UseTotpStep(u.Id, step)
+	} else {
+		err = a.db.UseRecoveryCode(u.Id, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// requireSecondFactor writes an error response and returns false if the user
+// has two factor authentication enabled and the code isn't valid
+func (a *userApi) requireSecondFactor(w http.ResponseWriter, u models.User, code string) bool {
+	if !u.TwoFactor {
+		return true
+	}
+
+	ok, err := a.checkSecondFactor(u, code)
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	if !ok {
+		apierror.Error(w, "Two factor code does not match", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func getPendingLoginToken(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(pendingLoginCookie)
+	if err != nil {
+		return "", err
+	}
+
+	return cookie.Value, nil
+}
+
+func storePendingLoginCookie(w http.ResponseWriter, token string, expiry time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookie,
+		Path:     "/",
+		Value:    token,
+		Expires:  expiry,
+		HttpOnly: true,
+		Domain:   "",
+	})
+}
+
+func deletePendingLoginCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingLoginCookie,
+		Path:     "/",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Domain:   "",
+	})
+}
+
+// createPendingLogin is the first step of logging in for users with two factor
+// authentication, they have to complete the login with a code before they get
+// a session. It writes an error response and returns false if it fails
+func (a *userApi) createPendingLogin(w http.ResponseWriter, u models.User) bool {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		log.Printf("Error generating pending login token: %v\n", err.Error())
+		apierror.Error(w, "Error generating pending login token", http.StatusInternalServerError)
+		return false
+	}
+
+	expiry := time.Now().Add(pendingLoginExpiry)
+	if err = a.db.CreatePendingLogin(u.Id, tokenHash, expiry); err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+	storePendingLoginCookie(w, token, expiry)
+
+	return true
+}
+
+func (a *userApi) startPendingLogin(w http.ResponseWriter, u models.User) {
+	if !a.createPendingLogin(w, u) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(models.LoginChallenge{TwoFactorRequired: true})
+}
+
+func (a *userApi) UserLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	var code models.TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := getPendingLoginToken(r)
+	if err != nil {
+		apierror.Error(w, "No login in progress", http.StatusUnauthorized)
+		return
+	}
+	tokenHash := hashToken(token)
+
+	id, err := a.db.GetPendingLogin(tokenHash, maxPendingLoginAttempts)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			deletePendingLoginCookie(w)
+			apierror.Error(w, "Login has expired, please log in again", http.StatusUnauthorized)
+			return
+		}
+		handleDbErr(err, w)
+		return
+	}
+
+	u, err := a.db.GetUserFromId(id)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	ok, err := a.checkSecondFactor(u, code.Code)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if !ok {
+		if err = a.db.FailPendingLogin(tokenHash); err != nil {
+			log.Printf("Error recording failed login for %v: %v\n", u.Id, err)
+		}
+		apierror.Error(w, "Two factor code does not match", http.StatusUnauthorized)
+		return
+	}
+
+	if u.Suspended {
+		apierror.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	if !a.cancelDeletion(w, u) {
+		return
+	}
+
+	s, err := generateSession(u.Id)
+	if err != nil {
+		log.Printf("Error generating session ID: %v\n", err.Error())
+		apierror.Error(w, "Error generating session ID", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.CompletePendingLogin(tokenHash, s); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+	deletePendingLoginCookie(w)
+	storeSessionCookie(w, s)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// UserTwoFactorEnrollPost starts enrolling the user in two factor
+// authentication, it isn't enabled until they confirm a code from the secret
+func (a *userApi) UserTwoFactorEnrollPost(w http.ResponseWriter, r *http.Request) {
+	var confirmation models.Confirmation
+	err := json.NewDecoder(r.Body).Decode(&confirmation)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if !a.confirmPassword(w, r, u, confirmation.Password) {
+		return
+	}
+
+	if u.TwoFactor {
+		apierror.Error(w, "Two factor authentication is already enabled", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		log.Printf("Error generating totp secret: %v\n", err.Error())
+		apierror.Error(w, "Error generating totp secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.SetTotpSecret(u.Id, secret); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.TotpEnrollment{
+		Secret: secret,
+		Uri:    totpUri(u.Username, secret),
+	})
+}
+
+// UserTwoFactorConfirmPost enables two factor authentication once the user
+// shows they can generate codes, and returns their recovery codes
+func (a *userApi) UserTwoFactorConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var code models.TwoFactorCode
+	err := json.NewDecoder(r.Body).Decode(&code)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if u.TwoFactor {
+		apierror.Error(w, "Two factor authentication is already enabled", http.StatusBadRequest)
+		return
+	} else if u.TotpSecret == "" {
+		apierror.Error(w, "Must enroll in two factor authentication first", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := checkTotp(u.TotpSecret, strings.TrimSpace(code.Code), time.Now())
+	if !ok {
+		apierror.Error(w, "Two factor code does not match", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.Printf("Error generating recovery codes: %v\n", err.Error())
+		apierror.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.EnableTotp(u.Id, step, hashes); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.RecoveryCodes{Codes: codes})
+}
+
+func (a *userApi) UserTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	var confirmation models.Confirmation
+	err := json.NewDecoder(r.Body).Decode(&confirmation)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if !u.TwoFactor {
+		apierror.Error(w, "Two factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if !a.confirmPassword(w, r, u, confirmation.Password) {
+		return
+	}
+
+	if !a.requireSecondFactor(w, u, confirmation.Code) {
+		return
+	}
+
+	if err = a.db.DisableTotp(u.Id); err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
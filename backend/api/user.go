@@ -2,31 +2,95 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/mail"
 	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/backend/oidc"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
-type userApi struct {
-	db database.DatabaseHandler
+const (
+	userEvent = "user"
+
+	passwordResetExpiry    = time.Hour
+	usernameChangeCooldown = 30 * 24 * time.Hour
+)
+
+// Usernames are restricted to the characters that can be mentioned
+var usernameRegexp = regexp.MustCompile(`^\w{3,32}$`)
+
+// reservedUsernames can't be taken because they could be mistaken for the
+// site itself or collide with frontend routes
+var reservedUsernames = map[string]bool{
+	"about":         true,
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"help":          true,
+	"login":         true,
+	"logout":        true,
+	"moderation":    true,
+	"moderator":     true,
+	"profile":       true,
+	"root":          true,
+	"settings":      true,
+	"signup":        true,
+	"support":       true,
+	"system":        true,
+	"transient":     true,
+	"user":          true,
+	"users":         true,
+	"verify":        true,
 }
 
-func (a *userApi) SelfGet(w http.ResponseWriter, r *http.Request) {
-	sessionId, err := getSessionId(r)
-	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
+// validUsername writes an error response and returns false if the username
+// can't be used
+func validUsername(w http.ResponseWriter, username string) bool {
+	if !usernameRegexp.MatchString(username) {
+		invalidField(w, "username", "Username must be 3 to 32 letters, numbers, or underscores")
+		return false
 	}
 
-	u, err := a.db.GetUserFromSession(sessionId)
-	if err != nil {
-		handleDbErr(err, w)
+	if reservedUsernames[strings.ToLower(username)] {
+		invalidField(w, "username", "Username is reserved")
+		return false
+	}
+
+	return true
+}
+
+type userApi struct {
+	db     database.DatabaseHandler
+	mailer mail.Mailer
+	hub    *streamHub
+	idp    *oidc.Provider
+	appUrl string
+}
+
+// sendMail sends the message in the background, so that how long sending
+// takes doesn't reveal anything to the client
+func (a *userApi) sendMail(m mail.Message) {
+	go func() {
+		if err := a.mailer.Send(m); err != nil {
+			log.Printf("Error sending mail: %v\n", err)
+		}
+	}()
+}
+
+func (a *userApi) SelfGet(w http.ResponseWriter, r *http.Request) {
+	u, ok := authenticate(a.db, w, r, models.ReadScope)
+	if !ok {
 		return
 	}
 
@@ -36,6 +100,10 @@ func (a *userApi) SelfGet(w http.ResponseWriter, r *http.Request) {
 		Id:             u.Id,
 		Identification: models.Identification{Username: u.Username},
 		Email:          u.Email,
+		Private:        u.Private,
+		Role:           u.Role,
+		Verified:       u.Verified,
+		TwoFactor:      u.TwoFactor,
 	})
 }
 
@@ -44,11 +112,11 @@ func (a *userApi) UserGet(w http.ResponseWriter, r *http.Request) {
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide an id", http.StatusBadRequest)
+		apierror.Error(w, "Must provide an id", http.StatusBadRequest)
 		return
 	}
 
-	u, err := a.db.GetUserFromId(id)
+	p, err := a.db.GetProfile(id, getViewerId(a.db, r))
 	if err != nil {
 		handleDbErr(err, w)
 		return
@@ -56,25 +124,25 @@ func (a *userApi) UserGet(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(models.User{
-		Id:             u.Id,
-		Identification: models.Identification{Username: u.Username},
-		Email:          u.Email,
-	})
+	json.NewEncoder(w).Encode(p)
 }
 
 func (a *userApi) UserPost(w http.ResponseWriter, r *http.Request) {
 	var u models.User
 	err := json.NewDecoder(r.Body).Decode(&u)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !validUsername(w, u.Username) {
 		return
 	}
 
 	password, err := hashPassword(u.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %v\n", err.Error())
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		apierror.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
 	u.Password = string(password)
@@ -82,7 +150,7 @@ func (a *userApi) UserPost(w http.ResponseWriter, r *http.Request) {
 	id, err := uuid.NewV4()
 	if err != nil {
 		log.Printf("Error generating UUID: %v\n", err.Error())
-		http.Error(w, "Error generating UUID", http.StatusInternalServerError)
+		apierror.Error(w, "Error generating UUID", http.StatusInternalServerError)
 		return
 	}
 	u.Id = id.String()
@@ -90,7 +158,7 @@ func (a *userApi) UserPost(w http.ResponseWriter, r *http.Request) {
 	s, err := generateSession(u.Id)
 	if err != nil {
 		log.Printf("Error generating session ID: %v\n", err.Error())
-		http.Error(w, "Error generating session ID", http.StatusInternalServerError)
+		apierror.Error(w, "Error generating session ID", http.StatusInternalServerError)
 		return
 	}
 
@@ -100,6 +168,12 @@ func (a *userApi) UserPost(w http.ResponseWriter, r *http.Request) {
 	}
 	storeSessionCookie(w, s)
 
+	// The account is usable without verifying, so a failure here shouldn't
+	// fail the signup, the user can ask for the email to be resent
+	if err = a.sendVerification(u, u.Email); err != nil {
+		log.Printf("Error sending verification email: %v\n", err)
+	}
+
     addRecommendsNode(&nodeResource{
         Id: u.Id,
         Type: userNode,
@@ -110,18 +184,139 @@ func (a *userApi) UserPost(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// UserPatch changes the username and/or email of the logged in user. A new
+// email only replaces the old one once it has been verified, until then the
+// user keeps using the old one
+func (a *userApi) UserPatch(w http.ResponseWriter, r *http.Request) {
+	var update models.UserUpdate
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if !a.confirmPassword(w, r, u, update.Password) {
+		return
+	}
+
+	changeUsername := update.Username != "" && update.Username != u.Username
+	changeEmail := update.Email != "" && update.Email != u.Email
+	if !changeUsername && !changeEmail {
+		apierror.Error(w, "Must provide a new username or email", http.StatusBadRequest)
+		return
+	}
+
+	// Everything is checked before anything changes, so that a request that
+	// fails doesn't half apply
+	if changeUsername {
+		if !validUsername(w, update.Username) {
+			return
+		}
+
+		if next := u.UsernameChanged.Add(usernameChangeCooldown); time.Now().Before(next) {
+			apierror.Error(w, "Username can't be changed again until "+next.Format(time.RFC1123), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if changeEmail {
+		if _, err = a.db.GetUserFromEmail(update.Email); err == nil {
+			invalidField(w, "email", "Email is already in use")
+			return
+		} else if _, ok := err.(*database.NotFoundError); !ok {
+			handleDbErr(err, w)
+			return
+		}
+
+		if !a.allowVerification(w, u.Id) {
+			return
+		}
+	}
+
+	username, pendingEmail, token, tokenHash := "", "", "", ""
+	if changeUsername {
+		username = update.Username
+	}
+	if changeEmail {
+		pendingEmail = update.Email
+		if token, tokenHash, err = generateToken(); err != nil {
+			log.Printf("Error generating verification token: %v\n", err)
+			apierror.Error(w, "Could not send verification email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The username and verification are stored together, and the email is
+	// only sent once they are, so a failure can't leave a username changed
+	// without its verification
+	err = a.db.UpdateAccount(u.Id, username, pendingEmail, tokenHash, time.Now().Add(verificationExpiry))
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if changeUsername {
+		u.Username = update.Username
+		a.publishUser(u)
+	}
+
+	if changeEmail {
+		a.mailVerification(u, pendingEmail, token)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.User{
+		Id:             u.Id,
+		Identification: models.Identification{Username: u.Username},
+		Email:          u.Email,
+		Private:        u.Private,
+		Role:           u.Role,
+		Verified:       u.Verified,
+		PendingEmail:   pendingEmail,
+	})
+}
+
+// publishUser tells the user's open clients and their followers about a new
+// username. Everything stored refers to users by ID and looks the username up
+// when it's read, so only the clients that already have it need to be told
+func (a *userApi) publishUser(u models.User) {
+	followerIds, err := a.db.GetFollowerIds(u.Id)
+	if err != nil {
+		log.Printf("Error getting followers to publish user %v: %v\n", u.Id, err)
+		followerIds = []string{}
+	}
+
+	a.hub.publish(userEvent, models.User{
+		Id:             u.Id,
+		Identification: models.Identification{Username: u.Username},
+	}, append(followerIds, u.Id)...)
+}
+
 func (a *userApi) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 	var id models.Identification
 	err := json.NewDecoder(r.Body).Decode(&id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	u, err := a.db.GetUserFromUsername(id.Username)
 	if err != nil {
 		if _, ok := err.(*database.NotFoundError); ok {
-			http.Error(w, "Username or password does not match", http.StatusUnauthorized)
+			apierror.Error(w, "Username or password does not match", http.StatusUnauthorized)
 			return
 		}
 		handleDbErr(err, w)
@@ -129,14 +324,28 @@ func (a *userApi) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !passwordMatches(u.Password, id.Password) {
-		http.Error(w, "Username or password does not match", http.StatusUnauthorized)
+		apierror.Error(w, "Username or password does not match", http.StatusUnauthorized)
+		return
+	}
+
+	if u.Suspended {
+		apierror.Error(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	if u.TwoFactor {
+		a.startPendingLogin(w, u)
+		return
+	}
+
+	if !a.cancelDeletion(w, u) {
 		return
 	}
 
 	s, err := generateSession(u.Id)
 	if err != nil {
 		log.Printf("Error generating session ID: %v\n", err.Error())
-		http.Error(w, "Error generating session ID", http.StatusInternalServerError)
+		apierror.Error(w, "Error generating session ID", http.StatusInternalServerError)
 		return
 	}
 
@@ -153,7 +362,7 @@ func (a *userApi) UserLoginPost(w http.ResponseWriter, r *http.Request) {
 func (a *userApi) UserLogoutPost(w http.ResponseWriter, r *http.Request) {
 	sessionId, err := getSessionId(r)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
 		return
 	}
 
@@ -170,7 +379,7 @@ func (a *userApi) UserLogoutPost(w http.ResponseWriter, r *http.Request) {
 func (a *userApi) UserInvalidatePost(w http.ResponseWriter, r *http.Request) {
 	sessionId, err := getSessionId(r)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
 		return
 	}
 
@@ -184,40 +393,53 @@ func (a *userApi) UserInvalidatePost(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *userApi) UserDeletePost(w http.ResponseWriter, r *http.Request) {
-	var id models.Identification
-	err := json.NewDecoder(r.Body).Decode(&id)
+	var confirmation models.Confirmation
+	err := json.NewDecoder(r.Body).Decode(&confirmation)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	sessionId, err := getSessionId(r)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusBadRequest)
+		apierror.Error(w, "Not logged in", http.StatusBadRequest)
 		return
 	}
 
 	u, err := a.db.GetUserFromSession(sessionId)
 	if err != nil {
 		if _, ok := err.(*database.NotFoundError); ok {
-			http.Error(w, "Username or password does not match", http.StatusUnauthorized)
+			apierror.Error(w, "Username or password does not match", http.StatusUnauthorized)
 			return
 		}
 		handleDbErr(err, w)
 		return
 	}
 
-	if !passwordMatches(u.Password, id.Password) {
-		http.Error(w, "Username or password does not match", http.StatusUnauthorized)
+	if !a.confirmPassword(w, r, u, confirmation.Password) {
+		return
+	}
+
+	if !a.requireSecondFactor(w, u, confirmation.Code) {
 		return
 	}
 
-	if err = a.db.DeleteUser(u.Id); err != nil {
+	purgeTime := time.Now().Add(accountDeletionGracePeriod)
+	if err = a.db.ScheduleUserDeletion(u.Id, purgeTime); err != nil {
 		handleDbErr(err, w)
 		return
 	}
 	deleteSessionCookie(w)
 
+	a.sendMail(mail.Message{
+		To:      u.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hi %v,\n\n"+
+			"Your account and everything you've posted will be deleted on %v. "+
+			"If you change your mind, log in before then to keep your account:\n\n%v/\n",
+			u.Username, purgeTime.Format("January 2, 2006"), a.appUrl),
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
@@ -226,20 +448,20 @@ func (a *userApi) UserPasswordPost(w http.ResponseWriter, r *http.Request) {
 	var change models.PasswordChange
 	err := json.NewDecoder(r.Body).Decode(&change)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	sessionId, err := getSessionId(r)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusBadRequest)
+		apierror.Error(w, "Not logged in", http.StatusBadRequest)
 		return
 	}
 
 	u, err := a.db.GetUserFromSession(sessionId)
 	if err != nil {
 		if _, ok := err.(*database.NotFoundError); ok {
-			http.Error(w, "Password does not match", http.StatusUnauthorized)
+			apierror.Error(w, "Password does not match", http.StatusUnauthorized)
 			return
 		}
 		handleDbErr(err, w)
@@ -247,14 +469,14 @@ func (a *userApi) UserPasswordPost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !passwordMatches(u.Password, change.Password) {
-		http.Error(w, "Password does not match", http.StatusUnauthorized)
+		apierror.Error(w, "Password does not match", http.StatusUnauthorized)
 		return
 	}
 
 	password, err := hashPassword(change.NewPassword)
 	if err != nil {
 		log.Printf("Error hashing password: %v\n", err.Error())
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		apierror.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
 
@@ -267,18 +489,118 @@ func (a *userApi) UserPasswordPost(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (a *userApi) UserAuthenticatedGet(w http.ResponseWriter, r *http.Request) {
+// UserPasswordResetPost emails a password reset link to the user with the
+// given email, it succeeds whether or not the user exists so that it can't be
+// used to find out who has an account
+func (a *userApi) UserPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var req models.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u, err := a.db.GetUserFromEmail(req.Email)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); !ok {
+			handleDbErr(err, w)
+			return
+		}
+	} else {
+		token, tokenHash, err := generateToken()
+		if err != nil {
+			log.Printf("Error generating reset token: %v\n", err.Error())
+			apierror.Error(w, "Error generating reset token", http.StatusInternalServerError)
+			return
+		}
+
+		if err = a.db.CreatePasswordReset(u.Id, tokenHash, time.Now().Add(passwordResetExpiry)); err != nil {
+			handleDbErr(err, w)
+			return
+		}
+
+		a.sendMail(mail.Message{
+			To:      u.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %v,\n\n"+
+				"Someone asked to reset the password for your account. If it was you, "+
+				"you can choose a new password here:\n\n%v/reset-password?token=%v\n\n"+
+				"The link expires in an hour. If you didn't ask for a reset you can ignore this email.\n",
+				u.Username, a.appUrl, url.QueryEscape(token)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *userApi) UserPasswordResetConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var reset models.PasswordReset
+	if err := json.NewDecoder(r.Body).Decode(&reset); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if reset.Token == "" || reset.NewPassword == "" {
+		apierror.Error(w, "Must provide a token and a new password", http.StatusBadRequest)
+		return
+	}
+
+	password, err := hashPassword(reset.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %v\n", err.Error())
+		apierror.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	if err = a.db.ConfirmPasswordReset(hashToken(reset.Token), password); err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			apierror.Error(w, "Reset token is invalid or has expired", http.StatusBadRequest)
+			return
+		}
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *userApi) UserPrivacyPost(w http.ResponseWriter, r *http.Request) {
 	sessionId, err := getSessionId(r)
 	if err != nil {
-		http.Error(w, "Not logged in", http.StatusBadRequest)
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	var privacy models.Privacy
+	if err = json.NewDecoder(r.Body).Decode(&privacy); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if _, err = a.db.GetUserFromSession(sessionId); err != nil {
+	if err = a.db.SetPrivate(u.Id, privacy.Private); err != nil {
 		handleDbErr(err, w)
 		return
 	}
 
+	setRecommendsPrivacy(&privacyResource{Id: u.Id, Private: privacy.Private})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(privacy)
+}
+
+func (a *userApi) UserAuthenticatedGet(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticate(a.db, w, r, models.ReadScope); !ok {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
@@ -288,7 +610,7 @@ func (a *userApi) UsersSearchGet(w http.ResponseWriter, r *http.Request) {
 
 	username := params.Get("username")
 	if username == "" {
-		http.Error(w, "Query parameter 'username' required", http.StatusBadRequest)
+		apierror.Error(w, "Query parameter 'username' required", http.StatusBadRequest)
 		return
 	}
 
@@ -318,7 +640,7 @@ func (a *userApi) UsersExactGet(w http.ResponseWriter, r *http.Request) {
 
 	username, ok := vars["username"]
 	if !ok {
-		http.Error(w, "Must provide a username", http.StatusBadRequest)
+		apierror.Error(w, "Must provide a username", http.StatusBadRequest)
 		return
 	}
 
@@ -334,5 +656,6 @@ func (a *userApi) UsersExactGet(w http.ResponseWriter, r *http.Request) {
 		Id:             u.Id,
 		Identification: models.Identification{Username: u.Username},
 		Email:          u.Email,
+		Private:        u.Private,
 	})
 }
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/mail"
+	"github.com/jbrunsting/transient/backend/models"
+	"github.com/jbrunsting/transient/common/apierror"
+)
+
+const (
+	postAction    = "post"
+	commentAction = "comment"
+	voteAction    = "vote"
+	followAction  = "follow"
+
+	defaultUnverifiedRestrictions = postAction
+
+	verificationExpiry      = 24 * time.Hour
+	verificationResendDelay = time.Minute
+	maxVerificationsPerDay  = 5
+)
+
+// restrictions are the actions that users can't take until they verify their
+// email
+type restrictions map[string]bool
+
+// newRestrictions reads the restricted actions from the comma separated
+// UNVERIFIED_RESTRICTIONS environment variable, which can be set to "none"
+func newRestrictions() restrictions {
+	value, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !ok {
+		value = defaultUnverifiedRestrictions
+	}
+
+	r := restrictions{}
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		switch action {
+		case postAction, commentAction, voteAction, followAction:
+			r[action] = true
+		case "", "none":
+		default:
+			log.Printf("Unknown unverified restriction %v\n", action)
+		}
+	}
+
+	return r
+}
+
+// allow writes an error response and returns false if the user can't take the
+// action because they haven't verified their email
+func (r restrictions) allow(w http.ResponseWriter, u models.User, action string) bool {
+	if r[action] && !u.Verified {
+		apierror.Error(w, "Must verify your email to "+action, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// sendVerification emails the user a link that verifies they own email
+func (a *userApi) sendVerification(u models.User, email string) error {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	if err = a.db.CreateEmailVerification(u.Id, email, tokenHash, time.Now().Add(verificationExpiry)); err != nil {
+		return err
+	}
+
+	a.mailVerification(u, email, token)
+	return nil
+}
+
+// mailVerification sends the link for a verification that is already stored
+func (a *userApi) mailVerification(u models.User, email, token string) {
+	a.sendMail(mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %v,\n\n"+
+			"Please confirm this is your email address by following this link:\n\n%v/verify?token=%v\n\n"+
+			"The link expires in a day.\n",
+			u.Username, a.appUrl, url.QueryEscape(token)),
+	})
+}
+
+// allowVerification writes an error response and returns false if the user has
+// been sent too many verification emails recently
+func (a *userApi) allowVerification(w http.ResponseWriter, id string) bool {
+	now := time.Now()
+	recent, err := a.db.CountEmailVerifications(id, now.Add(-verificationResendDelay))
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	daily, err := a.db.CountEmailVerifications(id, now.Add(-24*time.Hour))
+	if err != nil {
+		handleDbErr(err, w)
+		return false
+	}
+
+	if recent > 0 || daily >= maxVerificationsPerDay {
+		apierror.Error(w, "Too many verification emails, please try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+func (a *userApi) UserVerifyGet(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		apierror.Error(w, "Must provide a token", http.StatusBadRequest)
+		return
+	}
+
+	u, err := a.db.VerifyEmail(hashToken(token))
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			apierror.Error(w, "Verification token is invalid or has expired", http.StatusBadRequest)
+			return
+		}
+		handleDbErr(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(models.User{
+		Id:             u.Id,
+		Identification: models.Identification{Username: u.Username},
+		Email:          u.Email,
+		Verified:       u.Verified,
+	})
+}
+
+func (a *userApi) UserVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	sessionId, err := getSessionId(r)
+	if err != nil {
+		apierror.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+
+	u, err := a.db.GetUserFromSession(sessionId)
+	if err != nil {
+		handleDbErr(err, w)
+		return
+	}
+
+	if u.Verified {
+		apierror.Error(w, "Email is already verified", http.StatusBadRequest)
+		return
+	}
+
+	if !a.allowVerification(w, u.Id) {
+		return
+	}
+
+	if err = a.sendVerification(u, u.Email); err != nil {
+		log.Printf("Error sending verification email: %v\n", err)
+		apierror.Error(w, "Could not send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
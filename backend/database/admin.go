@@ -0,0 +1,144 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type adminHandler struct {
+	db *sql.DB
+}
+
+// GetUserStats lists the users whose username or email contains search, or
+// every user if search is empty
+func (h *adminHandler) GetUserStats(search string, limit, offset int) ([]models.UserStats, error) {
+	users := []models.UserStats{}
+
+	rows, err := h.db.Query(`
+	SELECT id, username, email, role, private, suspended,
+		(SELECT COUNT(*) FROM Posts WHERE Posts.id = Users.id),
+		(SELECT COUNT(*) FROM Followings WHERE Followings.followingId = Users.id),
+		(SELECT COUNT(*) FROM Followings WHERE Followings.id = Users.id),
+		(SELECT COUNT(*) FROM Reports WHERE Reports.targetType = $4 AND Reports.targetId = Users.id),
+		(SELECT COUNT(*) FROM Sessions WHERE Sessions.id = Users.id AND Sessions.expiry > $5)
+	FROM Users
+	WHERE $1 = '' OR strpos(lower(username), lower($1)) > 0 OR strpos(lower(email), lower($1)) > 0
+	ORDER BY username
+	LIMIT $2 OFFSET $3`, search, limit, offset, models.UserTarget, time.Now())
+	if err != nil {
+		return users, formatError(err, "users", "getting user stats")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.UserStats
+		if err = rows.Scan(&u.Id, &u.Username, &u.Email, &u.Role, &u.Private, &u.Suspended,
+			&u.Posts, &u.Followers, &u.Followings, &u.Reports, &u.Sessions); err != nil {
+			break
+		}
+
+		users = append(users, u)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return users, &UnexpectedError{
+			Action:        "parsing user stats",
+			InternalError: err.Error(),
+		}
+	}
+
+	return users, nil
+}
+
+func (h *adminHandler) GetSystemStats() (models.SystemStats, error) {
+	var stats models.SystemStats
+	now := time.Now()
+	err := h.db.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM Users),
+		(SELECT COUNT(*) FROM Posts WHERE time > $1 AND NOT hidden),
+		(SELECT COUNT(*) FROM Sessions WHERE expiry > $2),
+		(SELECT COUNT(*) FROM Reports WHERE status = $3)`,
+		now.Add(-models.PostLifetime), now, models.ReportOpen).Scan(
+		&stats.Users, &stats.LivePosts, &stats.Sessions, &stats.OpenReports)
+	return stats, formatError(err, "stats", "getting system stats")
+}
+
+func (h *adminHandler) SetRole(a models.ModerationAction, role string) error {
+	return moderate(h.db, &a, "", func(tx *sql.Tx) error {
+		return execOne(tx, `UPDATE Users SET role = $2 WHERE id = $1`, a.TargetId, role)
+	})
+}
+
+// ForcePasswordReset replaces the user's password and revokes all of their
+// sessions, API tokens, and logins waiting on a second factor
+func (h *adminHandler) ForcePasswordReset(a models.ModerationAction, password string) error {
+	return moderate(h.db, &a, "", func(tx *sql.Tx) error {
+		err := execOne(tx, `UPDATE Users SET password = $2 WHERE id = $1`, a.TargetId, password)
+		if err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			`DELETE FROM Sessions WHERE id = $1`,
+			`DELETE FROM ApiTokens WHERE id = $1`,
+			`DELETE FROM PendingLogins WHERE id = $1`,
+		} {
+			if _, err = tx.Exec(query, a.TargetId); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteUserPosts deletes every post by the user, along with their votes and
+// comments, and returns the IDs of the deleted posts
+func (h *adminHandler) DeleteUserPosts(a models.ModerationAction) ([]string, error) {
+	postIds := []string{}
+	err := moderate(h.db, &a, "", func(tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRow(`SELECT 1 FROM Users WHERE id = $1`, a.TargetId).Scan(&exists); err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(`SELECT postId FROM Posts WHERE id = $1`, a.TargetId)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var postId string
+			if err = rows.Scan(&postId); err != nil {
+				return err
+			}
+			postIds = append(postIds, postId)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(`DELETE FROM Votes WHERE postId = ANY($1)`, pq.Array(postIds)); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(`DELETE FROM Comments WHERE postId = ANY($1)`, pq.Array(postIds)); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM Posts WHERE id = $1`, a.TargetId)
+		return err
+	})
+
+	return postIds, err
+}
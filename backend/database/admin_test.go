@@ -0,0 +1,177 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestGetUserStats(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "mod")
+
+	createTestPost(t, h, "alice-id", "post")
+	if _, err := h.CreateFollowing("bob-id", "alice-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+	if err := h.CreateReport(report("report", "mod-id", models.UserTarget, "alice-id")); err != nil {
+		t.Fatalf("Could not create report: %v", err)
+	}
+
+	users, err := h.GetUserStats("ALI", 10, 0)
+	want := []models.UserStats{{
+		Id:        "alice-id",
+		Username:  "alice",
+		Email:     "alice@example.com",
+		Role:      models.UserRole,
+		Posts:     1,
+		Followers: 1,
+		Reports:   1,
+		Sessions:  1,
+	}}
+	if err != nil || !reflect.DeepEqual(users, want) {
+		t.Errorf("Got stats %+v, %v, want %+v", users, err, want)
+	}
+
+	users, err = h.GetUserStats("", 2, 1)
+	if err != nil || len(users) != 2 || users[0].Username != "bob" || users[0].Followings != 1 {
+		t.Errorf("Got stats %+v, %v, want bob then mod", users, err)
+	}
+
+	users, err = h.GetUserStats("nobody", 10, 0)
+	if err != nil || len(users) != 0 {
+		t.Errorf("Got stats %+v, %v, want none", users, err)
+	}
+
+	_, err = h.GetUserStats("", 10, -1)
+	checkError(t, err, &DataViolation{})
+}
+
+func TestGetSystemStats(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+
+	createTestPost(t, h, "alice-id", "post")
+	old := models.Post{Id: "alice-id", PostId: "old-post", Time: time.Now().Add(-2 * models.PostLifetime), Title: "Old", Format: models.PlainFormat}
+	if err := h.CreatePost(old); err != nil {
+		t.Fatalf("Could not create post: %v", err)
+	}
+	if err := h.CreateSession(models.Session{Id: "alice-id", SessionId: "expired", Expiry: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Could not create session: %v", err)
+	}
+	if err := h.CreateReport(report("report", "mod-id", models.PostTarget, "post")); err != nil {
+		t.Fatalf("Could not create report: %v", err)
+	}
+
+	stats, err := h.GetSystemStats()
+	want := models.SystemStats{Users: 2, LivePosts: 1, Sessions: 2, OpenReports: 1}
+	if err != nil || stats != want {
+		t.Errorf("Got stats %+v, %v, want %+v", stats, err, want)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+
+	if err := h.SetRole(action("role", models.RoleAction, models.UserTarget, "alice-id"), models.ModeratorRole); err != nil {
+		t.Fatalf("Could not set role: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || u.Role != models.ModeratorRole {
+		t.Errorf("Got role %v, %v, want moderator", u.Role, err)
+	}
+
+	err = h.SetRole(action("unknown", models.RoleAction, models.UserTarget, "carol-id"), models.ModeratorRole)
+	checkError(t, err, &NotFoundError{})
+
+	err = h.SetRole(action("long", models.RoleAction, models.UserTarget, "alice-id"), "a-role-that-is-far-too-long")
+	checkError(t, err, &DataViolation{})
+
+	actions, err := h.GetModerationActions(10)
+	if err != nil || len(actions) != 1 || actions[0].ActionId != "role" {
+		t.Errorf("Got actions %+v, %v, want only the role change", actions, err)
+	}
+}
+
+func TestForcePasswordReset(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+
+	tok := models.ApiToken{
+		TokenId:   "token",
+		Id:        "alice-id",
+		Name:      "token",
+		Scopes:    []string{models.ReadScope},
+		Time:      time.Now(),
+		Expiry:    time.Now().Add(time.Hour),
+		TokenHash: "token-hash",
+	}
+	if err := h.CreateApiToken(tok); err != nil {
+		t.Fatalf("Could not create token: %v", err)
+	}
+	if err := h.CreatePendingLogin("alice-id", "pending", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not create pending login: %v", err)
+	}
+
+	if err := h.ForcePasswordReset(action("reset", models.PasswordResetAction, models.UserTarget, "alice-id"), "new-hash"); err != nil {
+		t.Fatalf("Could not reset password: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || u.Password != "new-hash" || len(u.Sessions) != 0 {
+		t.Errorf("Got user %+v, %v, want new password and no sessions", u, err)
+	}
+
+	count, err := h.CountApiTokens("alice-id")
+	if err != nil || count != 0 {
+		t.Errorf("Got %v, %v tokens, want none", count, err)
+	}
+
+	_, err = h.GetPendingLogin("pending", 2)
+	checkError(t, err, &NotFoundError{})
+
+	err = h.ForcePasswordReset(action("unknown", models.PasswordResetAction, models.UserTarget, "carol-id"), "new-hash")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestDeleteUserPosts(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "mod")
+
+	createTestPost(t, h, "alice-id", "alice-post")
+	createTestPost(t, h, "bob-id", "bob-post")
+	if err := h.CreateVote("bob-id", "alice-post", models.UPVOTE); err != nil {
+		t.Fatalf("Could not create vote: %v", err)
+	}
+
+	postIds, err := h.DeleteUserPosts(action("delete", models.DeletePostsAction, models.UserTarget, "alice-id"))
+	if err != nil || !reflect.DeepEqual(postIds, []string{"alice-post"}) {
+		t.Errorf("Got %v, %v, want alice-post deleted", postIds, err)
+	}
+
+	_, err = h.GetPost("alice-post")
+	checkError(t, err, &NotFoundError{})
+
+	if _, err = h.GetPost("bob-post"); err != nil {
+		t.Errorf("Other user's post was deleted: %v", err)
+	}
+
+	postIds, err = h.DeleteUserPosts(action("delete-again", models.DeletePostsAction, models.UserTarget, "alice-id"))
+	if err != nil || len(postIds) != 0 {
+		t.Errorf("Got %v, %v deleting again, want nothing deleted", postIds, err)
+	}
+
+	_, err = h.DeleteUserPosts(action("unknown", models.DeletePostsAction, models.UserTarget, "carol-id"))
+	checkError(t, err, &NotFoundError{})
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+type blockHandler struct {
+	db *sql.DB
+}
+
+// CreateBlock blocks blockedId for id, removing any followings or follow
+// requests between the two users in either direction
+func (h *blockHandler) CreateBlock(id, blockedId string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "block", "starting database transaction")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Blocks (id, blockedId, time)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`, id, blockedId, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "block", "creating block")
+	}
+
+	_, err = tx.Exec(`
+	DELETE FROM Followings
+	WHERE (id = $1 AND followingId = $2) OR (id = $2 AND followingId = $1)`, id, blockedId)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "following", "deleting followings")
+	}
+
+	_, err = tx.Exec(`
+	DELETE FROM FollowRequests
+	WHERE (id = $1 AND followingId = $2) OR (id = $2 AND followingId = $1)`, id, blockedId)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "follow request", "deleting follow requests")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "block", "committing database transaction")
+}
+
+func (h *blockHandler) DeleteBlock(id, blockedId string) error {
+	_, err := h.db.Exec(`DELETE FROM Blocks WHERE id = $1 AND blockedId = $2`, id, blockedId)
+	return formatError(err, "block", "deleting block")
+}
+
+// IsBlocked returns true if either user has blocked the other
+func (h *blockHandler) IsBlocked(id, otherId string) (bool, error) {
+	var blocked bool
+	err := h.db.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM Blocks
+		WHERE (id = $1 AND blockedId = $2) OR (id = $2 AND blockedId = $1)
+	)`, id, otherId).Scan(&blocked)
+	return blocked, formatError(err, "block", "checking block")
+}
+
+func (h *blockHandler) CreateMute(id, mutedId string) error {
+	_, err := h.db.Exec(`
+	INSERT INTO Mutes (id, mutedId, time)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`, id, mutedId, time.Now())
+	return formatError(err, "mute", "creating mute")
+}
+
+func (h *blockHandler) DeleteMute(id, mutedId string) error {
+	_, err := h.db.Exec(`DELETE FROM Mutes WHERE id = $1 AND mutedId = $2`, id, mutedId)
+	return formatError(err, "mute", "deleting mute")
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	if _, err := h.CreateFollowing("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+	if _, err := h.CreateFollowing("bob-id", "alice-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+	if _, err := h.CreateFollowRequest("bob-id", "carol-id"); err != nil {
+		t.Fatalf("Could not create follow request: %v", err)
+	}
+
+	err := h.CreateBlock("alice-id", "dave-id")
+	checkError(t, err, &ForeignKeyViolation{})
+
+	for _, b := range [][2]string{{"alice-id", "bob-id"}, {"alice-id", "bob-id"}, {"carol-id", "bob-id"}} {
+		if err = h.CreateBlock(b[0], b[1]); err != nil {
+			t.Fatalf("Could not create block: %v", err)
+		}
+	}
+
+	for _, f := range [][2]string{{"alice-id", "bob-id"}, {"bob-id", "alice-id"}} {
+		following, err := h.IsFollowing(f[0], f[1])
+		if err != nil || following {
+			t.Errorf("Got following %v, %v from %v to %v after block, want false", following, err, f[0], f[1])
+		}
+	}
+
+	requests, err := h.GetFollowRequests("carol-id")
+	if err != nil || len(requests) != 0 {
+		t.Errorf("Got requests %+v, %v after block, want none", requests, err)
+	}
+
+	tests := []struct {
+		id, otherId string
+		blocked     bool
+	}{
+		{"alice-id", "bob-id", true},
+		{"bob-id", "alice-id", true},
+		{"alice-id", "carol-id", false},
+		{"alice-id", "dave-id", false},
+	}
+	for _, test := range tests {
+		blocked, err := h.IsBlocked(test.id, test.otherId)
+		if err != nil || blocked != test.blocked {
+			t.Errorf("Got blocked %v, %v for %v and %v, want %v", blocked, err, test.id, test.otherId, test.blocked)
+		}
+	}
+
+	// Only the user who blocked can unblock
+	if err = h.DeleteBlock("bob-id", "alice-id"); err != nil {
+		t.Fatalf("Could not delete block: %v", err)
+	}
+	if blocked, _ := h.IsBlocked("alice-id", "bob-id"); !blocked {
+		t.Errorf("Block removed by the blocked user")
+	}
+
+	if err = h.DeleteBlock("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not delete block: %v", err)
+	}
+	if blocked, _ := h.IsBlocked("alice-id", "bob-id"); blocked {
+		t.Errorf("Block wasn't removed")
+	}
+}
+
+func TestMutes(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	if _, err := h.CreateFollowing("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+	createTestPost(t, h, "bob-id", "post")
+
+	err := h.CreateMute("alice-id", "dave-id")
+	checkError(t, err, &ForeignKeyViolation{})
+
+	for i := 0; i < 2; i++ {
+		if err = h.CreateMute("alice-id", "bob-id"); err != nil {
+			t.Fatalf("Could not create mute: %v", err)
+		}
+	}
+
+	posts, err := h.GetFollowingsPosts("alice-id")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("Got posts %v, %v while muted, want none", postIds(posts), err)
+	}
+
+	if err = h.DeleteMute("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not delete mute: %v", err)
+	}
+
+	posts, err = h.GetFollowingsPosts("alice-id")
+	if err != nil || len(posts) != 1 {
+		t.Errorf("Got posts %v, %v after unmuting, want bob's post", postIds(posts), err)
+	}
+}
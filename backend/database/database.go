@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/jbrunsting/transient/backend/models"
 )
@@ -10,28 +11,120 @@ type DatabaseHandler interface {
 	GetUserFromUsername(username string) (models.User, error)
 	GetUserFromSession(sessionId string) (models.User, error)
 	GetUserFromId(sessionId string) (models.User, error)
+	GetUserFromEmail(email string) (models.User, error)
+	GetUserFromIdentity(provider, subject string) (models.User, error)
 	GetBasicUsers(ids []string) ([]models.User, error)
 	CreateUser(u models.User, s models.Session) error
 	CreateSession(s models.Session) error
 	DeleteOtherSessions(currentSessionId string) error
 	DeleteSession(sessionId string) error
-	DeleteUser(id string) error
 	ChangePassword(id string, password string) error
+	CreatePasswordReset(id, tokenHash string, expiry time.Time) error
+	ConfirmPasswordReset(tokenHash, password string) error
+	CreateEmailVerification(id, email, tokenHash string, expiry time.Time) error
+	CountEmailVerifications(id string, since time.Time) (int, error)
+	VerifyEmail(tokenHash string) (models.User, error)
+	UpdateAccount(id, username, email, tokenHash string, expiry time.Time) error
+	SetPrivate(id string, private bool) error
 	SearchUsers(search string, limit int) ([]models.User, error)
 
-	GetUserPosts(id string) ([]models.Post, error)
+	SetTotpSecret(id, secret string) error
+	EnableTotp(id string, step int64, codeHashes []string) error
+	DisableTotp(id string) error
+	UseTotpStep(id string, step int64) error
+	UseRecoveryCode(id, codeHash string) error
+	CreatePendingLogin(id, tokenHash string, expiry time.Time) error
+	GetPendingLogin(tokenHash string, maxAttempts int) (string, error)
+	FailPendingLogin(tokenHash string) error
+	CompletePendingLogin(tokenHash string, s models.Session) error
+
+	CreateOidcLogin(l models.OidcLogin) error
+	UseOidcLogin(stateHash string) (models.OidcLogin, error)
+	ReauthenticateSession(sessionId string) error
+	SessionReauthenticated(sessionId string, since time.Time) (bool, error)
+	CreateIdentity(i models.Identity) error
+	CreateIdentityUser(u models.User, i models.Identity, s models.Session) error
+	GetIdentities(id string) ([]models.Identity, error)
+	DeleteIdentity(id, provider, subject string) error
+
+	CreateApiToken(t models.ApiToken) error
+	GetApiTokens(id string) ([]models.ApiToken, error)
+	CountApiTokens(id string) (int, error)
+	UseApiToken(tokenHash string) (models.ApiToken, error)
+	DeleteApiToken(id, tokenId string) error
+
+	CreateUserExport(e models.UserExport) error
+	FinishUserExport(exportId, status string, data []byte) error
+	GetUserExport(id, exportId string) (models.UserExport, error)
+	DeleteExpiredUserExports(pendingBefore time.Time) error
+	GetAllUserPosts(id string) ([]models.Post, error)
+	GetUserComments(id string) ([]models.Comment, error)
+	GetUserVotes(id string) ([]models.Vote, error)
+
+	GetProfile(id, viewerId string) (models.Profile, error)
+	UpdateProfile(id string, update models.ProfileUpdate) error
+
+	ScheduleUserDeletion(id string, purgeTime time.Time) error
+	CancelUserDeletion(id string) error
+	GetDueUserDeletions(before time.Time) ([]string, error)
+	PurgeUser(id string, before time.Time) ([]string, error)
+
+	GetUserPosts(id, viewerId string) ([]models.Post, error)
 	GetPost(postId string) (models.Post, error)
-	GetPosts(postIds []string) ([]models.Post, error)
+	GetPosts(postIds []string, viewerId string) ([]models.Post, error)
+	CanViewPost(postId, viewerId string) error
 	CreatePost(p models.Post) error
+	UpdatePostPreview(postId string, preview models.LinkPreview) error
 	DeletePost(postId string) error
 	GetFollowingsPosts(id string) ([]models.Post, error)
+	SearchPosts(query, viewerId string, after *models.SearchResult, limit int) ([]models.SearchResult, error)
 	CreateVote(id string, postId string, vote int) error
+	GetVoteCounts(postId string) (models.VoteCounts, error)
 	CreateComment(postId string, c models.Comment) error
-	GetComments(postId string) ([]models.Comment, error)
+	GetComments(postId, viewerId string) ([]models.Comment, error)
+
+	GetTagPosts(tag, viewerId string) ([]models.Post, error)
+	GetTrendingTags(since time.Time, limit int) ([]models.TrendingTag, error)
 
-	CreateFollowing(id, followingId string) error
+	CreateNotification(n models.Notification) (bool, error)
+	GetNotifications(id string, unreadOnly bool, limit int) ([]models.Notification, error)
+	GetUnreadNotificationCount(id string) (int, error)
+	MarkNotificationsRead(id string, notificationIds []string) error
+
+	CreateFollowing(id, followingId string) (bool, error)
+	IsFollowing(id, followingId string) (bool, error)
 	GetFollowings(id string) ([]models.User, error)
+	GetFollowerIds(id string) ([]string, error)
+	GetFollowerList(id, after string, limit int) ([]models.FollowEntry, error)
+	GetFollowingList(id, after string, limit int) ([]models.FollowEntry, error)
+	GetKnownFollowers(id, viewerId string, limit int) (models.KnownFollowers, error)
+	GetImportTargets(id string, usernames []string) ([]models.ImportTarget, error)
+	ImportFollowings(id string, followingIds, requestIds []string) ([]string, []string, error)
 	DeleteFollowing(id, followingId string) error
+	CreateFollowRequest(id, followingId string) (bool, error)
+	GetFollowRequests(followingId string) ([]models.FollowRequest, error)
+	AcceptFollowRequest(id, followingId string) error
+	RejectFollowRequest(id, followingId string) error
+
+	CreateBlock(id, blockedId string) error
+	DeleteBlock(id, blockedId string) error
+	IsBlocked(id, otherId string) (bool, error)
+	CreateMute(id, mutedId string) error
+	DeleteMute(id, mutedId string) error
+
+	CreateReport(r models.Report) error
+	GetReports(status string, limit int) ([]models.Report, error)
+	HideContent(a models.ModerationAction) error
+	SuspendUser(a models.ModerationAction) error
+	UnsuspendUser(a models.ModerationAction) error
+	DismissReport(a models.ModerationAction) error
+	GetModerationActions(limit int) ([]models.ModerationAction, error)
+
+	GetUserStats(search string, limit, offset int) ([]models.UserStats, error)
+	GetSystemStats() (models.SystemStats, error)
+	SetRole(a models.ModerationAction, role string) error
+	ForcePasswordReset(a models.ModerationAction, password string) error
+	DeleteUserPosts(a models.ModerationAction) ([]string, error)
 
 	Close()
 }
@@ -39,8 +132,19 @@ type DatabaseHandler interface {
 type databaseHandler struct {
 	db *sql.DB
 	userHandler
+	twoFactorHandler
+	identityHandler
+	tokenHandler
+	exportHandler
+	profileHandler
+	deletionHandler
 	postHandler
 	followingHandler
+	tagHandler
+	notificationHandler
+	blockHandler
+	moderationHandler
+	adminHandler
 }
 
 func NewDatabaseHandler() (DatabaseHandler, error) {
@@ -48,7 +152,11 @@ func NewDatabaseHandler() (DatabaseHandler, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &databaseHandler{db: db, userHandler: userHandler{db}, postHandler: postHandler{db}, followingHandler: followingHandler{db}}, nil
+	return newDatabaseHandler(db), nil
+}
+
+func newDatabaseHandler(db *sql.DB) *databaseHandler {
+	return &databaseHandler{db: db, userHandler: userHandler{db}, twoFactorHandler: twoFactorHandler{db}, identityHandler: identityHandler{db}, tokenHandler: tokenHandler{db}, exportHandler: exportHandler{db}, profileHandler: profileHandler{db}, deletionHandler: deletionHandler{db}, postHandler: postHandler{db}, followingHandler: followingHandler{db}, tagHandler: tagHandler{db}, notificationHandler: notificationHandler{db}, blockHandler: blockHandler{db}, moderationHandler: moderationHandler{db}, adminHandler: adminHandler{db}}
 }
 
 func (h *databaseHandler) Close() {
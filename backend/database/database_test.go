@@ -0,0 +1,219 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+const schemaPath = "../../database/development.sql"
+
+// testServer is a throwaway Postgres instance shared by the tests in this
+// package, it is started by the first test that needs it and stopped once
+// every test has run. Each test gets its own database on it
+var testServer struct {
+	once sync.Once
+	dir  string
+	bin  string
+	dsn  string
+	err  error
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopTestServer()
+	os.Exit(code)
+}
+
+// postgresBin finds the directory holding initdb and pg_ctl, looking in the
+// PATH before asking pg_config
+func postgresBin() (string, error) {
+	if path, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(path), nil
+	}
+
+	out, err := exec.Command("pg_config", "--bindir").Output()
+	if err != nil {
+		return "", fmt.Errorf("initdb isn't in the PATH and pg_config couldn't find it: %v", err)
+	}
+
+	bin := strings.TrimSpace(string(out))
+	if _, err = os.Stat(filepath.Join(bin, "initdb")); err != nil {
+		return "", fmt.Errorf("initdb isn't in the PATH or %v, is the Postgres server installed?", bin)
+	}
+	return bin, nil
+}
+
+// startTestServer creates a cluster in a temporary directory and starts
+// Postgres on a unix socket in that directory, so it can't clash with any
+// other server on the machine
+func startTestServer() {
+	bin, err := postgresBin()
+	if err != nil {
+		testServer.err = err
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "transient-db")
+	if err != nil {
+		testServer.err = err
+		return
+	}
+	testServer.dir = dir
+	testServer.bin = bin
+
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(filepath.Join(bin, "initdb"),
+		"-D", data, "-U", "transient", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+	if err != nil {
+		testServer.err = fmt.Errorf("initdb failed: %v\n%s", err, out)
+		return
+	}
+
+	out, err = exec.Command(filepath.Join(bin, "pg_ctl"), "start", "-w",
+		"-D", data, "-l", filepath.Join(dir, "postgres.log"),
+		"-o", "-k "+dir+" -c listen_addresses='' -c fsync=off").CombinedOutput()
+	if err != nil {
+		log, _ := ioutil.ReadFile(filepath.Join(dir, "postgres.log"))
+		testServer.err = fmt.Errorf("pg_ctl start failed: %v\n%s%s", err, out, log)
+		return
+	}
+
+	testServer.dsn = "host=" + dir + " user=transient sslmode=disable"
+}
+
+func stopTestServer() {
+	if testServer.dir == "" {
+		return
+	}
+
+	if testServer.dsn != "" {
+		exec.Command(filepath.Join(testServer.bin, "pg_ctl"), "stop",
+			"-D", filepath.Join(testServer.dir, "data"), "-m", "immediate").Run()
+	}
+	os.RemoveAll(testServer.dir)
+}
+
+// newTestHandler creates a fresh database loaded with the development schema
+// and returns a handler for it, the database is dropped when the test ends.
+// The test fails if Postgres can't be started
+func newTestHandler(t *testing.T) *databaseHandler {
+	t.Helper()
+
+	testServer.once.Do(startTestServer)
+	if testServer.err != nil {
+		t.Fatalf("Could not start Postgres: %v", testServer.err)
+	}
+
+	schema, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		t.Fatalf("Could not read schema: %v", err)
+	}
+
+	server, err := sql.Open("postgres", testServer.dsn+" dbname=postgres")
+	if err != nil {
+		t.Fatalf("Could not connect to test database server: %v", err)
+	}
+
+	name := fmt.Sprintf("transient_test_%v", time.Now().UnixNano())
+	if _, err = server.Exec(`CREATE DATABASE ` + name); err != nil {
+		server.Close()
+		t.Fatalf("Could not create test database: %v", err)
+	}
+
+	db, err := sql.Open("postgres", testServer.dsn+" dbname="+name)
+	if err == nil {
+		_, err = db.Exec(string(schema))
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := server.Exec(`DROP DATABASE IF EXISTS ` + name); err != nil {
+			t.Errorf("Could not drop test database: %v", err)
+		}
+		server.Close()
+	})
+
+	if err != nil {
+		t.Fatalf("Could not load schema: %v", err)
+	}
+
+	return newDatabaseHandler(db)
+}
+
+// createTestUser creates a user with a session named after them, their ID
+// and session ID are the username with "-id" and "-session" appended
+func createTestUser(t *testing.T, h *databaseHandler, username string) models.User {
+	t.Helper()
+
+	u := models.User{
+		Id:             username + "-id",
+		Identification: models.Identification{Username: username, Password: "hash"},
+		Email:          username + "@example.com",
+	}
+	s := models.Session{
+		Id:        u.Id,
+		SessionId: username + "-session",
+		Expiry:    time.Now().Add(time.Hour),
+	}
+	if err := h.CreateUser(u, s); err != nil {
+		t.Fatalf("Could not create user %v: %v", username, err)
+	}
+
+	u.Sessions = []models.Session{s}
+	return u
+}
+
+// createTestPost creates a post by the user that was made now, with the ID
+// given
+func createTestPost(t *testing.T, h *databaseHandler, id, postId string, tags ...string) models.Post {
+	t.Helper()
+
+	p := models.Post{
+		Id:      id,
+		PostId:  postId,
+		Time:    time.Now(),
+		Title:   "Title of " + postId,
+		Content: "Content of " + postId,
+		Format:  models.PlainFormat,
+		Tags:    tags,
+	}
+	if err := h.CreatePost(p); err != nil {
+		t.Fatalf("Could not create post %v: %v", postId, err)
+	}
+
+	return p
+}
+
+// checkError fails the test unless err has the same type as want, or both
+// are nil
+func checkError(t *testing.T, err, want error) {
+	t.Helper()
+
+	if fmt.Sprintf("%T", err) != fmt.Sprintf("%T", want) {
+		t.Fatalf("Got error %#v, want %T", err, want)
+	}
+}
+
+func TestClosedDatabase(t *testing.T) {
+	h := newTestHandler(t)
+	h.Close()
+
+	_, err := h.GetUserFromId("alice-id")
+	checkError(t, err, &UnexpectedError{})
+
+	err = h.CreateSession(models.Session{Id: "alice-id", SessionId: "session", Expiry: time.Now()})
+	checkError(t, err, &UnexpectedError{})
+
+	_, err = h.CreateFollowing("alice-id", "bob-id")
+	checkError(t, err, &UnexpectedError{})
+}
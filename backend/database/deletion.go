@@ -0,0 +1,135 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+type deletionHandler struct {
+	db *sql.DB
+}
+
+// ScheduleUserDeletion marks the user to be purged at purgeTime, and logs them
+// out everywhere so that they have to log in again to cancel it
+func (h *deletionHandler) ScheduleUserDeletion(id string, purgeTime time.Time) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "user", "starting database transaction")
+	}
+
+	if err = execOne(tx, `UPDATE Users SET deletionScheduled = $2 WHERE id = $1`, id, purgeTime); err != nil {
+		tx.Rollback()
+		return formatError(err, "user", "scheduling deletion")
+	}
+
+	if _, err = tx.Exec(`DELETE FROM Sessions WHERE id = $1`, id); err != nil {
+		tx.Rollback()
+		return formatError(err, "session", "deleting sessions")
+	}
+
+	if _, err = tx.Exec(`DELETE FROM ApiTokens WHERE id = $1`, id); err != nil {
+		tx.Rollback()
+		return formatError(err, "api token", "deleting api tokens")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "user", "committing database transaction")
+}
+
+func (h *deletionHandler) CancelUserDeletion(id string) error {
+	_, err := h.db.Exec(`UPDATE Users SET deletionScheduled = NULL WHERE id = $1`, id)
+	return formatError(err, "user", "cancelling deletion")
+}
+
+// GetDueUserDeletions returns the users whose grace period ended before the
+// given time
+func (h *deletionHandler) GetDueUserDeletions(before time.Time) ([]string, error) {
+	ids := []string{}
+
+	rows, err := h.db.Query(`SELECT id FROM Users WHERE deletionScheduled <= $1`, before)
+	if err != nil {
+		return ids, formatError(err, "user", "getting due deletions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			break
+		}
+
+		ids = append(ids, id)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return ids, &UnexpectedError{
+			Action:        "parsing users",
+			InternalError: err.Error(),
+		}
+	}
+
+	return ids, nil
+}
+
+// PurgeUser deletes the user along with everything they created, including
+// the votes and comments others left on their posts, and returns the IDs of
+// the deleted posts. It does nothing if the deletion was cancelled since the
+// user was returned by GetDueUserDeletions
+func (h *deletionHandler) PurgeUser(id string, before time.Time) ([]string, error) {
+	postIds := []string{}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return postIds, formatError(err, "user", "starting database transaction")
+	}
+
+	var exists int
+	err = tx.QueryRow(`
+	SELECT 1 FROM Users WHERE id = $1 AND deletionScheduled <= $2
+	FOR UPDATE`, id, before).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		return postIds, formatError(err, "user", "locking user")
+	}
+
+	rows, err := tx.Query(`SELECT postId FROM Posts WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		return postIds, formatError(err, "post", "getting user posts")
+	}
+
+	for rows.Next() {
+		var postId string
+		if err = rows.Scan(&postId); err != nil {
+			break
+		}
+		postIds = append(postIds, postId)
+	}
+	rows.Close()
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return postIds, &UnexpectedError{
+			Action:        "parsing posts",
+			InternalError: err.Error(),
+		}
+	}
+
+	// Everything else referencing the user or their posts is deleted by the
+	// cascades
+	if _, err = tx.Exec(`DELETE FROM Users WHERE id = $1`, id); err != nil {
+		tx.Rollback()
+		return postIds, formatError(err, "user", "deleting user")
+	}
+
+	err = tx.Commit()
+	return postIds, formatError(err, "user", "committing database transaction")
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestScheduleUserDeletion(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	err := h.CreateApiToken(models.ApiToken{
+		TokenId:   "token",
+		Id:        "alice-id",
+		Name:      "token",
+		Scopes:    []string{models.ReadScope},
+		Time:      time.Now(),
+		Expiry:    time.Now().Add(time.Hour),
+		TokenHash: "token-hash",
+	})
+	if err != nil {
+		t.Fatalf("Could not create api token: %v", err)
+	}
+
+	err = h.ScheduleUserDeletion("carol-id", time.Now())
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.ScheduleUserDeletion("alice-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || u.DeletionScheduled.IsZero() || len(u.Sessions) != 0 {
+		t.Errorf("Got user %+v, %v, want deletion scheduled and no sessions", u, err)
+	}
+
+	_, err = h.UseApiToken("token-hash")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.CancelUserDeletion("alice-id"); err != nil {
+		t.Fatalf("Could not cancel deletion: %v", err)
+	}
+
+	u, err = h.GetUserFromId("alice-id")
+	if err != nil || !u.DeletionScheduled.IsZero() {
+		t.Errorf("Got user %+v, %v, want deletion cancelled", u, err)
+	}
+}
+
+func TestPurgeUser(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	createTestPost(t, h, "alice-id", "alice-post")
+	createTestPost(t, h, "bob-id", "bob-post")
+	if err := h.CreateVote("bob-id", "alice-post", models.UPVOTE); err != nil {
+		t.Fatalf("Could not create vote: %v", err)
+	}
+	if _, err := h.CreateFollowing("bob-id", "alice-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+
+	now := time.Now()
+	if err := h.ScheduleUserDeletion("alice-id", now.Add(-time.Minute)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+	if err := h.ScheduleUserDeletion("carol-id", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+
+	ids, err := h.GetDueUserDeletions(now)
+	if err != nil || !reflect.DeepEqual(ids, []string{"alice-id"}) {
+		t.Errorf("Got %v, %v, want alice-id due", ids, err)
+	}
+
+	_, err = h.PurgeUser("carol-id", now)
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.PurgeUser("bob-id", now)
+	checkError(t, err, &NotFoundError{})
+
+	postIds, err := h.PurgeUser("alice-id", now)
+	if err != nil || !reflect.DeepEqual(postIds, []string{"alice-post"}) {
+		t.Errorf("Got %v, %v, want alice-post deleted", postIds, err)
+	}
+
+	_, err = h.GetUserFromId("alice-id")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.GetPost("alice-post")
+	checkError(t, err, &NotFoundError{})
+
+	votes, err := h.GetUserVotes("bob-id")
+	if err != nil || len(votes) != 0 {
+		t.Errorf("Got votes %+v, %v, want bob's vote on the purged post deleted", votes, err)
+	}
+
+	followings, err := h.GetFollowings("bob-id")
+	if err != nil || len(followings) != 0 {
+		t.Errorf("Got followings %+v, %v, want none", followings, err)
+	}
+
+	if _, err = h.GetPost("bob-post"); err != nil {
+		t.Errorf("Other user's post was deleted: %v", err)
+	}
+
+	_, err = h.PurgeUser("alice-id", now)
+	checkError(t, err, &NotFoundError{})
+}
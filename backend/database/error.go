@@ -39,6 +39,24 @@ func (e *UniquenessViolation) Error() string {
 	return fmt.Sprintf("Uniqueness constraint violated for %v", e.Object)
 }
 
+// ForeignKeyViolation is returned when a row refers to one that doesn't exist
+type ForeignKeyViolation struct {
+	Object string
+}
+
+func (e *ForeignKeyViolation) Error() string {
+	return fmt.Sprintf("Could not find what the %v refers to", e.Object)
+}
+
+type CheckViolation struct {
+	Object     string
+	Constraint string
+}
+
+func (e *CheckViolation) Error() string {
+	return fmt.Sprintf("Check constraint %v violated for %v", e.Constraint, e.Object)
+}
+
 type UnexpectedError struct {
 	Action        string
 	InternalError string
@@ -60,8 +78,13 @@ func formatError(err error, object string, action string) error {
 		case "22":
 			return &DataViolation{Violation: sqlErr.Detail}
 		case "23":
-			if sqlErr.Code.Name() == "unique_violation" {
+			switch sqlErr.Code.Name() {
+			case "unique_violation":
 				return &UniquenessViolation{Object: object}
+			case "foreign_key_violation":
+				return &ForeignKeyViolation{Object: object}
+			case "check_violation":
+				return &CheckViolation{Object: object, Constraint: sqlErr.Constraint}
 			}
 		}
 	}
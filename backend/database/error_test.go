@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestFormatError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{{
+		name: "nil",
+		err:  nil,
+		want: nil,
+	}, {
+		name: "no rows",
+		err:  sql.ErrNoRows,
+		want: &NotFoundError{Object: "user"},
+	}, {
+		name: "connection failure",
+		err:  &pq.Error{Code: "08006", Message: "connection failure"},
+		want: &ConnectionError{InternalError: "pq: connection failure"},
+	}, {
+		name: "data exception",
+		err:  &pq.Error{Code: "22001", Message: "value too long", Detail: "too long"},
+		want: &DataViolation{Violation: "too long"},
+	}, {
+		name: "unique violation",
+		err:  &pq.Error{Code: "23505", Message: "duplicate key"},
+		want: &UniquenessViolation{Object: "user"},
+	}, {
+		name: "foreign key violation",
+		err:  &pq.Error{Code: "23503", Message: "missing key"},
+		want: &ForeignKeyViolation{Object: "user"},
+	}, {
+		name: "check violation",
+		err:  &pq.Error{Code: "23514", Message: "check failed", Constraint: "Followings_not_self"},
+		want: &CheckViolation{Object: "user", Constraint: "Followings_not_self"},
+	}, {
+		name: "other integrity violation",
+		err:  &pq.Error{Code: "23502", Message: "null value"},
+		want: &UnexpectedError{Action: "creating user", InternalError: "pq: null value"},
+	}, {
+		name: "other postgres error",
+		err:  &pq.Error{Code: "42P01", Message: "undefined table"},
+		want: &UnexpectedError{Action: "creating user", InternalError: "pq: undefined table"},
+	}, {
+		name: "other error",
+		err:  errors.New("closed"),
+		want: &UnexpectedError{Action: "creating user", InternalError: "closed"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := formatError(test.err, "user", "creating user")
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type exportHandler struct {
+	db *sql.DB
+}
+
+// CreateUserExport returns a UniquenessViolation if the user already has an
+// export being built
+func (h *exportHandler) CreateUserExport(e models.UserExport) error {
+	_, err := h.db.Exec(`
+	INSERT INTO UserExports (exportId, id, status, time, expiry)
+	VALUES ($1, $2, $3, $4, $5)`, e.ExportId, e.Id, e.Status, e.Time, e.Expiry)
+	return formatError(err, "export", "creating export")
+}
+
+func (h *exportHandler) FinishUserExport(exportId, status string, data []byte) error {
+	_, err := h.db.Exec(`
+	UPDATE UserExports SET status = $2, data = $3
+	WHERE exportId = $1`, exportId, status, data)
+	return formatError(err, "export", "finishing export")
+}
+
+func (h *exportHandler) GetUserExport(id, exportId string) (models.UserExport, error) {
+	e := models.UserExport{Id: id, ExportId: exportId}
+	err := h.db.QueryRow(`
+	SELECT status, time, expiry, data FROM UserExports
+	WHERE id = $1 AND exportId = $2 AND expiry > $3`, id, exportId, time.Now()).Scan(&e.Status, &e.Time, &e.Expiry, &e.Data)
+	return e, formatError(err, "export", "getting export")
+}
+
+// DeleteExpiredUserExports deletes exports that have expired, and exports that
+// were still pending at pendingBefore, which were abandoned by a restart
+func (h *exportHandler) DeleteExpiredUserExports(pendingBefore time.Time) error {
+	_, err := h.db.Exec(`
+	DELETE FROM UserExports
+	WHERE expiry <= $1 OR (status = $2 AND time < $3)`, time.Now(), models.ExportPending, pendingBefore)
+	return formatError(err, "export", "deleting expired exports")
+}
+
+// GetAllUserPosts returns all of the user's posts, including the ones that
+// have expired or been hidden
+func (h *exportHandler) GetAllUserPosts(id string) ([]models.Post, error) {
+	posts := []models.Post{}
+
+	rows, err := h.db.Query(`
+	SELECT `+postColumns+` FROM Posts
+	INNER JOIN Users ON Users.id = Posts.id
+	WHERE Posts.id = $1
+	ORDER BY Posts.time`, id)
+	if err != nil {
+		return posts, formatError(err, "post", "getting posts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post models.Post
+		if post, err = scanPost(rows); err != nil {
+			break
+		}
+
+		posts = append(posts, post)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return posts, &UnexpectedError{
+			Action:        "parsing posts",
+			InternalError: err.Error(),
+		}
+	}
+
+	return posts, nil
+}
+
+func (h *exportHandler) GetUserComments(id string) ([]models.Comment, error) {
+	comments := []models.Comment{}
+
+	rows, err := h.db.Query(`
+	SELECT postId, commentId, time, content, format FROM Comments
+	WHERE id = $1
+	ORDER BY time`, id)
+	if err != nil {
+		return comments, formatError(err, "comment", "getting comments")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := models.Comment{Id: id}
+		if err = rows.Scan(&c.PostId, &c.CommentId, &c.Time, &c.Content, &c.Format); err != nil {
+			break
+		}
+
+		comments = append(comments, c)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return comments, &UnexpectedError{
+			Action:        "parsing comments",
+			InternalError: err.Error(),
+		}
+	}
+
+	return comments, nil
+}
+
+func (h *exportHandler) GetUserVotes(id string) ([]models.Vote, error) {
+	votes := []models.Vote{}
+
+	rows, err := h.db.Query(`
+	SELECT postId, time, vote FROM Votes
+	WHERE id = $1
+	ORDER BY time`, id)
+	if err != nil {
+		return votes, formatError(err, "vote", "getting votes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := models.Vote{Id: id}
+		if err = rows.Scan(&v.PostId, &v.Time, &v.Vote); err != nil {
+			break
+		}
+
+		votes = append(votes, v)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return votes, &UnexpectedError{
+			Action:        "parsing votes",
+			InternalError: err.Error(),
+		}
+	}
+
+	return votes, nil
+}
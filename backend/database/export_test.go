@@ -0,0 +1,135 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestUserExport(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	now := time.Now()
+	export := func(exportId, id, status string, start time.Time, expiry time.Time) models.UserExport {
+		return models.UserExport{ExportId: exportId, Id: id, Status: status, Time: start, Expiry: expiry}
+	}
+
+	if err := h.CreateUserExport(export("export", "alice-id", models.ExportPending, now, now.Add(time.Hour))); err != nil {
+		t.Fatalf("Could not create export: %v", err)
+	}
+
+	err := h.CreateUserExport(export("other-export", "alice-id", models.ExportPending, now, now.Add(time.Hour)))
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateUserExport(export("export", "bob-id", models.ExportPending, now, now.Add(time.Hour)))
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateUserExport(export("carol-export", "carol-id", models.ExportPending, now, now.Add(time.Hour)))
+	checkError(t, err, &ForeignKeyViolation{})
+
+	e, err := h.GetUserExport("alice-id", "export")
+	if err != nil || e.Status != models.ExportPending || e.Data != nil {
+		t.Errorf("Got export %+v, %v, want pending", e, err)
+	}
+
+	_, err = h.GetUserExport("bob-id", "export")
+	checkError(t, err, &NotFoundError{})
+
+	data := []byte(`{"username":"alice"}`)
+	if err = h.FinishUserExport("export", models.ExportReady, data); err != nil {
+		t.Fatalf("Could not finish export: %v", err)
+	}
+
+	e, err = h.GetUserExport("alice-id", "export")
+	if err != nil || e.Status != models.ExportReady || !bytes.Equal(e.Data, data) {
+		t.Errorf("Got export %+v, %v, want ready", e, err)
+	}
+
+	// Once the first export is ready another can be built
+	exports := []models.UserExport{
+		export("abandoned", "alice-id", models.ExportPending, now.Add(-time.Hour), now.Add(time.Hour)),
+		export("expired", "bob-id", models.ExportReady, now.Add(-time.Hour), now.Add(-time.Minute)),
+	}
+	for _, e := range exports {
+		if err = h.CreateUserExport(e); err != nil {
+			t.Fatalf("Could not create export %v: %v", e.ExportId, err)
+		}
+	}
+
+	_, err = h.GetUserExport("bob-id", "expired")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.DeleteExpiredUserExports(now.Add(-time.Minute)); err != nil {
+		t.Fatalf("Could not delete expired exports: %v", err)
+	}
+
+	_, err = h.GetUserExport("alice-id", "abandoned")
+	checkError(t, err, &NotFoundError{})
+
+	if _, err = h.GetUserExport("alice-id", "export"); err != nil {
+		t.Errorf("Ready export was deleted: %v", err)
+	}
+
+	var count int
+	if err = h.db.QueryRow(`SELECT COUNT(*) FROM UserExports`).Scan(&count); err != nil || count != 1 {
+		t.Errorf("Got %v, %v exports, want 1", count, err)
+	}
+}
+
+func TestUserContent(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	createTestPost(t, h, "alice-id", "alice-post", "go")
+	createTestPost(t, h, "bob-id", "bob-post")
+
+	old := models.Post{Id: "alice-id", PostId: "old-post", Time: time.Now().Add(-2 * models.PostLifetime), Title: "Old", Format: models.PlainFormat}
+	if err := h.CreatePost(old); err != nil {
+		t.Fatalf("Could not create post: %v", err)
+	}
+
+	comment := models.Comment{Id: "alice-id", CommentId: "comment", Time: time.Now(), Content: "Comment", Format: models.PlainFormat}
+	if err := h.CreateComment("bob-post", comment); err != nil {
+		t.Fatalf("Could not create comment: %v", err)
+	}
+	if err := h.CreateVote("alice-id", "bob-post", models.UPVOTE); err != nil {
+		t.Fatalf("Could not create vote: %v", err)
+	}
+
+	posts, err := h.GetAllUserPosts("alice-id")
+	if err != nil || len(posts) != 2 || posts[0].PostId != "old-post" || posts[1].PostId != "alice-post" {
+		t.Errorf("Got posts %+v, %v, want old-post then alice-post", posts, err)
+	}
+
+	comments, err := h.GetUserComments("alice-id")
+	if err != nil || len(comments) != 1 || comments[0].PostId != "bob-post" || comments[0].Content != "Comment" {
+		t.Errorf("Got comments %+v, %v, want alice's comment", comments, err)
+	}
+
+	votes, err := h.GetUserVotes("alice-id")
+	if err != nil || len(votes) != 1 || votes[0].PostId != "bob-post" || votes[0].Vote != models.UPVOTE {
+		t.Errorf("Got votes %+v, %v, want alice's upvote", votes, err)
+	}
+
+	for _, id := range []string{"bob-id", "carol-id"} {
+		comments, err = h.GetUserComments(id)
+		if err != nil || len(comments) != 0 {
+			t.Errorf("Got comments %+v, %v for %v, want none", comments, err, id)
+		}
+
+		votes, err = h.GetUserVotes(id)
+		if err != nil || len(votes) != 0 {
+			t.Errorf("Got votes %+v, %v for %v, want none", votes, err, id)
+		}
+	}
+
+	posts, err = h.GetAllUserPosts("carol-id")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("Got posts %+v, %v for carol, want none", posts, err)
+	}
+}
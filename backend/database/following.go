@@ -2,6 +2,9 @@ package database
 
 import (
 	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/jbrunsting/transient/backend/models"
 )
@@ -10,15 +13,31 @@ type followingHandler struct {
 	db *sql.DB
 }
 
-func (h *followingHandler) CreateFollowing(id, followingId string) error {
-	_, err := h.db.Exec(`
+// CreateFollowing returns false if the user was already following
+func (h *followingHandler) CreateFollowing(id, followingId string) (bool, error) {
+	res, err := h.db.Exec(`
 	INSERT INTO Followings (id, followingId)
-	VALUES ($1, $2)`, id, followingId)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`, id, followingId)
 	if err != nil {
-		return formatError(err, "following", "creating following")
+		return false, formatError(err, "following", "creating following")
 	}
 
-	return nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, formatError(err, "following", "creating following")
+	}
+
+	return n > 0, nil
+}
+
+func (h *followingHandler) IsFollowing(id, followingId string) (bool, error) {
+	var following bool
+	err := h.db.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM Followings WHERE id = $1 AND followingId = $2
+	)`, id, followingId).Scan(&following)
+	return following, formatError(err, "following", "checking following")
 }
 
 func (h *followingHandler) GetFollowings(id string) ([]models.User, error) {
@@ -56,11 +75,358 @@ func (h *followingHandler) GetFollowings(id string) ([]models.User, error) {
 	return followings, nil
 }
 
+func (h *followingHandler) GetFollowerIds(id string) ([]string, error) {
+	ids := []string{}
+
+	// Followers who muted the user don't want to hear about their posts
+	rows, err := h.db.Query(`
+	SELECT id FROM Followings
+	WHERE followingId = $1 AND NOT EXISTS (
+		SELECT 1 FROM Mutes WHERE Mutes.id = Followings.id AND Mutes.mutedId = $1)`, id)
+	if err != nil {
+		return ids, formatError(err, "followers", "getting followers")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var followerId string
+		if err = rows.Scan(&followerId); err != nil {
+			break
+		}
+
+		ids = append(ids, followerId)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return ids, &UnexpectedError{
+			Action:        "parsing followers",
+			InternalError: err.Error(),
+		}
+	}
+
+	return ids, nil
+}
+
+// DeleteFollowing removes the following, as well as any request to follow
 func (h *followingHandler) DeleteFollowing(id, followingId string) error {
-	_, err := h.db.Exec(`DELETE FROM Followings WHERE id = $1 AND followingId = $2`, id, followingId)
+	tx, err := h.db.Begin()
 	if err != nil {
+		return formatError(err, "following", "starting database transaction")
+	}
+
+	_, err = tx.Exec(`DELETE FROM Followings WHERE id = $1 AND followingId = $2`, id, followingId)
+	if err != nil {
+		tx.Rollback()
 		return formatError(err, "following", "deleting following")
 	}
 
+	_, err = tx.Exec(`DELETE FROM FollowRequests WHERE id = $1 AND followingId = $2`, id, followingId)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "follow request", "deleting follow request")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "following", "committing database transaction")
+}
+
+// CreateFollowRequest creates a pending request to follow, re-opening the
+// request if it was previously rejected. It returns false if the request was
+// already pending
+func (h *followingHandler) CreateFollowRequest(id, followingId string) (bool, error) {
+	res, err := h.db.Exec(`
+	INSERT INTO FollowRequests (id, followingId, status, time)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (id, followingId) DO UPDATE SET status = $3, time = $4
+	WHERE FollowRequests.status = $5`,
+		id, followingId, models.FollowPending, time.Now(), models.FollowRejected)
+	if err != nil {
+		return false, formatError(err, "follow request", "creating follow request")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, formatError(err, "follow request", "creating follow request")
+	}
+
+	return n > 0, nil
+}
+
+func (h *followingHandler) GetFollowRequests(followingId string) ([]models.FollowRequest, error) {
+	requests := []models.FollowRequest{}
+
+	rows, err := h.db.Query(`
+	SELECT FollowRequests.id, Users.username, followingId, status, time FROM FollowRequests
+	INNER JOIN Users ON Users.id = FollowRequests.id
+	WHERE followingId = $1 AND status = $2
+	ORDER BY time DESC`, followingId, models.FollowPending)
+	if err != nil {
+		return requests, formatError(err, "follow requests", "getting follow requests")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.FollowRequest
+		if err = rows.Scan(&r.Id, &r.Username, &r.FollowingId, &r.Status, &r.Time); err != nil {
+			break
+		}
+
+		requests = append(requests, r)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return requests, &UnexpectedError{
+			Action:        "parsing follow requests",
+			InternalError: err.Error(),
+		}
+	}
+
+	return requests, nil
+}
+
+// AcceptFollowRequest marks the pending request as accepted and creates the
+// following in the same transaction
+func (h *followingHandler) AcceptFollowRequest(id, followingId string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "follow request", "starting database transaction")
+	}
+
+	res, err := tx.Exec(`
+	UPDATE FollowRequests SET status = $3
+	WHERE id = $1 AND followingId = $2 AND status = $4`,
+		id, followingId, models.FollowAccepted, models.FollowPending)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "follow request", "accepting follow request")
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return formatError(sql.ErrNoRows, "follow request", "accepting follow request")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Followings (id, followingId)
+	VALUES ($1, $2)
+	ON CONFLICT DO NOTHING`, id, followingId)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "following", "creating following")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "follow request", "committing database transaction")
+}
+
+func (h *followingHandler) RejectFollowRequest(id, followingId string) error {
+	res, err := h.db.Exec(`
+	UPDATE FollowRequests SET status = $3
+	WHERE id = $1 AND followingId = $2 AND status = $4`,
+		id, followingId, models.FollowRejected, models.FollowPending)
+	if err != nil {
+		return formatError(err, "follow request", "rejecting follow request")
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return formatError(sql.ErrNoRows, "follow request", "rejecting follow request")
+	}
+
 	return nil
 }
+
+const followEntryColumns = `Users.id, username, displayName, avatarUrl`
+
+func (h *followingHandler) getFollowEntries(query string, args ...interface{}) ([]models.FollowEntry, int, error) {
+	entries := []models.FollowEntry{}
+	total := 0
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return entries, total, formatError(err, "followings", "getting followings")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.FollowEntry
+		var displayName sql.NullString
+		var avatarUrl sql.NullString
+		if err = rows.Scan(&e.Id, &e.Username, &displayName, &avatarUrl, &e.Mutual, &total); err != nil {
+			break
+		}
+		e.DisplayName = displayName.String
+		e.AvatarUrl = avatarUrl.String
+
+		entries = append(entries, e)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return entries, total, &UnexpectedError{
+			Action:        "parsing followings",
+			InternalError: err.Error(),
+		}
+	}
+
+	return entries, total, nil
+}
+
+// GetFollowerList returns up to limit of the user's followers, ordered by
+// username and starting after the given username
+func (h *followingHandler) GetFollowerList(id, after string, limit int) ([]models.FollowEntry, error) {
+	entries, _, err := h.getFollowEntries(`
+	SELECT `+followEntryColumns+`, EXISTS (
+		SELECT 1 FROM Followings Back
+		WHERE Back.id = $1 AND Back.followingId = Users.id), 0
+	FROM Followings
+	INNER JOIN Users ON Users.id = Followings.id
+	WHERE Followings.followingId = $1 AND Users.deletionScheduled IS NULL AND Users.username > $2
+	ORDER BY Users.username
+	LIMIT $3`, id, after, limit)
+	return entries, err
+}
+
+// GetFollowingList returns up to limit of the users the user follows, ordered
+// by username and starting after the given username
+func (h *followingHandler) GetFollowingList(id, after string, limit int) ([]models.FollowEntry, error) {
+	entries, _, err := h.getFollowEntries(`
+	SELECT `+followEntryColumns+`, EXISTS (
+		SELECT 1 FROM Followings Back
+		WHERE Back.id = Users.id AND Back.followingId = $1), 0
+	FROM Followings
+	INNER JOIN Users ON Users.id = Followings.followingId
+	WHERE Followings.id = $1 AND Users.deletionScheduled IS NULL AND Users.username > $2
+	ORDER BY Users.username
+	LIMIT $3`, id, after, limit)
+	return entries, err
+}
+
+// GetKnownFollowers returns up to limit of the user's followers that viewerId
+// follows, along with how many there are in total
+func (h *followingHandler) GetKnownFollowers(id, viewerId string, limit int) (models.KnownFollowers, error) {
+	entries, total, err := h.getFollowEntries(`
+	SELECT `+followEntryColumns+`, EXISTS (
+		SELECT 1 FROM Followings Back
+		WHERE Back.id = $1 AND Back.followingId = Users.id), COUNT(*) OVER ()
+	FROM Followings
+	INNER JOIN Followings Known ON Known.followingId = Followings.id AND Known.id = $2
+	INNER JOIN Users ON Users.id = Followings.id
+	WHERE Followings.followingId = $1 AND Users.deletionScheduled IS NULL
+	ORDER BY Users.username
+	LIMIT $3`, id, viewerId, limit)
+	return models.KnownFollowers{Users: entries, Total: total}, err
+}
+
+// GetImportTargets looks up the users with the given usernames in one query,
+// users being deleted are left out as if they didn't exist
+func (h *followingHandler) GetImportTargets(id string, usernames []string) ([]models.ImportTarget, error) {
+	targets := []models.ImportTarget{}
+
+	rows, err := h.db.Query(`
+	SELECT Users.id, Users.username, Users.private,
+		EXISTS (
+			SELECT 1 FROM Followings
+			WHERE Followings.id = $1 AND Followings.followingId = Users.id
+		),
+		EXISTS (
+			SELECT 1 FROM Blocks
+			WHERE (Blocks.id = $1 AND Blocks.blockedId = Users.id) OR (Blocks.id = Users.id AND Blocks.blockedId = $1)
+		)
+	FROM Users
+	WHERE Users.username = ANY($2) AND Users.deletionScheduled IS NULL`, id, pq.Array(usernames))
+	if err != nil {
+		return targets, formatError(err, "user", "getting import targets")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.ImportTarget
+		if err = rows.Scan(&t.Id, &t.Username, &t.Private, &t.Following, &t.Blocked); err != nil {
+			break
+		}
+
+		targets = append(targets, t)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return targets, &UnexpectedError{
+			Action:        "parsing import targets",
+			InternalError: err.Error(),
+		}
+	}
+
+	return targets, nil
+}
+
+// ImportFollowings follows followingIds and requests to follow requestIds in
+// one transaction, it returns the users that were newly followed and the ones
+// that were newly requested
+func (h *followingHandler) ImportFollowings(id string, followingIds, requestIds []string) ([]string, []string, error) {
+	followed := []string{}
+	requested := []string{}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return followed, requested, formatError(err, "following", "starting database transaction")
+	}
+
+	followed, err = queryIds(tx, `
+	INSERT INTO Followings (id, followingId)
+	SELECT $1, unnest($2::VARCHAR(36)[])
+	ON CONFLICT DO NOTHING
+	RETURNING followingId`, id, pq.Array(followingIds))
+	if err != nil {
+		tx.Rollback()
+		return followed, requested, formatError(err, "following", "creating followings")
+	}
+
+	requested, err = queryIds(tx, `
+	INSERT INTO FollowRequests (id, followingId, status, time)
+	SELECT $1, unnest($2::VARCHAR(36)[]), $3, $4
+	ON CONFLICT (id, followingId) DO UPDATE SET status = $3, time = $4
+	WHERE FollowRequests.status = $5
+	RETURNING followingId`,
+		id, pq.Array(requestIds), models.FollowPending, time.Now(), models.FollowRejected)
+	if err != nil {
+		tx.Rollback()
+		return followed, requested, formatError(err, "follow request", "creating follow requests")
+	}
+
+	err = tx.Commit()
+	return followed, requested, formatError(err, "following", "committing database transaction")
+}
+
+func queryIds(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	ids := []string{}
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,327 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestCreateFollowing(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	tests := []struct {
+		name        string
+		followingId string
+		created     bool
+		want        error
+	}{{
+		name:        "new follow",
+		followingId: "bob-id",
+		created:     true,
+	}, {
+		name:        "already following",
+		followingId: "bob-id",
+	}, {
+		name:        "self",
+		followingId: "alice-id",
+		want:        &CheckViolation{},
+	}, {
+		name:        "unknown user",
+		followingId: "carol-id",
+		want:        &ForeignKeyViolation{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			created, err := h.CreateFollowing("alice-id", test.followingId)
+			checkError(t, err, test.want)
+			if created != test.created {
+				t.Errorf("Got created %v, want %v", created, test.created)
+			}
+
+			following, err := h.IsFollowing("alice-id", test.followingId)
+			if err != nil || following != (test.want == nil) {
+				t.Errorf("Got following %v, %v, want %v", following, err, test.want == nil)
+			}
+		})
+	}
+
+	_, err := h.CreateFollowing("alice-id", "alice-id")
+	if v, ok := err.(*CheckViolation); !ok || v.Constraint != "followings_not_self" {
+		t.Errorf("Got %#v, want the followings_not_self constraint", err)
+	}
+
+	following, err := h.IsFollowing("bob-id", "alice-id")
+	if err != nil || following {
+		t.Errorf("Got following %v, %v in reverse, want false", following, err)
+	}
+}
+
+func TestFollowings(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	for _, f := range [][2]string{{"alice-id", "bob-id"}, {"alice-id", "carol-id"}, {"carol-id", "bob-id"}} {
+		if _, err := h.CreateFollowing(f[0], f[1]); err != nil {
+			t.Fatalf("Could not create following: %v", err)
+		}
+	}
+	if err := h.CreateMute("carol-id", "bob-id"); err != nil {
+		t.Fatalf("Could not create mute: %v", err)
+	}
+
+	followings, err := h.GetFollowings("alice-id")
+	if err != nil {
+		t.Fatalf("Could not get followings: %v", err)
+	}
+	usernames := []string{}
+	for _, u := range followings {
+		usernames = append(usernames, u.Username)
+	}
+	sort.Strings(usernames)
+	if !reflect.DeepEqual(usernames, []string{"bob", "carol"}) {
+		t.Errorf("Got followings %v, want bob and carol", usernames)
+	}
+
+	// carol muted bob, so only alice hears about his posts
+	ids, err := h.GetFollowerIds("bob-id")
+	if err != nil || !reflect.DeepEqual(ids, []string{"alice-id"}) {
+		t.Errorf("Got followers %v, %v, want alice-id", ids, err)
+	}
+
+	if err = h.DeleteFollowing("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not delete following: %v", err)
+	}
+	if err = h.DeleteFollowing("alice-id", "bob-id"); err != nil {
+		t.Errorf("Could not delete following twice: %v", err)
+	}
+
+	following, err := h.IsFollowing("alice-id", "bob-id")
+	if err != nil || following {
+		t.Errorf("Got following %v, %v after deleting, want false", following, err)
+	}
+
+	ids, err = h.GetFollowerIds("dave-id")
+	if err != nil || len(ids) != 0 {
+		t.Errorf("Got followers %v, %v for unknown user, want none", ids, err)
+	}
+}
+
+func TestFollowRequests(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	for _, id := range []string{"alice-id", "carol-id"} {
+		if created, err := h.CreateFollowRequest(id, "bob-id"); err != nil || !created {
+			t.Fatalf("Could not create follow request: %v, %v", created, err)
+		}
+	}
+
+	created, err := h.CreateFollowRequest("alice-id", "bob-id")
+	if err != nil || created {
+		t.Errorf("Got %v, %v requesting again, want the pending request kept", created, err)
+	}
+
+	_, err = h.CreateFollowRequest("bob-id", "bob-id")
+	checkError(t, err, &CheckViolation{})
+
+	_, err = h.CreateFollowRequest("dave-id", "bob-id")
+	checkError(t, err, &ForeignKeyViolation{})
+
+	requests, err := h.GetFollowRequests("bob-id")
+	if err != nil || len(requests) != 2 {
+		t.Fatalf("Got requests %+v, %v, want 2", requests, err)
+	}
+	for _, r := range requests {
+		if r.FollowingId != "bob-id" || r.Status != models.FollowPending || r.Username+"-id" != r.Id {
+			t.Errorf("Got request %+v, want a pending request for bob", r)
+		}
+	}
+
+	if err = h.AcceptFollowRequest("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not accept follow request: %v", err)
+	}
+	following, err := h.IsFollowing("alice-id", "bob-id")
+	if err != nil || !following {
+		t.Errorf("Got following %v, %v after accepting, want true", following, err)
+	}
+
+	err = h.AcceptFollowRequest("alice-id", "bob-id")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.RejectFollowRequest("carol-id", "bob-id"); err != nil {
+		t.Fatalf("Could not reject follow request: %v", err)
+	}
+
+	err = h.RejectFollowRequest("carol-id", "bob-id")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.AcceptFollowRequest("carol-id", "bob-id")
+	checkError(t, err, &NotFoundError{})
+
+	requests, err = h.GetFollowRequests("bob-id")
+	if err != nil || len(requests) != 0 {
+		t.Errorf("Got requests %+v, %v, want none pending", requests, err)
+	}
+
+	// Rejected requests can be made again, and deleting the following
+	// cancels them
+	if created, err = h.CreateFollowRequest("carol-id", "bob-id"); err != nil || !created {
+		t.Fatalf("Could not create follow request: %v, %v", created, err)
+	}
+	requests, err = h.GetFollowRequests("bob-id")
+	if err != nil || len(requests) != 1 || requests[0].Id != "carol-id" {
+		t.Errorf("Got requests %+v, %v, want carol's request re-opened", requests, err)
+	}
+
+	if err = h.DeleteFollowing("carol-id", "bob-id"); err != nil {
+		t.Fatalf("Could not delete following: %v", err)
+	}
+	err = h.RejectFollowRequest("carol-id", "bob-id")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestFollowLists(t *testing.T) {
+	h := newTestHandler(t)
+	for _, username := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		createTestUser(t, h, username)
+	}
+
+	// bob, carol and dave follow alice, alice follows bob back, and erin
+	// follows carol and dave
+	for _, f := range [][2]string{
+		{"bob-id", "alice-id"}, {"carol-id", "alice-id"}, {"dave-id", "alice-id"},
+		{"alice-id", "bob-id"}, {"erin-id", "carol-id"}, {"erin-id", "dave-id"},
+	} {
+		if _, err := h.CreateFollowing(f[0], f[1]); err != nil {
+			t.Fatalf("Could not create following: %v", err)
+		}
+	}
+
+	displayName := "Bob"
+	if err := h.UpdateProfile("bob-id", models.ProfileUpdate{DisplayName: &displayName}); err != nil {
+		t.Fatalf("Could not update profile: %v", err)
+	}
+
+	followers, err := h.GetFollowerList("alice-id", "", 2)
+	if err != nil || len(followers) != 2 {
+		t.Fatalf("Got followers %+v, %v, want 2", followers, err)
+	}
+	if followers[0].Username != "bob" || !followers[0].Mutual || followers[0].DisplayName != "Bob" {
+		t.Errorf("Got follower %+v, want bob as a mutual", followers[0])
+	}
+	if followers[1].Username != "carol" || followers[1].Mutual {
+		t.Errorf("Got follower %+v, want carol", followers[1])
+	}
+
+	followers, err = h.GetFollowerList("alice-id", "carol", 2)
+	if err != nil || len(followers) != 1 || followers[0].Username != "dave" {
+		t.Errorf("Got followers %+v, %v after carol, want dave", followers, err)
+	}
+
+	followings, err := h.GetFollowingList("bob-id", "", 10)
+	if err != nil || len(followings) != 1 || followings[0].Username != "alice" || !followings[0].Mutual {
+		t.Errorf("Got followings %+v, %v, want alice as a mutual", followings, err)
+	}
+
+	// dave is being deleted, so he's left out of lists
+	if err = h.ScheduleUserDeletion("dave-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+
+	followers, err = h.GetFollowerList("alice-id", "carol", 2)
+	if err != nil || len(followers) != 0 {
+		t.Errorf("Got followers %+v, %v, want none after carol", followers, err)
+	}
+
+	known, err := h.GetKnownFollowers("alice-id", "erin-id", 10)
+	if err != nil || known.Total != 1 || len(known.Users) != 1 || known.Users[0].Username != "carol" {
+		t.Errorf("Got known followers %+v, %v, want carol", known, err)
+	}
+
+	known, err = h.GetKnownFollowers("alice-id", "bob-id", 10)
+	if err != nil || known.Total != 0 || len(known.Users) != 0 {
+		t.Errorf("Got known followers %+v, %v, want none", known, err)
+	}
+
+	_, err = h.GetFollowerList("alice-id", "", -1)
+	checkError(t, err, &DataViolation{})
+}
+
+func TestImportFollowings(t *testing.T) {
+	h := newTestHandler(t)
+	for _, username := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		createTestUser(t, h, username)
+	}
+
+	if err := h.SetPrivate("carol-id", true); err != nil {
+		t.Fatalf("Could not set private: %v", err)
+	}
+	if _, err := h.CreateFollowing("alice-id", "dave-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+	if err := h.CreateBlock("erin-id", "alice-id"); err != nil {
+		t.Fatalf("Could not create block: %v", err)
+	}
+	if err := h.ScheduleUserDeletion("frank-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+
+	targets, err := h.GetImportTargets("alice-id", []string{"bob", "carol", "dave", "erin", "frank", "unknown"})
+	if err != nil {
+		t.Fatalf("Could not get import targets: %v", err)
+	}
+
+	got := map[string]models.ImportTarget{}
+	for _, target := range targets {
+		got[target.Username] = target
+	}
+	want := map[string]models.ImportTarget{
+		"bob":   {Id: "bob-id", Username: "bob"},
+		"carol": {Id: "carol-id", Username: "carol", Private: true},
+		"dave":  {Id: "dave-id", Username: "dave", Following: true},
+		"erin":  {Id: "erin-id", Username: "erin", Blocked: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got targets %+v, want %+v", got, want)
+	}
+
+	followed, requested, err := h.ImportFollowings("alice-id", []string{"bob-id", "dave-id"}, []string{"carol-id"})
+	if err != nil {
+		t.Fatalf("Could not import followings: %v", err)
+	}
+	if !reflect.DeepEqual(followed, []string{"bob-id"}) || !reflect.DeepEqual(requested, []string{"carol-id"}) {
+		t.Errorf("Got followed %v and requested %v, want bob-id and carol-id", followed, requested)
+	}
+
+	followed, requested, err = h.ImportFollowings("alice-id", []string{"bob-id"}, []string{"carol-id"})
+	if err != nil || len(followed) != 0 || len(requested) != 0 {
+		t.Errorf("Got %v, %v, %v importing again, want nothing new", followed, requested, err)
+	}
+
+	// A bad ID fails the whole import
+	_, _, err = h.ImportFollowings("alice-id", []string{"erin-id", "unknown-id"}, nil)
+	checkError(t, err, &ForeignKeyViolation{})
+
+	_, _, err = h.ImportFollowings("alice-id", []string{"alice-id"}, nil)
+	checkError(t, err, &CheckViolation{})
+
+	_, _, err = h.ImportFollowings("alice-id", nil, []string{"frank-id", "alice-id"})
+	checkError(t, err, &CheckViolation{})
+
+	for _, id := range []string{"erin-id", "frank-id"} {
+		following, err := h.IsFollowing("alice-id", id)
+		if err != nil || following {
+			t.Errorf("Got following %v, %v for %v after failed import, want false", following, err, id)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type identityHandler struct {
+	db *sql.DB
+}
+
+func (h *identityHandler) CreateOidcLogin(l models.OidcLogin) error {
+	var linkId sql.NullString
+	if l.LinkId != "" {
+		linkId = sql.NullString{String: l.LinkId, Valid: true}
+	}
+
+	_, err := h.db.Exec(`
+	INSERT INTO OidcLogins (stateHash, verifier, nonce, linkId, reauth, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6)`, l.StateHash, l.Verifier, l.Nonce, linkId, l.Reauth, l.Expiry)
+	return formatError(err, "login", "creating oidc login")
+}
+
+// UseOidcLogin removes and returns the login with the given state, so that
+// each state can only be used once
+func (h *identityHandler) UseOidcLogin(stateHash string) (models.OidcLogin, error) {
+	l := models.OidcLogin{StateHash: stateHash}
+
+	var linkId sql.NullString
+	err := h.db.QueryRow(`
+	DELETE FROM OidcLogins
+	WHERE stateHash = $1 AND expiry > $2
+	RETURNING verifier, nonce, linkId, reauth, expiry`, stateHash, time.Now()).Scan(&l.Verifier, &l.Nonce, &linkId, &l.Reauth, &l.Expiry)
+	l.LinkId = linkId.String
+	return l, formatError(err, "login", "using oidc login")
+}
+
+// ReauthenticateSession records that the user of the session just signed in
+// with their identity provider again
+func (h *identityHandler) ReauthenticateSession(sessionId string) error {
+	res, err := h.db.Exec(`
+	UPDATE Sessions SET reauthenticated = $2
+	WHERE sessionId = $1`, sessionId, time.Now())
+	if err != nil {
+		return formatError(err, "session", "reauthenticating session")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "session", "reauthenticating session")
+	} else if n == 0 {
+		return &NotFoundError{"session"}
+	}
+
+	return nil
+}
+
+// SessionReauthenticated returns whether the user of the session signed in
+// with their identity provider again after the given time
+func (h *identityHandler) SessionReauthenticated(sessionId string, since time.Time) (bool, error) {
+	var reauthenticated bool
+	err := h.db.QueryRow(`
+	SELECT COALESCE(reauthenticated > $2, FALSE) FROM Sessions
+	WHERE sessionId = $1`, sessionId, since).Scan(&reauthenticated)
+	return reauthenticated, formatError(err, "session", "checking session reauthentication")
+}
+
+func (h *identityHandler) CreateIdentity(i models.Identity) error {
+	_, err := h.db.Exec(`
+	INSERT INTO Identities (provider, subject, id, email, time)
+	VALUES ($1, $2, $3, $4, $5)`, i.Provider, i.Subject, i.Id, i.Email, i.Time)
+	return formatError(err, "identity", "creating identity")
+}
+
+// CreateIdentityUser creates a user who signed up through an identity
+// provider, along with their identity and first session
+func (h *identityHandler) CreateIdentityUser(u models.User, i models.Identity, s models.Session) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "user", "starting database transaction")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Users (id, username, password, email, verified)
+	VALUES ($1, $2, $3, $4, $5)`, u.Id, u.Username, u.Password, u.Email, u.Verified)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "user", "creating user")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Identities (provider, subject, id, email, time)
+	VALUES ($1, $2, $3, $4, $5)`, i.Provider, i.Subject, u.Id, i.Email, i.Time)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "identity", "creating identity")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Sessions (id, sessionId, expiry)
+	VALUES ($1, $2, $3)`, u.Id, s.SessionId, s.Expiry)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "session", "creating session")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "user", "committing database transaction")
+}
+
+func (h *identityHandler) GetIdentities(id string) ([]models.Identity, error) {
+	identities := []models.Identity{}
+
+	rows, err := h.db.Query(`
+	SELECT provider, subject, email, time FROM Identities
+	WHERE id = $1
+	ORDER BY time`, id)
+	if err != nil {
+		return identities, formatError(err, "identity", "getting identities")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i := models.Identity{Id: id}
+		if err = rows.Scan(&i.Provider, &i.Subject, &i.Email, &i.Time); err != nil {
+			break
+		}
+
+		identities = append(identities, i)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return identities, &UnexpectedError{
+			Action:        "parsing identities",
+			InternalError: err.Error(),
+		}
+	}
+
+	return identities, nil
+}
+
+func (h *identityHandler) DeleteIdentity(id, provider, subject string) error {
+	res, err := h.db.Exec(`
+	DELETE FROM Identities
+	WHERE id = $1 AND provider = $2 AND subject = $3`, id, provider, subject)
+	if err != nil {
+		return formatError(err, "identity", "deleting identity")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "identity", "deleting identity")
+	} else if n == 0 {
+		return &NotFoundError{"identity"}
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestOidcLogin(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	expiry := time.Now().Add(time.Minute)
+	logins := []models.OidcLogin{
+		{StateHash: "login", Verifier: "verifier", Nonce: "nonce", Expiry: expiry},
+		{StateHash: "link", Verifier: "verifier", Nonce: "nonce", LinkId: "alice-id", Expiry: expiry},
+		{StateHash: "reauth", Verifier: "verifier", Nonce: "nonce", LinkId: "alice-id", Reauth: true, Expiry: expiry},
+		{StateHash: "expired", Verifier: "verifier", Nonce: "nonce", Expiry: time.Now().Add(-time.Minute)},
+	}
+	for _, l := range logins {
+		if err := h.CreateOidcLogin(l); err != nil {
+			t.Fatalf("Could not create login %v: %v", l.StateHash, err)
+		}
+	}
+
+	err := h.CreateOidcLogin(logins[0])
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateOidcLogin(models.OidcLogin{StateHash: "unknown", LinkId: "carol-id", Expiry: expiry})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	for _, want := range logins[:3] {
+		l, err := h.UseOidcLogin(want.StateHash)
+		if err != nil {
+			t.Fatalf("Could not use login %v: %v", want.StateHash, err)
+		}
+		if l.Verifier != want.Verifier || l.Nonce != want.Nonce || l.LinkId != want.LinkId || l.Reauth != want.Reauth {
+			t.Errorf("Got login %+v, want %+v", l, want)
+		}
+
+		_, err = h.UseOidcLogin(want.StateHash)
+		checkError(t, err, &NotFoundError{})
+	}
+
+	_, err = h.UseOidcLogin("expired")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestReauthenticateSession(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	since := time.Now().Add(-time.Minute)
+	reauthenticated, err := h.SessionReauthenticated("alice-session", since)
+	if err != nil || reauthenticated {
+		t.Errorf("Got %v, %v before reauthenticating, want false", reauthenticated, err)
+	}
+
+	if err = h.ReauthenticateSession("alice-session"); err != nil {
+		t.Fatalf("Could not reauthenticate session: %v", err)
+	}
+
+	reauthenticated, err = h.SessionReauthenticated("alice-session", since)
+	if err != nil || !reauthenticated {
+		t.Errorf("Got %v, %v after reauthenticating, want true", reauthenticated, err)
+	}
+
+	reauthenticated, err = h.SessionReauthenticated("alice-session", time.Now().Add(time.Minute))
+	if err != nil || reauthenticated {
+		t.Errorf("Got %v, %v for a later time, want false", reauthenticated, err)
+	}
+
+	err = h.ReauthenticateSession("unknown-session")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.SessionReauthenticated("unknown-session", since)
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestIdentities(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	now := time.Now()
+	google := models.Identity{Provider: "google", Subject: "alice-sub", Id: "alice-id", Email: "alice@example.com", Time: now}
+	github := models.Identity{Provider: "github", Subject: "alice-sub", Id: "alice-id", Time: now.Add(time.Second)}
+	for _, i := range []models.Identity{google, github} {
+		if err := h.CreateIdentity(i); err != nil {
+			t.Fatalf("Could not create identity: %v", err)
+		}
+	}
+
+	err := h.CreateIdentity(models.Identity{Provider: "google", Subject: "alice-sub", Id: "bob-id", Time: now})
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateIdentity(models.Identity{Provider: "google", Subject: "carol-sub", Id: "carol-id", Time: now})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	identities, err := h.GetIdentities("alice-id")
+	if err != nil {
+		t.Fatalf("Could not get identities: %v", err)
+	}
+	if len(identities) != 2 || identities[0].Provider != "google" || identities[1].Provider != "github" {
+		t.Errorf("Got identities %+v, want google then github", identities)
+	}
+	if identities[0].Email != "alice@example.com" || identities[0].Id != "alice-id" {
+		t.Errorf("Got identity %+v, want alice's google identity", identities[0])
+	}
+
+	identities, err = h.GetIdentities("bob-id")
+	if err != nil || len(identities) != 0 {
+		t.Errorf("Got %+v, %v for bob, want no identities", identities, err)
+	}
+
+	err = h.DeleteIdentity("bob-id", "google", "alice-sub")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.DeleteIdentity("alice-id", "google", "alice-sub"); err != nil {
+		t.Fatalf("Could not delete identity: %v", err)
+	}
+
+	err = h.DeleteIdentity("alice-id", "google", "alice-sub")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.GetUserFromIdentity("google", "alice-sub")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestCreateIdentityUser(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	if err := h.CreateIdentity(models.Identity{Provider: "google", Subject: "alice-sub", Id: "alice-id", Time: time.Now()}); err != nil {
+		t.Fatalf("Could not create identity: %v", err)
+	}
+
+	user := func(username string) models.User {
+		return models.User{
+			Id:             "bob-id",
+			Identification: models.Identification{Username: username},
+			Email:          username + "@example.com",
+			Verified:       true,
+		}
+	}
+	identity := func(subject string) models.Identity {
+		return models.Identity{Provider: "google", Subject: subject, Time: time.Now()}
+	}
+	session := func(sessionId string) models.Session {
+		return models.Session{SessionId: sessionId, Expiry: time.Now().Add(time.Hour)}
+	}
+
+	tests := []struct {
+		name     string
+		user     models.User
+		identity models.Identity
+		session  models.Session
+		want     error
+	}{{
+		name:     "duplicate username",
+		user:     user("alice"),
+		identity: identity("bob-sub"),
+		session:  session("bob-session"),
+		want:     &UniquenessViolation{},
+	}, {
+		name:     "duplicate identity",
+		user:     user("bob"),
+		identity: identity("alice-sub"),
+		session:  session("bob-session"),
+		want:     &UniquenessViolation{},
+	}, {
+		name:     "duplicate session",
+		user:     user("bob"),
+		identity: identity("bob-sub"),
+		session:  session("alice-session"),
+		want:     &UniquenessViolation{},
+	}, {
+		name:     "new user",
+		user:     user("bob"),
+		identity: identity("bob-sub"),
+		session:  session("bob-session"),
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := h.CreateIdentityUser(test.user, test.identity, test.session)
+			checkError(t, err, test.want)
+
+			u, err := h.GetUserFromIdentity("google", "bob-sub")
+			if test.want != nil {
+				checkError(t, err, &NotFoundError{})
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Could not get user: %v", err)
+			}
+			if u.Id != "bob-id" || !u.Verified || len(u.Sessions) != 1 {
+				t.Errorf("Got user %+v, want verified bob with a session", u)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+// reportTargets maps each report target type to the query that checks the
+// target exists
+var reportTargets = map[string]string{
+	models.PostTarget:    `SELECT 1 FROM Posts WHERE postId = $1 AND NOT hidden`,
+	models.CommentTarget: `SELECT 1 FROM Comments WHERE commentId = $1 AND NOT hidden`,
+	models.UserTarget:    `SELECT 1 FROM Users WHERE id = $1`,
+}
+
+type moderationHandler struct {
+	db *sql.DB
+}
+
+func (h *moderationHandler) CreateReport(r models.Report) error {
+	query, ok := reportTargets[r.TargetType]
+	if !ok {
+		return &NotFoundError{Object: r.TargetType}
+	}
+
+	var exists int
+	err := h.db.QueryRow(query, r.TargetId).Scan(&exists)
+	if err != nil {
+		return formatError(err, r.TargetType, "checking report target")
+	}
+
+	var details sql.NullString
+	if r.Details != "" {
+		details = sql.NullString{String: r.Details, Valid: true}
+	}
+
+	_, err = h.db.Exec(`
+	INSERT INTO Reports (reportId, id, targetType, targetId, reason, details, status, time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		r.ReportId, r.Id, r.TargetType, r.TargetId, r.Reason, details, models.ReportOpen, r.Time)
+	return formatError(err, "report", "creating report")
+}
+
+func (h *moderationHandler) GetReports(status string, limit int) ([]models.Report, error) {
+	reports := []models.Report{}
+
+	rows, err := h.db.Query(`
+	SELECT reportId, Reports.id, Users.username, targetType, targetId, reason, details, status, time
+	FROM Reports
+	INNER JOIN Users ON Users.id = Reports.id
+	WHERE status = $1
+	ORDER BY time
+	LIMIT $2`, status, limit)
+	if err != nil {
+		return reports, formatError(err, "reports", "getting reports")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Report
+		var details sql.NullString
+		if err = rows.Scan(&r.ReportId, &r.Id, &r.Username, &r.TargetType, &r.TargetId,
+			&r.Reason, &details, &r.Status, &r.Time); err != nil {
+			break
+		}
+		r.Details = details.String
+
+		reports = append(reports, r)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return reports, &UnexpectedError{
+			Action:        "parsing reports",
+			InternalError: err.Error(),
+		}
+	}
+
+	return reports, nil
+}
+
+// HideContent hides the post or comment the action targets, resolving any
+// open reports against it
+func (h *moderationHandler) HideContent(a models.ModerationAction) error {
+	var update string
+	switch a.TargetType {
+	case models.PostTarget:
+		update = `UPDATE Posts SET hidden = TRUE WHERE postId = $1`
+	case models.CommentTarget:
+		update = `UPDATE Comments SET hidden = TRUE WHERE commentId = $1`
+	default:
+		return &NotFoundError{Object: a.TargetType}
+	}
+
+	return moderate(h.db, &a, models.ReportResolved, func(tx *sql.Tx) error {
+		return execOne(tx, update, a.TargetId)
+	})
+}
+
+// SuspendUser suspends the user the action targets and revokes all of their
+// sessions, resolving any open reports against them
+func (h *moderationHandler) SuspendUser(a models.ModerationAction) error {
+	return moderate(h.db, &a, models.ReportResolved, func(tx *sql.Tx) error {
+		err := execOne(tx, `UPDATE Users SET suspended = TRUE WHERE id = $1`, a.TargetId)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM Sessions WHERE id = $1`, a.TargetId)
+		return err
+	})
+}
+
+func (h *moderationHandler) UnsuspendUser(a models.ModerationAction) error {
+	return moderate(h.db, &a, "", func(tx *sql.Tx) error {
+		return execOne(tx, `UPDATE Users SET suspended = FALSE WHERE id = $1`, a.TargetId)
+	})
+}
+
+// DismissReport closes the report without taking action on its target, the
+// action's target is filled in from the report
+func (h *moderationHandler) DismissReport(a models.ModerationAction) error {
+	return moderate(h.db, &a, "", func(tx *sql.Tx) error {
+		return tx.QueryRow(`
+		UPDATE Reports SET status = $2 WHERE reportId = $1 AND status = $3
+		RETURNING targetType, targetId`,
+			a.ReportId, models.ReportDismissed, models.ReportOpen).Scan(&a.TargetType, &a.TargetId)
+	})
+}
+
+// execOne executes the query, returning sql.ErrNoRows if it didn't affect
+// any rows
+func execOne(tx *sql.Tx, query string, args ...interface{}) error {
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// moderate applies the action, closes the open reports against the action's
+// target with reportStatus unless it is empty, and records the action in the
+// audit trail, all in a single transaction
+func moderate(db *sql.DB, a *models.ModerationAction, reportStatus string, apply func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return formatError(err, "moderation action", "starting database transaction")
+	}
+
+	if err = apply(tx); err != nil {
+		tx.Rollback()
+		return formatError(err, a.TargetType, "applying moderation action")
+	}
+
+	if reportStatus != "" {
+		_, err = tx.Exec(`
+		UPDATE Reports SET status = $3
+		WHERE targetType = $1 AND targetId = $2 AND status = $4`,
+			a.TargetType, a.TargetId, reportStatus, models.ReportOpen)
+		if err != nil {
+			tx.Rollback()
+			return formatError(err, "report", "resolving reports")
+		}
+	}
+
+	var reportId sql.NullString
+	if a.ReportId != "" {
+		reportId = sql.NullString{String: a.ReportId, Valid: true}
+	}
+
+	var reason sql.NullString
+	if a.Reason != "" {
+		reason = sql.NullString{String: a.Reason, Valid: true}
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO ModerationActions (actionId, moderatorId, action, targetType, targetId, reportId, reason, time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		a.ActionId, a.ModeratorId, a.Action, a.TargetType, a.TargetId, reportId, reason, a.Time)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "moderation action", "recording moderation action")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "moderation action", "committing database transaction")
+}
+
+func (h *moderationHandler) GetModerationActions(limit int) ([]models.ModerationAction, error) {
+	actions := []models.ModerationAction{}
+
+	rows, err := h.db.Query(`
+	SELECT actionId, moderatorId, action, targetType, targetId, reportId, reason, time
+	FROM ModerationActions
+	ORDER BY time DESC
+	LIMIT $1`, limit)
+	if err != nil {
+		return actions, formatError(err, "moderation actions", "getting moderation actions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.ModerationAction
+		var moderatorId, reportId, reason sql.NullString
+		if err = rows.Scan(&a.ActionId, &moderatorId, &a.Action, &a.TargetType, &a.TargetId,
+			&reportId, &reason, &a.Time); err != nil {
+			break
+		}
+		a.ModeratorId = moderatorId.String
+		a.ReportId = reportId.String
+		a.Reason = reason.String
+
+		actions = append(actions, a)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return actions, &UnexpectedError{
+			Action:        "parsing moderation actions",
+			InternalError: err.Error(),
+		}
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func report(reportId, id, targetType, targetId string) models.Report {
+	return models.Report{
+		ReportId:   reportId,
+		Id:         id,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Reason:     "spam",
+		Time:       time.Now(),
+	}
+}
+
+func action(actionId, action, targetType, targetId string) models.ModerationAction {
+	return models.ModerationAction{
+		ActionId:    actionId,
+		ModeratorId: "mod-id",
+		Action:      action,
+		TargetType:  targetType,
+		TargetId:    targetId,
+		Time:        time.Now(),
+	}
+}
+
+func TestCreateReport(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestPost(t, h, "bob-id", "post")
+	comment := models.Comment{Id: "bob-id", CommentId: "comment", Time: time.Now(), Content: "Comment", Format: models.PlainFormat}
+	if err := h.CreateComment("post", comment); err != nil {
+		t.Fatalf("Could not create comment: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		report models.Report
+		want   error
+	}{{
+		name:   "post",
+		report: report("post-report", "alice-id", models.PostTarget, "post"),
+	}, {
+		name:   "comment",
+		report: report("comment-report", "alice-id", models.CommentTarget, "comment"),
+	}, {
+		name:   "user",
+		report: report("user-report", "alice-id", models.UserTarget, "bob-id"),
+	}, {
+		name:   "already reported",
+		report: report("other-report", "alice-id", models.PostTarget, "post"),
+		want:   &UniquenessViolation{},
+	}, {
+		name:   "unknown post",
+		report: report("other-report", "alice-id", models.PostTarget, "unknown"),
+		want:   &NotFoundError{},
+	}, {
+		name:   "unknown comment",
+		report: report("other-report", "alice-id", models.CommentTarget, "unknown"),
+		want:   &NotFoundError{},
+	}, {
+		name:   "unknown user",
+		report: report("other-report", "alice-id", models.UserTarget, "unknown"),
+		want:   &NotFoundError{},
+	}, {
+		name:   "unknown target type",
+		report: report("other-report", "alice-id", "tag", "go"),
+		want:   &NotFoundError{},
+	}, {
+		name:   "unknown reporter",
+		report: report("other-report", "carol-id", models.PostTarget, "post"),
+		want:   &ForeignKeyViolation{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := h.CreateReport(test.report)
+			checkError(t, err, test.want)
+		})
+	}
+
+	withDetails := report("details-report", "bob-id", models.UserTarget, "alice-id")
+	withDetails.Details = "Details"
+	if err := h.CreateReport(withDetails); err != nil {
+		t.Fatalf("Could not create report: %v", err)
+	}
+
+	reports, err := h.GetReports(models.ReportOpen, 10)
+	if err != nil || len(reports) != 4 {
+		t.Fatalf("Got reports %+v, %v, want 4", reports, err)
+	}
+	for _, r := range reports {
+		if r.Status != models.ReportOpen || r.Username+"-id" != r.Id {
+			t.Errorf("Got report %+v, want an open report with its reporter's username", r)
+		}
+		if (r.Details != "") != (r.ReportId == "details-report") {
+			t.Errorf("Got details %q for %v", r.Details, r.ReportId)
+		}
+	}
+
+	reports, err = h.GetReports(models.ReportOpen, 1)
+	if err != nil || len(reports) != 1 || reports[0].ReportId != "post-report" {
+		t.Errorf("Got reports %+v, %v, want the oldest report", reports, err)
+	}
+
+	reports, err = h.GetReports(models.ReportResolved, 10)
+	if err != nil || len(reports) != 0 {
+		t.Errorf("Got reports %+v, %v, want none resolved", reports, err)
+	}
+}
+
+func TestHideContent(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+	createTestPost(t, h, "alice-id", "post")
+	comment := models.Comment{Id: "alice-id", CommentId: "comment", Time: time.Now(), Content: "Comment", Format: models.PlainFormat}
+	if err := h.CreateComment("post", comment); err != nil {
+		t.Fatalf("Could not create comment: %v", err)
+	}
+	for _, r := range []models.Report{
+		report("post-report", "mod-id", models.PostTarget, "post"),
+		report("comment-report", "mod-id", models.CommentTarget, "comment"),
+	} {
+		if err := h.CreateReport(r); err != nil {
+			t.Fatalf("Could not create report: %v", err)
+		}
+	}
+
+	err := h.HideContent(action("unknown", models.HideAction, models.PostTarget, "unknown"))
+	checkError(t, err, &NotFoundError{})
+
+	err = h.HideContent(action("user", models.HideAction, models.UserTarget, "alice-id"))
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.HideContent(action("hide-comment", models.HideAction, models.CommentTarget, "comment")); err != nil {
+		t.Fatalf("Could not hide comment: %v", err)
+	}
+
+	comments, err := h.GetComments("post", "mod-id")
+	if err != nil || len(comments) != 0 {
+		t.Errorf("Got comments %+v, %v, want the comment hidden", comments, err)
+	}
+
+	reports, err := h.GetReports(models.ReportResolved, 10)
+	if err != nil || len(reports) != 1 || reports[0].ReportId != "comment-report" {
+		t.Errorf("Got resolved reports %+v, %v, want the comment report", reports, err)
+	}
+
+	a := action("hide-post", models.HideAction, models.PostTarget, "post")
+	a.ReportId = "post-report"
+	a.Reason = "Spam"
+	if err = h.HideContent(a); err != nil {
+		t.Fatalf("Could not hide post: %v", err)
+	}
+
+	err = h.CanViewPost("post", "alice-id")
+	checkError(t, err, &NotFoundError{})
+
+	// Action IDs can't be reused
+	err = h.HideContent(action("hide-post", models.HideAction, models.CommentTarget, "comment"))
+	checkError(t, err, &UniquenessViolation{})
+
+	actions, err := h.GetModerationActions(10)
+	if err != nil || len(actions) != 2 {
+		t.Fatalf("Got actions %+v, %v, want 2", actions, err)
+	}
+	if actions[0].ActionId != "hide-post" || actions[0].ReportId != "post-report" || actions[0].Reason != "Spam" || actions[0].ModeratorId != "mod-id" {
+		t.Errorf("Got action %+v, want the post being hidden", actions[0])
+	}
+	if actions[1].ActionId != "hide-comment" || actions[1].ReportId != "" || actions[1].Reason != "" {
+		t.Errorf("Got action %+v, want the comment being hidden", actions[1])
+	}
+
+	err = h.HideContent(action("other", models.HideAction, models.PostTarget, "post"))
+	if err != nil {
+		t.Errorf("Could not hide a hidden post: %v", err)
+	}
+
+	// Actions stay in the audit trail after their moderator is deleted
+	if _, err = h.db.Exec(`DELETE FROM Users WHERE id = 'mod-id'`); err != nil {
+		t.Fatalf("Could not delete moderator: %v", err)
+	}
+	actions, err = h.GetModerationActions(1)
+	if err != nil || len(actions) != 1 || actions[0].ModeratorId != "" {
+		t.Errorf("Got actions %+v, %v, want no moderator", actions, err)
+	}
+}
+
+func TestSuspendUser(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+	if err := h.CreateReport(report("report", "mod-id", models.UserTarget, "alice-id")); err != nil {
+		t.Fatalf("Could not create report: %v", err)
+	}
+
+	err := h.SuspendUser(action("unknown", models.SuspendAction, models.UserTarget, "carol-id"))
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.SuspendUser(action("suspend", models.SuspendAction, models.UserTarget, "alice-id")); err != nil {
+		t.Fatalf("Could not suspend user: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || !u.Suspended || len(u.Sessions) != 0 {
+		t.Errorf("Got user %+v, %v, want suspended with no sessions", u, err)
+	}
+
+	reports, err := h.GetReports(models.ReportResolved, 10)
+	if err != nil || len(reports) != 1 {
+		t.Errorf("Got resolved reports %+v, %v, want 1", reports, err)
+	}
+
+	err = h.UnsuspendUser(action("unknown-unsuspend", models.UnsuspendAction, models.UserTarget, "carol-id"))
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.UnsuspendUser(action("unsuspend", models.UnsuspendAction, models.UserTarget, "alice-id")); err != nil {
+		t.Fatalf("Could not unsuspend user: %v", err)
+	}
+
+	u, err = h.GetUserFromId("alice-id")
+	if err != nil || u.Suspended {
+		t.Errorf("Got user %+v, %v, want not suspended", u, err)
+	}
+
+	actions, err := h.GetModerationActions(10)
+	if err != nil || len(actions) != 2 {
+		t.Errorf("Got actions %+v, %v, want suspend and unsuspend", actions, err)
+	}
+}
+
+func TestDismissReport(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "mod")
+	createTestPost(t, h, "alice-id", "post")
+	if err := h.CreateReport(report("report", "mod-id", models.PostTarget, "post")); err != nil {
+		t.Fatalf("Could not create report: %v", err)
+	}
+
+	a := models.ModerationAction{ActionId: "dismiss", ModeratorId: "mod-id", Action: models.DismissAction, ReportId: "report", Time: time.Now()}
+	if err := h.DismissReport(a); err != nil {
+		t.Fatalf("Could not dismiss report: %v", err)
+	}
+
+	reports, err := h.GetReports(models.ReportDismissed, 10)
+	if err != nil || len(reports) != 1 {
+		t.Errorf("Got dismissed reports %+v, %v, want 1", reports, err)
+	}
+
+	if err = h.CanViewPost("post", "mod-id"); err != nil {
+		t.Errorf("Post was hidden by dismissing its report: %v", err)
+	}
+
+	actions, err := h.GetModerationActions(10)
+	if err != nil || len(actions) != 1 || actions[0].TargetType != models.PostTarget || actions[0].TargetId != "post" {
+		t.Errorf("Got actions %+v, %v, want the action to target the post", actions, err)
+	}
+
+	a.ActionId = "dismiss-again"
+	err = h.DismissReport(a)
+	checkError(t, err, &NotFoundError{})
+
+	a.ActionId = "dismiss-unknown"
+	a.ReportId = "unknown"
+	err = h.DismissReport(a)
+	checkError(t, err, &NotFoundError{})
+}
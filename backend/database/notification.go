@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type notificationHandler struct {
+	db *sql.DB
+}
+
+// CreateNotification returns false if the notification was a repeat and
+// wasn't stored
+func (h *notificationHandler) CreateNotification(n models.Notification) (bool, error) {
+	var postId sql.NullString
+	var commentId sql.NullString
+	if n.PostId != "" {
+		postId = sql.NullString{String: n.PostId, Valid: true}
+	}
+	if n.CommentId != "" {
+		commentId = sql.NullString{String: n.CommentId, Valid: true}
+	}
+
+	// Conflicts only happen for repeated upvotes, which shouldn't notify the
+	// post owner again
+	res, err := h.db.Exec(`
+	INSERT INTO Notifications (notificationId, id, actorId, kind, postId, commentId, time)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT DO NOTHING`, n.NotificationId, n.Id, n.ActorId, n.Kind, postId, commentId, n.Time)
+	if err != nil {
+		return false, formatError(err, "notification", "creating notification")
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, formatError(err, "notification", "creating notification")
+	}
+
+	return rows > 0, nil
+}
+
+func (h *notificationHandler) GetNotifications(id string, unreadOnly bool, limit int) ([]models.Notification, error) {
+	notifications := []models.Notification{}
+
+	rows, err := h.db.Query(`
+	SELECT notificationId, Notifications.id, actorId, Users.username, kind, postId, commentId, time, read
+	FROM Notifications
+	INNER JOIN Users ON Users.id = Notifications.actorId
+	WHERE Notifications.id = $1 AND (NOT $2 OR NOT read)
+	ORDER BY time DESC
+	LIMIT $3`, id, unreadOnly, limit)
+	if err != nil {
+		return notifications, formatError(err, "notification", "getting notifications")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.Notification
+		var postId sql.NullString
+		var commentId sql.NullString
+		err = rows.Scan(&n.NotificationId, &n.Id, &n.ActorId, &n.ActorUsername, &n.Kind, &postId, &commentId, &n.Time, &n.Read)
+		if err != nil {
+			break
+		}
+
+		n.PostId = postId.String
+		n.CommentId = commentId.String
+		notifications = append(notifications, n)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return notifications, &UnexpectedError{
+			Action:        "parsing notifications",
+			InternalError: err.Error(),
+		}
+	}
+
+	return notifications, nil
+}
+
+func (h *notificationHandler) GetUnreadNotificationCount(id string) (int, error) {
+	var count int
+	err := h.db.QueryRow(`
+	SELECT COUNT(*) FROM Notifications WHERE id = $1 AND NOT read`, id).Scan(&count)
+	return count, formatError(err, "notification", "counting unread notifications")
+}
+
+// MarkNotificationsRead marks the given notifications as read, or all of the
+// users notifications if notificationIds is empty
+func (h *notificationHandler) MarkNotificationsRead(id string, notificationIds []string) error {
+	var err error
+	if len(notificationIds) == 0 {
+		_, err = h.db.Exec(`
+		UPDATE Notifications SET read = TRUE WHERE id = $1 AND NOT read`, id)
+	} else {
+		_, err = h.db.Exec(`
+		UPDATE Notifications SET read = TRUE
+		WHERE id = $1 AND notificationId = ANY($2)`, id, pq.Array(notificationIds))
+	}
+	return formatError(err, "notification", "marking notifications read")
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestNotifications(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestPost(t, h, "alice-id", "post")
+
+	now := time.Now()
+	notifications := []struct {
+		notification models.Notification
+		created      bool
+	}{
+		{models.Notification{NotificationId: "follow", Id: "alice-id", ActorId: "bob-id", Kind: models.FollowNotification, Time: now.Add(-time.Minute)}, true},
+		{models.Notification{NotificationId: "upvote", Id: "alice-id", ActorId: "bob-id", Kind: models.UpvoteNotification, PostId: "post", Time: now}, true},
+		// Upvoting the same post again doesn't notify twice
+		{models.Notification{NotificationId: "upvote-again", Id: "alice-id", ActorId: "bob-id", Kind: models.UpvoteNotification, PostId: "post", Time: now}, false},
+	}
+	for _, n := range notifications {
+		created, err := h.CreateNotification(n.notification)
+		if err != nil {
+			t.Fatalf("Could not create notification %v: %v", n.notification.NotificationId, err)
+		}
+		if created != n.created {
+			t.Errorf("Got created %v for %v, want %v", created, n.notification.NotificationId, n.created)
+		}
+	}
+
+	_, err := h.CreateNotification(models.Notification{NotificationId: "unknown", Id: "alice-id", ActorId: "carol-id", Kind: models.FollowNotification, Time: now})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	_, err = h.CreateNotification(models.Notification{NotificationId: "deleted", Id: "alice-id", ActorId: "bob-id", Kind: models.CommentNotification, PostId: "post", CommentId: "comment", Time: now})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	got, err := h.GetNotifications("alice-id", false, 10)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Got notifications %+v, %v, want 2", got, err)
+	}
+	if got[0].NotificationId != "upvote" || got[0].PostId != "post" || got[0].ActorUsername != "bob" || got[0].Read {
+		t.Errorf("Got notification %+v, want bob's unread upvote", got[0])
+	}
+	if got[1].NotificationId != "follow" || got[1].PostId != "" || got[1].CommentId != "" {
+		t.Errorf("Got notification %+v, want bob's follow", got[1])
+	}
+
+	count, err := h.GetUnreadNotificationCount("alice-id")
+	if err != nil || count != 2 {
+		t.Errorf("Got %v, %v unread, want 2", count, err)
+	}
+
+	// Marking another user's notifications does nothing
+	if err = h.MarkNotificationsRead("bob-id", []string{"follow"}); err != nil {
+		t.Fatalf("Could not mark notifications read: %v", err)
+	}
+	if err = h.MarkNotificationsRead("alice-id", []string{"follow"}); err != nil {
+		t.Fatalf("Could not mark notifications read: %v", err)
+	}
+
+	got, err = h.GetNotifications("alice-id", true, 10)
+	if err != nil || len(got) != 1 || got[0].NotificationId != "upvote" {
+		t.Errorf("Got unread notifications %+v, %v, want the upvote", got, err)
+	}
+
+	if err = h.MarkNotificationsRead("alice-id", nil); err != nil {
+		t.Fatalf("Could not mark notifications read: %v", err)
+	}
+
+	count, err = h.GetUnreadNotificationCount("alice-id")
+	if err != nil || count != 0 {
+		t.Errorf("Got %v, %v unread, want 0", count, err)
+	}
+
+	got, err = h.GetNotifications("alice-id", false, 1)
+	if err != nil || len(got) != 1 || !got[0].Read {
+		t.Errorf("Got notifications %+v, %v, want one read notification", got, err)
+	}
+}
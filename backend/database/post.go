@@ -5,32 +5,118 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/jbrunsting/transient/backend/models"
 )
 
+const postColumns = `Posts.id, Users.username, Posts.postId, Posts.time, Posts.title,
+	Posts.content, Posts.postUrl, Posts.imageUrl, Posts.previewTitle,
+	Posts.previewDescription, Posts.previewImageUrl, Posts.format,
+	ARRAY(SELECT tag FROM PostTags WHERE PostTags.postId = Posts.postId ORDER BY tag)`
+
 type postHandler struct {
 	db *sql.DB
 }
 
+const (
+	// Search snippets mark matched terms with these private use characters,
+	// which the api layer replaces after escaping the snippet
+	HighlightStart = "\uE000"
+	HighlightStop  = "\uE001"
+
+	headlineOptions = "StartSel=" + HighlightStart + ", StopSel=" + HighlightStop +
+		", MaxFragments=2, MaxWords=30, MinWords=10"
+)
+
+// visibleTo returns a condition matching the posts that the user whose ID is
+// in the given query parameter may see, posts from private users are only
+// visible to themselves and their followers, and hidden posts aren't visible
+// to anyone
+func visibleTo(param string) string {
+	return `NOT Posts.hidden AND Users.deletionScheduled IS NULL AND (NOT Users.private OR Posts.id = ` + param + ` OR EXISTS (
+	SELECT 1 FROM Followings
+	WHERE Followings.id = ` + param + ` AND Followings.followingId = Posts.id))`
+}
+
+// scanPost scans a row selected with postColumns, followed by any extra
+// columns into dest
+func scanPost(rows *sql.Rows, dest ...interface{}) (models.Post, error) {
+	var post models.Post
+	var content sql.NullString
+	var postUrl sql.NullString
+	var imageUrl sql.NullString
+	var previewTitle sql.NullString
+	var previewDescription sql.NullString
+	var previewImageUrl sql.NullString
+	cols := []interface{}{&post.Id, &post.Username, &post.PostId, &post.Time, &post.Title, &content, &postUrl, &imageUrl,
+		&previewTitle, &previewDescription, &previewImageUrl, &post.Format, pq.Array(&post.Tags)}
+	err := rows.Scan(append(cols, dest...)...)
+	if err != nil {
+		return post, err
+	}
+
+	post.Content = content.String
+	post.PostUrl = postUrl.String
+	post.ImageUrl = imageUrl.String
+	if previewTitle.Valid || previewDescription.Valid || previewImageUrl.Valid {
+		post.Preview = &models.LinkPreview{
+			Title:       previewTitle.String,
+			Description: previewDescription.String,
+			ImageUrl:    previewImageUrl.String,
+		}
+	}
+
+	return post, nil
+}
+
 func (h *postHandler) CreatePost(p models.Post) error {
-	_, err := h.db.Exec(`
-	INSERT INTO Posts (id, postId, time, title, content, postUrl, imageUrl)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)`, p.Id, p.PostId, p.Time, p.Title, p.Content, p.PostUrl, p.ImageUrl)
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "post", "starting database transaction")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Posts (id, postId, time, title, content, postUrl, imageUrl, format)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, p.Id, p.PostId, p.Time, p.Title, p.Content, p.PostUrl, p.ImageUrl, p.Format)
 	if err != nil {
+		tx.Rollback()
 		return formatError(err, "post", "creating post")
 	}
 
+	for _, tag := range p.Tags {
+		_, err = tx.Exec(`
+		INSERT INTO PostTags (postId, tag, time)
+		VALUES ($1, $2, $3)`, p.PostId, tag, p.Time)
+		if err != nil {
+			tx.Rollback()
+			return formatError(err, "tag", "creating post tag")
+		}
+	}
+
+	err = tx.Commit()
+	return formatError(err, "post", "committing database transaction")
+}
+
+func (h *postHandler) UpdatePostPreview(postId string, preview models.LinkPreview) error {
+	_, err := h.db.Exec(`
+	UPDATE Posts SET previewTitle = $2, previewDescription = $3, previewImageUrl = $4
+	WHERE postId = $1`, postId, preview.Title, preview.Description, preview.ImageUrl)
+	if err != nil {
+		return formatError(err, "post", "updating post preview")
+	}
+
 	return nil
 }
 
-func (h *postHandler) GetUserPosts(id string) ([]models.Post, error) {
+func (h *postHandler) GetUserPosts(id, viewerId string) ([]models.Post, error) {
 	posts := []models.Post{}
 
 	rows, err := h.db.Query(`
-	SELECT Posts.id, Users.username, postId, time, title, content, postUrl, imageUrl
+	SELECT `+postColumns+`
 	FROM Posts
 	INNER JOIN Users on Users.id = Posts.id
-    WHERE Posts.id = $1 ORDER BY time DESC`, id)
+    WHERE Posts.id = $1 AND `+visibleTo("$2")+` ORDER BY time DESC`, id, viewerId)
 	if err != nil {
 		return posts, formatError(err, "post", "getting posts")
 	}
@@ -38,16 +124,10 @@ func (h *postHandler) GetUserPosts(id string) ([]models.Post, error) {
 
 	for rows.Next() {
 		var post models.Post
-		var content sql.NullString
-		var postUrl sql.NullString
-		var imageUrl sql.NullString
-		if err = rows.Scan(&post.Id, &post.Username, &post.PostId, &post.Time, &post.Title, &content, &postUrl, &imageUrl); err != nil {
+		if post, err = scanPost(rows); err != nil {
 			break
 		}
 
-		post.Content = content.String
-		post.PostUrl = postUrl.String
-		post.ImageUrl = imageUrl.String
 		posts = append(posts, post)
 	}
 
@@ -66,17 +146,23 @@ func (h *postHandler) GetUserPosts(id string) ([]models.Post, error) {
 }
 
 func (h *postHandler) GetPost(postId string) (models.Post, error) {
-	posts, err := h.GetPosts([]string{postId})
+	posts, err := h.getPosts([]string{postId}, nil)
 	if err != nil {
 		return models.Post{}, err
 	}
 	if len(posts) == 0 {
-		return posts[0], &NotFoundError{"post"}
+		return models.Post{}, &NotFoundError{"post"}
 	}
 	return posts[0], nil
 }
 
-func (h *postHandler) GetPosts(postIds []string) ([]models.Post, error) {
+func (h *postHandler) GetPosts(postIds []string, viewerId string) ([]models.Post, error) {
+	return h.getPosts(postIds, &viewerId)
+}
+
+// getPosts gets the given posts, only including the posts visible to viewerId
+// unless it is nil
+func (h *postHandler) getPosts(postIds []string, viewerId *string) ([]models.Post, error) {
 	posts := []models.Post{}
 
 	if len(postIds) == 0 {
@@ -93,11 +179,17 @@ func (h *postHandler) GetPosts(postIds []string) ([]models.Post, error) {
 		inQuery += fmt.Sprintf(", $%v", i)
 	}
 
+	visibleCondition := ""
+	if viewerId != nil {
+		postIdsInterface = append(postIdsInterface, *viewerId)
+		visibleCondition = "AND " + visibleTo(fmt.Sprintf("$%v", len(postIdsInterface)))
+	}
+
 	rows, err := h.db.Query(`
-	SELECT Posts.id, Users.username, postId, time, title, content, postUrl, imageUrl
+	SELECT `+postColumns+`
 	FROM Posts
 	INNER JOIN Users ON Users.id = Posts.id
-    WHERE postId IN (`+inQuery+`)
+    WHERE postId IN (`+inQuery+`) `+visibleCondition+`
 	`, postIdsInterface...)
 	if err != nil {
 		return posts, formatError(err, "post", "retrieving posts")
@@ -106,17 +198,10 @@ func (h *postHandler) GetPosts(postIds []string) ([]models.Post, error) {
 
 	for rows.Next() {
 		var post models.Post
-		var content sql.NullString
-		var postUrl sql.NullString
-		var imageUrl sql.NullString
-		err = rows.Scan(&post.Id, &post.Username, &post.PostId, &post.Time, &post.Title, &content, &postUrl, &imageUrl)
-		if err != nil {
+		if post, err = scanPost(rows); err != nil {
 			break
 		}
 
-		post.Content = content.String
-		post.PostUrl = postUrl.String
-		post.ImageUrl = imageUrl.String
 		posts = append(posts, post)
 	}
 
@@ -147,10 +232,11 @@ func (h *postHandler) GetFollowingsPosts(id string) ([]models.Post, error) {
 	posts := []models.Post{}
 
 	rows, err := h.db.Query(`
-	SELECT Posts.id, Users.username, postId, time, title, content, postUrl, imageUrl FROM Posts
+	SELECT `+postColumns+` FROM Posts
 	INNER JOIN Followings on Followings.followingId = Posts.id
 	INNER JOIN Users on Users.id = Posts.id
-	WHERE Followings.id = $1
+	WHERE Followings.id = $1 AND NOT Posts.hidden AND Users.deletionScheduled IS NULL AND NOT EXISTS (
+		SELECT 1 FROM Mutes WHERE Mutes.id = $1 AND Mutes.mutedId = Posts.id)
 	ORDER BY time DESC`, id)
 	if err != nil {
 		return posts, formatError(err, "post", "getting posts")
@@ -159,16 +245,10 @@ func (h *postHandler) GetFollowingsPosts(id string) ([]models.Post, error) {
 
 	for rows.Next() {
 		var post models.Post
-		var content sql.NullString
-		var postUrl sql.NullString
-		var imageUrl sql.NullString
-		if err = rows.Scan(&post.Id, &post.Username, &post.PostId, &post.Time, &post.Title, &content, &postUrl, &imageUrl); err != nil {
+		if post, err = scanPost(rows); err != nil {
 			break
 		}
 
-		post.Content = content.String
-		post.PostUrl = postUrl.String
-		post.ImageUrl = imageUrl.String
 		posts = append(posts, post)
 	}
 
@@ -186,6 +266,53 @@ func (h *postHandler) GetFollowingsPosts(id string) ([]models.Post, error) {
 	return posts, nil
 }
 
+func (h *postHandler) SearchPosts(query, viewerId string, after *models.SearchResult, limit int) ([]models.SearchResult, error) {
+	results := []models.SearchResult{}
+
+	args := []interface{}{query, time.Now().Add(-models.PostLifetime), limit, headlineOptions, viewerId}
+	cursorCondition := ""
+	if after != nil {
+		cursorCondition = "AND (ts_rank(Posts.searchVector, query), Posts.postId) < ($6::real, $7)"
+		args = append(args, after.Rank, after.PostId)
+	}
+
+	rows, err := h.db.Query(`
+	SELECT `+postColumns+`, ts_rank(Posts.searchVector, query) AS rank,
+	ts_headline('english', Posts.title || E'\n' || coalesce(Posts.content, ''), query, $4)
+	FROM Posts
+	INNER JOIN Users ON Users.id = Posts.id,
+	websearch_to_tsquery('english', $1) query
+	WHERE Posts.searchVector @@ query AND Posts.time > $2 AND `+visibleTo("$5")+` `+cursorCondition+`
+	ORDER BY rank DESC, Posts.postId DESC
+	LIMIT $3`, args...)
+	if err != nil {
+		return results, formatError(err, "post", "searching posts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result models.SearchResult
+		if result.Post, err = scanPost(rows, &result.Rank, &result.Snippet); err != nil {
+			break
+		}
+
+		results = append(results, result)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return results, &UnexpectedError{
+			Action:        "parsing search results",
+			InternalError: err.Error(),
+		}
+	}
+
+	return results, nil
+}
+
 func (h *postHandler) CreateVote(id string, postId string, vote int) error {
 	_, err := h.db.Exec(`
 	INSERT INTO Votes (id, postId, time, vote)
@@ -198,23 +325,44 @@ func (h *postHandler) CreateVote(id string, postId string, vote int) error {
 	return nil
 }
 
+func (h *postHandler) GetVoteCounts(postId string) (models.VoteCounts, error) {
+	var counts models.VoteCounts
+	err := h.db.QueryRow(`
+	SELECT COUNT(*) FILTER (WHERE vote = $2), COUNT(*) FILTER (WHERE vote = $3)
+	FROM Votes WHERE postId = $1`, postId, models.UPVOTE, models.DOWNVOTE).Scan(&counts.Upvotes, &counts.Downvotes)
+	return counts, formatError(err, "vote", "counting votes")
+}
+
 func (h *postHandler) CreateComment(postId string, p models.Comment) error {
 	_, err := h.db.Exec(`
-	INSERT INTO Comments (id, postId, commentId, time, content)
-    VALUES ($1, $2, $3, $4, $5)`, p.Id, postId, p.CommentId, p.Time, p.Content)
+	INSERT INTO Comments (id, postId, commentId, time, content, format)
+    VALUES ($1, $2, $3, $4, $5, $6)`, p.Id, postId, p.CommentId, p.Time, p.Content, p.Format)
 	if err != nil {
-		return formatError(err, "vote", "creating vote")
+		return formatError(err, "comment", "creating comment")
 	}
 
 	return nil
 }
 
-func (h *postHandler) GetComments(postId string) ([]models.Comment, error) {
+func (h *postHandler) CanViewPost(postId, viewerId string) error {
+	var exists int
+	err := h.db.QueryRow(`
+	SELECT 1 FROM Posts
+	INNER JOIN Users ON Users.id = Posts.id
+	WHERE postId = $1 AND `+visibleTo("$2"), postId, viewerId).Scan(&exists)
+	return formatError(err, "post", "checking post visibility")
+}
+
+func (h *postHandler) GetComments(postId, viewerId string) ([]models.Comment, error) {
 	comments := []models.Comment{}
 
+	if err := h.CanViewPost(postId, viewerId); err != nil {
+		return comments, err
+	}
+
 	rows, err := h.db.Query(`
-	SELECT id, commentId, time, content
-	FROM Comments WHERE postId = $1
+	SELECT id, commentId, time, content, format
+	FROM Comments WHERE postId = $1 AND NOT hidden
 	ORDER BY time DESC`, postId)
 	if err != nil {
 		return comments, formatError(err, "comments", "getting comments")
@@ -223,7 +371,7 @@ func (h *postHandler) GetComments(postId string) ([]models.Comment, error) {
 
 	for rows.Next() {
 		var comment models.Comment
-		if err = rows.Scan(&comment.Id, &comment.CommentId, &comment.Time, &comment.Content); err != nil {
+		if err = rows.Scan(&comment.Id, &comment.CommentId, &comment.Time, &comment.Content, &comment.Format); err != nil {
 			break
 		}
 
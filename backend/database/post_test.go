@@ -0,0 +1,373 @@
+package database
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func postIds(posts []models.Post) []string {
+	ids := []string{}
+	for _, p := range posts {
+		ids = append(ids, p.PostId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestCreatePost(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	createTestPost(t, h, "alice-id", "post", "go", "db")
+
+	p, err := h.GetPost("post")
+	if err != nil {
+		t.Fatalf("Could not get post: %v", err)
+	}
+	if p.Id != "alice-id" || p.Username != "alice" || p.Title != "Title of post" || p.Preview != nil {
+		t.Errorf("Got post %+v, want alice's post", p)
+	}
+	if !reflect.DeepEqual(p.Tags, []string{"db", "go"}) {
+		t.Errorf("Got tags %v, want db and go", p.Tags)
+	}
+
+	tests := []struct {
+		name string
+		post models.Post
+		want error
+	}{{
+		name: "duplicate post",
+		post: models.Post{Id: "alice-id", PostId: "post", Time: time.Now(), Title: "Post", Format: models.PlainFormat},
+		want: &UniquenessViolation{},
+	}, {
+		name: "duplicate tag",
+		post: models.Post{Id: "alice-id", PostId: "tagged", Time: time.Now(), Title: "Post", Format: models.PlainFormat, Tags: []string{"go", "go"}},
+		want: &UniquenessViolation{},
+	}, {
+		name: "tag too long",
+		post: models.Post{Id: "alice-id", PostId: "tagged", Time: time.Now(), Title: "Post", Format: models.PlainFormat, Tags: []string{strings.Repeat("t", 65)}},
+		want: &DataViolation{},
+	}, {
+		name: "unknown user",
+		post: models.Post{Id: "carol-id", PostId: "carol-post", Time: time.Now(), Title: "Post", Format: models.PlainFormat},
+		want: &ForeignKeyViolation{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := h.CreatePost(test.post)
+			checkError(t, err, test.want)
+
+			// The post isn't kept if its tags can't be
+			if test.post.PostId != "post" {
+				_, err = h.GetPost(test.post.PostId)
+				checkError(t, err, &NotFoundError{})
+			}
+		})
+	}
+}
+
+func TestGetPosts(t *testing.T) {
+	h := newTestHandler(t)
+	for _, username := range []string{"alice", "bob", "carol", "dave"} {
+		createTestUser(t, h, username)
+	}
+
+	// bob is private and only followed by alice, dave is being deleted
+	if err := h.SetPrivate("bob-id", true); err != nil {
+		t.Fatalf("Could not set private: %v", err)
+	}
+	if _, err := h.CreateFollowing("alice-id", "bob-id"); err != nil {
+		t.Fatalf("Could not create following: %v", err)
+	}
+
+	createTestPost(t, h, "alice-id", "alice-post")
+	createTestPost(t, h, "bob-id", "bob-post")
+	createTestPost(t, h, "carol-id", "carol-post")
+	createTestPost(t, h, "carol-id", "hidden-post")
+	createTestPost(t, h, "dave-id", "dave-post")
+	if _, err := h.db.Exec(`UPDATE Posts SET hidden = TRUE WHERE postId = 'hidden-post'`); err != nil {
+		t.Fatalf("Could not hide post: %v", err)
+	}
+	if err := h.ScheduleUserDeletion("dave-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+
+	all := []string{"alice-post", "bob-post", "carol-post", "dave-post", "hidden-post"}
+
+	tests := []struct {
+		viewerId string
+		want     []string
+	}{{
+		viewerId: "alice-id",
+		want:     []string{"alice-post", "bob-post", "carol-post"},
+	}, {
+		viewerId: "bob-id",
+		want:     []string{"alice-post", "bob-post", "carol-post"},
+	}, {
+		viewerId: "carol-id",
+		want:     []string{"alice-post", "carol-post"},
+	}, {
+		viewerId: "",
+		want:     []string{"alice-post", "carol-post"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.viewerId, func(t *testing.T) {
+			posts, err := h.GetPosts(all, test.viewerId)
+			if err != nil || !reflect.DeepEqual(postIds(posts), test.want) {
+				t.Errorf("Got posts %v, %v, want %v", postIds(posts), err, test.want)
+			}
+
+			for _, postId := range all {
+				err := h.CanViewPost(postId, test.viewerId)
+				visible := false
+				for _, want := range test.want {
+					visible = visible || want == postId
+				}
+				if visible {
+					checkError(t, err, nil)
+				} else {
+					checkError(t, err, &NotFoundError{})
+				}
+			}
+
+			posts, err = h.GetUserPosts("bob-id", test.viewerId)
+			wantBob := test.viewerId == "alice-id" || test.viewerId == "bob-id"
+			if err != nil || (len(posts) == 1) != wantBob {
+				t.Errorf("Got bob's posts %v, %v, want visible %v", postIds(posts), err, wantBob)
+			}
+		})
+	}
+
+	posts, err := h.GetPosts(nil, "alice-id")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("Got %v, %v for no IDs, want no posts", postIds(posts), err)
+	}
+
+	// GetPost ignores visibility
+	if _, err = h.GetPost("hidden-post"); err != nil {
+		t.Errorf("Could not get hidden post: %v", err)
+	}
+
+	_, err = h.GetPost("unknown-post")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestUpdatePostPreview(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestPost(t, h, "alice-id", "post")
+
+	preview := models.LinkPreview{Title: "Preview", Description: "Description", ImageUrl: "https://example.com/image.png"}
+	if err := h.UpdatePostPreview("post", preview); err != nil {
+		t.Fatalf("Could not update preview: %v", err)
+	}
+
+	p, err := h.GetPost("post")
+	if err != nil || p.Preview == nil || *p.Preview != preview {
+		t.Errorf("Got preview %+v, %v, want %+v", p.Preview, err, preview)
+	}
+
+	if err = h.UpdatePostPreview("unknown-post", preview); err != nil {
+		t.Errorf("Got %v updating a deleted post's preview, want nil", err)
+	}
+}
+
+func TestDeletePost(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestPost(t, h, "alice-id", "post", "go")
+
+	comment := models.Comment{Id: "alice-id", CommentId: "comment", Time: time.Now(), Content: "Comment", Format: models.PlainFormat}
+	if err := h.CreateComment("post", comment); err != nil {
+		t.Fatalf("Could not create comment: %v", err)
+	}
+	if err := h.CreateVote("alice-id", "post", models.UPVOTE); err != nil {
+		t.Fatalf("Could not create vote: %v", err)
+	}
+
+	if err := h.DeletePost("post"); err != nil {
+		t.Fatalf("Could not delete post: %v", err)
+	}
+
+	_, err := h.GetPost("post")
+	checkError(t, err, &NotFoundError{})
+
+	comments, err := h.GetUserComments("alice-id")
+	if err != nil || len(comments) != 0 {
+		t.Errorf("Got comments %+v, %v, want none", comments, err)
+	}
+
+	if err = h.DeletePost("post"); err != nil {
+		t.Errorf("Could not delete post twice: %v", err)
+	}
+}
+
+func TestGetFollowingsPosts(t *testing.T) {
+	h := newTestHandler(t)
+	for _, username := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		createTestUser(t, h, username)
+	}
+
+	for _, id := range []string{"bob-id", "carol-id", "erin-id"} {
+		if _, err := h.CreateFollowing("alice-id", id); err != nil {
+			t.Fatalf("Could not create following: %v", err)
+		}
+	}
+	if err := h.CreateMute("alice-id", "carol-id"); err != nil {
+		t.Fatalf("Could not create mute: %v", err)
+	}
+
+	createTestPost(t, h, "bob-id", "bob-post")
+	createTestPost(t, h, "bob-id", "hidden-post")
+	createTestPost(t, h, "carol-id", "carol-post")
+	createTestPost(t, h, "dave-id", "dave-post")
+	createTestPost(t, h, "erin-id", "erin-post")
+	if err := h.ScheduleUserDeletion("erin-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+	if _, err := h.db.Exec(`UPDATE Posts SET hidden = TRUE WHERE postId = 'hidden-post'`); err != nil {
+		t.Fatalf("Could not hide post: %v", err)
+	}
+
+	posts, err := h.GetFollowingsPosts("alice-id")
+	if err != nil || !reflect.DeepEqual(postIds(posts), []string{"bob-post"}) {
+		t.Errorf("Got posts %v, %v, want bob-post", postIds(posts), err)
+	}
+
+	posts, err = h.GetFollowingsPosts("dave-id")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("Got posts %v, %v, want none", postIds(posts), err)
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	posts := []models.Post{
+		{Id: "alice-id", PostId: "a", Time: time.Now(), Title: "Gophers", Content: "Gophers dig tunnels", Format: models.PlainFormat},
+		{Id: "alice-id", PostId: "b", Time: time.Now(), Title: "Tunnels", Content: "Gophers live in them", Format: models.PlainFormat},
+		{Id: "alice-id", PostId: "c", Time: time.Now(), Title: "Unrelated", Content: "Nothing to see", Format: models.PlainFormat},
+		{Id: "alice-id", PostId: "d", Time: time.Now().Add(-2 * models.PostLifetime), Title: "Gophers", Format: models.PlainFormat},
+		{Id: "bob-id", PostId: "e", Time: time.Now(), Title: "Private gophers", Format: models.PlainFormat},
+	}
+	for _, p := range posts {
+		if err := h.CreatePost(p); err != nil {
+			t.Fatalf("Could not create post: %v", err)
+		}
+	}
+	if err := h.SetPrivate("bob-id", true); err != nil {
+		t.Fatalf("Could not set private: %v", err)
+	}
+
+	results, err := h.SearchPosts("gophers", "alice-id", nil, 1)
+	if err != nil || len(results) != 1 || results[0].PostId != "a" {
+		t.Fatalf("Got results %+v, %v, want a first", results, err)
+	}
+	if !strings.Contains(results[0].Snippet, HighlightStart+"Gophers"+HighlightStop) {
+		t.Errorf("Got snippet %q, want gophers highlighted", results[0].Snippet)
+	}
+
+	results, err = h.SearchPosts("gophers", "alice-id", &results[0], 10)
+	if err != nil || len(results) != 1 || results[0].PostId != "b" {
+		t.Errorf("Got results %+v, %v after a, want b", results, err)
+	}
+
+	results, err = h.SearchPosts("gophers", "bob-id", nil, 10)
+	if err != nil || len(results) != 3 {
+		t.Errorf("Got results %+v, %v for bob, want his private post too", results, err)
+	}
+
+	results, err = h.SearchPosts("aardvarks", "alice-id", nil, 10)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Got results %+v, %v, want none", results, err)
+	}
+}
+
+func TestVotes(t *testing.T) {
+	h := newTestHandler(t)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		createTestUser(t, h, username)
+	}
+	createTestPost(t, h, "alice-id", "post")
+
+	votes := []struct {
+		id   string
+		vote int
+	}{{"alice-id", models.UPVOTE}, {"bob-id", models.UPVOTE}, {"carol-id", models.DOWNVOTE}, {"bob-id", models.DOWNVOTE}}
+	for _, v := range votes {
+		if err := h.CreateVote(v.id, "post", v.vote); err != nil {
+			t.Fatalf("Could not create vote: %v", err)
+		}
+	}
+
+	counts, err := h.GetVoteCounts("post")
+	if err != nil || counts != (models.VoteCounts{Upvotes: 1, Downvotes: 2}) {
+		t.Errorf("Got counts %+v, %v, want 1 up and 2 down", counts, err)
+	}
+
+	counts, err = h.GetVoteCounts("unknown-post")
+	if err != nil || counts != (models.VoteCounts{}) {
+		t.Errorf("Got counts %+v, %v for unknown post, want none", counts, err)
+	}
+
+	err = h.CreateVote("alice-id", "unknown-post", models.UPVOTE)
+	checkError(t, err, &ForeignKeyViolation{})
+
+	err = h.CreateVote("dave-id", "post", models.UPVOTE)
+	checkError(t, err, &ForeignKeyViolation{})
+}
+
+func TestComments(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestPost(t, h, "alice-id", "post")
+	createTestPost(t, h, "bob-id", "private-post")
+	if err := h.SetPrivate("bob-id", true); err != nil {
+		t.Fatalf("Could not set private: %v", err)
+	}
+
+	now := time.Now()
+	comments := []models.Comment{
+		{Id: "bob-id", CommentId: "first", Time: now.Add(-time.Minute), Content: "First", Format: models.PlainFormat},
+		{Id: "alice-id", CommentId: "second", Time: now, Content: "Second", Format: models.MarkdownFormat},
+		{Id: "bob-id", CommentId: "hidden", Time: now, Content: "Hidden", Format: models.PlainFormat},
+	}
+	for _, c := range comments {
+		if err := h.CreateComment("post", c); err != nil {
+			t.Fatalf("Could not create comment: %v", err)
+		}
+	}
+	if _, err := h.db.Exec(`UPDATE Comments SET hidden = TRUE WHERE commentId = 'hidden'`); err != nil {
+		t.Fatalf("Could not hide comment: %v", err)
+	}
+
+	err := h.CreateComment("post", comments[0])
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateComment("unknown-post", models.Comment{Id: "alice-id", CommentId: "other", Time: now, Content: "Other", Format: models.PlainFormat})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	got, err := h.GetComments("post", "bob-id")
+	if err != nil || len(got) != 2 || got[0].CommentId != "second" || got[1].CommentId != "first" {
+		t.Fatalf("Got comments %+v, %v, want second then first", got, err)
+	}
+	if got[0].PostId != "post" || got[0].Format != models.MarkdownFormat {
+		t.Errorf("Got comment %+v, want the second comment on post", got[0])
+	}
+
+	_, err = h.GetComments("private-post", "alice-id")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.GetComments("unknown-post", "alice-id")
+	checkError(t, err, &NotFoundError{})
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type profileHandler struct {
+	db *sql.DB
+}
+
+// GetProfile returns the user's profile as seen by viewerId, which is empty if
+// the viewer isn't logged in
+func (h *profileHandler) GetProfile(id, viewerId string) (models.Profile, error) {
+	var p models.Profile
+	var displayName sql.NullString
+	var bio sql.NullString
+	var avatarUrl sql.NullString
+	var link sql.NullString
+
+	err := h.db.QueryRow(`
+	SELECT id, username, private, displayName, bio, avatarUrl, link,
+		(SELECT COUNT(*) FROM Followings WHERE followingId = Users.id),
+		(SELECT COUNT(*) FROM Followings WHERE Followings.id = Users.id),
+		(SELECT COUNT(*) FROM Posts WHERE Posts.id = Users.id AND Posts.time > $3 AND NOT Posts.hidden),
+		EXISTS (SELECT 1 FROM Followings WHERE Followings.id = $2 AND followingId = Users.id)
+	FROM Users
+	WHERE id = $1 AND deletionScheduled IS NULL`, id, viewerId, time.Now().Add(-models.PostLifetime)).Scan(
+		&p.Id, &p.Username, &p.Private, &displayName, &bio, &avatarUrl, &link,
+		&p.Followers, &p.Followings, &p.Posts, &p.IsFollowing)
+	if err != nil {
+		return p, formatError(err, "user", "getting profile")
+	}
+
+	p.DisplayName = displayName.String
+	p.Bio = bio.String
+	p.AvatarUrl = avatarUrl.String
+	p.Link = link.String
+
+	return p, nil
+}
+
+// UpdateProfile sets the fields of the update that aren't nil
+func (h *profileHandler) UpdateProfile(id string, update models.ProfileUpdate) error {
+	res, err := h.db.Exec(`
+	UPDATE Users SET
+		displayName = COALESCE($2, displayName),
+		bio = COALESCE($3, bio),
+		avatarUrl = COALESCE($4, avatarUrl),
+		link = COALESCE($5, link)
+	WHERE id = $1`, id, update.DisplayName, update.Bio, update.AvatarUrl, update.Link)
+	if err != nil {
+		return formatError(err, "user", "updating profile")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "user", "updating profile")
+	} else if n == 0 {
+		return &NotFoundError{"user"}
+	}
+
+	return nil
+}
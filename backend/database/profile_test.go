@@ -0,0 +1,99 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestGetProfile(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	for _, f := range [][2]string{{"bob-id", "alice-id"}, {"carol-id", "alice-id"}, {"alice-id", "bob-id"}} {
+		if _, err := h.CreateFollowing(f[0], f[1]); err != nil {
+			t.Fatalf("Could not create following: %v", err)
+		}
+	}
+
+	createTestPost(t, h, "alice-id", "alice-post")
+	old := models.Post{Id: "alice-id", PostId: "old-post", Time: time.Now().Add(-2 * models.PostLifetime), Title: "Old", Format: models.PlainFormat}
+	if err := h.CreatePost(old); err != nil {
+		t.Fatalf("Could not create post: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		viewerId  string
+		following bool
+	}{{
+		name:      "follower",
+		viewerId:  "bob-id",
+		following: true,
+	}, {
+		name:     "self",
+		viewerId: "alice-id",
+	}, {
+		name: "logged out",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := h.GetProfile("alice-id", test.viewerId)
+			if err != nil {
+				t.Fatalf("Could not get profile: %v", err)
+			}
+
+			if p.Username != "alice" || p.Followers != 2 || p.Followings != 1 || p.Posts != 1 {
+				t.Errorf("Got profile %+v, want alice with 2 followers, 1 following and 1 post", p)
+			}
+			if p.IsFollowing != test.following {
+				t.Errorf("Got following %v, want %v", p.IsFollowing, test.following)
+			}
+		})
+	}
+
+	_, err := h.GetProfile("dave-id", "alice-id")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.ScheduleUserDeletion("carol-id", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Could not schedule deletion: %v", err)
+	}
+	_, err = h.GetProfile("carol-id", "alice-id")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestUpdateProfile(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	displayName := "Alice"
+	bio := "Hello"
+	if err := h.UpdateProfile("alice-id", models.ProfileUpdate{DisplayName: &displayName, Bio: &bio}); err != nil {
+		t.Fatalf("Could not update profile: %v", err)
+	}
+
+	link := "https://example.com"
+	if err := h.UpdateProfile("alice-id", models.ProfileUpdate{Link: &link}); err != nil {
+		t.Fatalf("Could not update profile: %v", err)
+	}
+
+	p, err := h.GetProfile("alice-id", "")
+	if err != nil {
+		t.Fatalf("Could not get profile: %v", err)
+	}
+	if p.DisplayName != displayName || p.Bio != bio || p.Link != link || p.AvatarUrl != "" {
+		t.Errorf("Got profile %+v, want both updates applied", p)
+	}
+
+	tooLong := strings.Repeat("a", 65)
+	err = h.UpdateProfile("alice-id", models.ProfileUpdate{DisplayName: &tooLong})
+	checkError(t, err, &DataViolation{})
+
+	err = h.UpdateProfile("carol-id", models.ProfileUpdate{Bio: &bio})
+	checkError(t, err, &NotFoundError{})
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type tagHandler struct {
+	db *sql.DB
+}
+
+func (h *tagHandler) GetTagPosts(tag, viewerId string) ([]models.Post, error) {
+	posts := []models.Post{}
+
+	rows, err := h.db.Query(`
+	SELECT `+postColumns+` FROM Posts
+	INNER JOIN PostTags ON PostTags.postId = Posts.postId
+	INNER JOIN Users ON Users.id = Posts.id
+	WHERE PostTags.tag = $1 AND Posts.time > $2 AND `+visibleTo("$3")+`
+	ORDER BY Posts.time DESC`, tag, time.Now().Add(-models.PostLifetime), viewerId)
+	if err != nil {
+		return posts, formatError(err, "post", "getting tag posts")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post models.Post
+		if post, err = scanPost(rows); err != nil {
+			break
+		}
+
+		posts = append(posts, post)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return posts, &UnexpectedError{
+			Action:        "parsing posts",
+			InternalError: err.Error(),
+		}
+	}
+
+	return posts, nil
+}
+
+func (h *tagHandler) GetTrendingTags(since time.Time, limit int) ([]models.TrendingTag, error) {
+	tags := []models.TrendingTag{}
+
+	rows, err := h.db.Query(`
+	SELECT tag, COUNT(*) AS count FROM PostTags
+	INNER JOIN Posts ON Posts.postId = PostTags.postId
+	WHERE PostTags.time > $1 AND NOT Posts.hidden
+	GROUP BY tag
+	ORDER BY count DESC, tag
+	LIMIT $2`, since, limit)
+	if err != nil {
+		return tags, formatError(err, "tag", "getting trending tags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag models.TrendingTag
+		if err = rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			break
+		}
+
+		tags = append(tags, tag)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return tags, &UnexpectedError{
+			Action:        "parsing tags",
+			InternalError: err.Error(),
+		}
+	}
+
+	return tags, nil
+}
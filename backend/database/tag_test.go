@@ -0,0 +1,65 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestGetTagPosts(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	if err := h.SetPrivate("bob-id", true); err != nil {
+		t.Fatalf("Could not set private: %v", err)
+	}
+
+	createTestPost(t, h, "alice-id", "alice-post", "go")
+	createTestPost(t, h, "alice-id", "other-post", "rust")
+	createTestPost(t, h, "bob-id", "bob-post", "go")
+	old := models.Post{Id: "alice-id", PostId: "old-post", Time: time.Now().Add(-2 * models.PostLifetime), Title: "Old", Format: models.PlainFormat, Tags: []string{"go"}}
+	if err := h.CreatePost(old); err != nil {
+		t.Fatalf("Could not create post: %v", err)
+	}
+
+	posts, err := h.GetTagPosts("go", "alice-id")
+	if err != nil || !reflect.DeepEqual(postIds(posts), []string{"alice-post"}) {
+		t.Errorf("Got posts %v, %v, want alice-post", postIds(posts), err)
+	}
+
+	posts, err = h.GetTagPosts("go", "bob-id")
+	if err != nil || !reflect.DeepEqual(postIds(posts), []string{"alice-post", "bob-post"}) {
+		t.Errorf("Got posts %v, %v for bob, want his private post too", postIds(posts), err)
+	}
+
+	posts, err = h.GetTagPosts("unknown", "alice-id")
+	if err != nil || len(posts) != 0 {
+		t.Errorf("Got posts %v, %v for unknown tag, want none", postIds(posts), err)
+	}
+}
+
+func TestGetTrendingTags(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	createTestPost(t, h, "alice-id", "a", "go", "db")
+	createTestPost(t, h, "alice-id", "b", "go", "rust")
+	createTestPost(t, h, "alice-id", "c", "go", "rust")
+	createTestPost(t, h, "alice-id", "hidden", "db", "hidden")
+	if _, err := h.db.Exec(`UPDATE Posts SET hidden = TRUE WHERE postId = 'hidden'`); err != nil {
+		t.Fatalf("Could not hide post: %v", err)
+	}
+
+	tags, err := h.GetTrendingTags(time.Now().Add(-time.Hour), 2)
+	want := []models.TrendingTag{{Tag: "go", Count: 3}, {Tag: "rust", Count: 2}}
+	if err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("Got tags %+v, %v, want %+v", tags, err, want)
+	}
+
+	tags, err = h.GetTrendingTags(time.Now().Add(time.Hour), 2)
+	if err != nil || len(tags) != 0 {
+		t.Errorf("Got tags %+v, %v in the future, want none", tags, err)
+	}
+}
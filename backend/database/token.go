@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type tokenHandler struct {
+	db *sql.DB
+}
+
+func (h *tokenHandler) CreateApiToken(t models.ApiToken) error {
+	_, err := h.db.Exec(`
+	INSERT INTO ApiTokens (tokenId, tokenHash, id, name, scopes, time, expiry)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`, t.TokenId, t.TokenHash, t.Id, t.Name, pq.Array(t.Scopes), t.Time, t.Expiry)
+	return formatError(err, "api token", "creating api token")
+}
+
+func (h *tokenHandler) GetApiTokens(id string) ([]models.ApiToken, error) {
+	tokens := []models.ApiToken{}
+
+	rows, err := h.db.Query(`
+	SELECT tokenId, name, scopes, time, expiry, lastUsed FROM ApiTokens
+	WHERE id = $1
+	ORDER BY time DESC`, id)
+	if err != nil {
+		return tokens, formatError(err, "api token", "getting api tokens")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := models.ApiToken{Id: id}
+		var lastUsed pq.NullTime
+		if err = rows.Scan(&t.TokenId, &t.Name, pq.Array(&t.Scopes), &t.Time, &t.Expiry, &lastUsed); err != nil {
+			break
+		}
+		if lastUsed.Valid {
+			t.LastUsed = &lastUsed.Time
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if rows.Err() != nil {
+		err = rows.Err()
+	}
+
+	if err != nil {
+		return tokens, &UnexpectedError{
+			Action:        "parsing api tokens",
+			InternalError: err.Error(),
+		}
+	}
+
+	return tokens, nil
+}
+
+func (h *tokenHandler) CountApiTokens(id string) (int, error) {
+	var count int
+	err := h.db.QueryRow(`SELECT COUNT(*) FROM ApiTokens WHERE id = $1`, id).Scan(&count)
+	return count, formatError(err, "api token", "counting api tokens")
+}
+
+// UseApiToken returns the unexpired token with the given hash, and records
+// that it was used
+func (h *tokenHandler) UseApiToken(tokenHash string) (models.ApiToken, error) {
+	var t models.ApiToken
+	now := time.Now()
+	err := h.db.QueryRow(`
+	UPDATE ApiTokens SET lastUsed = $2
+	WHERE tokenHash = $1 AND expiry > $2
+	RETURNING tokenId, id, name, scopes, time, expiry`, tokenHash, now).Scan(&t.TokenId, &t.Id, &t.Name, pq.Array(&t.Scopes), &t.Time, &t.Expiry)
+	t.LastUsed = &now
+	return t, formatError(err, "api token", "using api token")
+}
+
+func (h *tokenHandler) DeleteApiToken(id, tokenId string) error {
+	res, err := h.db.Exec(`DELETE FROM ApiTokens WHERE id = $1 AND tokenId = $2`, id, tokenId)
+	if err != nil {
+		return formatError(err, "api token", "deleting api token")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "api token", "deleting api token")
+	} else if n == 0 {
+		return &NotFoundError{"api token"}
+	}
+
+	return nil
+}
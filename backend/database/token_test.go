@@ -0,0 +1,101 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestApiTokens(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	now := time.Now()
+	token := func(tokenId, id string, expiry time.Time) models.ApiToken {
+		return models.ApiToken{
+			TokenId:   tokenId,
+			Id:        id,
+			Name:      tokenId + " name",
+			Scopes:    []string{models.ReadScope, models.PostScope},
+			Time:      now,
+			Expiry:    expiry,
+			TokenHash: tokenId + "-hash",
+		}
+	}
+
+	for _, tok := range []models.ApiToken{
+		token("token", "alice-id", now.Add(time.Hour)),
+		token("expired", "alice-id", now.Add(-time.Hour)),
+		token("bob-token", "bob-id", now.Add(time.Hour)),
+	} {
+		if err := h.CreateApiToken(tok); err != nil {
+			t.Fatalf("Could not create token %v: %v", tok.TokenId, err)
+		}
+	}
+
+	duplicateHash := token("other", "alice-id", now.Add(time.Hour))
+	duplicateHash.TokenHash = "token-hash"
+	err := h.CreateApiToken(duplicateHash)
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateApiToken(token("token", "alice-id", now.Add(time.Hour)))
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateApiToken(token("carol-token", "carol-id", now.Add(time.Hour)))
+	checkError(t, err, &ForeignKeyViolation{})
+
+	count, err := h.CountApiTokens("alice-id")
+	if err != nil || count != 2 {
+		t.Errorf("Got %v, %v tokens, want 2", count, err)
+	}
+
+	tokens, err := h.GetApiTokens("alice-id")
+	if err != nil || len(tokens) != 2 {
+		t.Fatalf("Got %+v, %v, want 2 tokens", tokens, err)
+	}
+	for _, tok := range tokens {
+		if tok.LastUsed != nil {
+			t.Errorf("Token %v has been used before it was", tok.TokenId)
+		}
+	}
+
+	used, err := h.UseApiToken("token-hash")
+	if err != nil {
+		t.Fatalf("Could not use token: %v", err)
+	}
+	if used.TokenId != "token" || used.Id != "alice-id" || !reflect.DeepEqual(used.Scopes, []string{models.ReadScope, models.PostScope}) {
+		t.Errorf("Got token %+v, want alice's token", used)
+	}
+
+	tokens, err = h.GetApiTokens("alice-id")
+	if err != nil {
+		t.Fatalf("Could not get tokens: %v", err)
+	}
+	for _, tok := range tokens {
+		if (tok.LastUsed != nil) != (tok.TokenId == "token") {
+			t.Errorf("Got last used %v for token %v", tok.LastUsed, tok.TokenId)
+		}
+	}
+
+	_, err = h.UseApiToken("expired-hash")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.UseApiToken("unknown-hash")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.DeleteApiToken("alice-id", "bob-token")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.DeleteApiToken("alice-id", "token"); err != nil {
+		t.Fatalf("Could not delete token: %v", err)
+	}
+
+	err = h.DeleteApiToken("alice-id", "token")
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.UseApiToken("token-hash")
+	checkError(t, err, &NotFoundError{})
+}
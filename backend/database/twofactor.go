@@ -0,0 +1,177 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+type twoFactorHandler struct {
+	db *sql.DB
+}
+
+// SetTotpSecret stores the secret for a user who is enrolling, it can't
+// replace the secret once two factor authentication is enabled
+func (h *twoFactorHandler) SetTotpSecret(id, secret string) error {
+	res, err := h.db.Exec(`
+	UPDATE Users SET totpSecret = $2
+	WHERE id = $1 AND NOT totpEnabled`, id, secret)
+	if err != nil {
+		return formatError(err, "user", "setting totp secret")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "user", "setting totp secret")
+	} else if n == 0 {
+		return &NotFoundError{"user"}
+	}
+
+	return nil
+}
+
+// EnableTotp turns on two factor authentication once the user has proven they
+// can generate codes, the step of that code is recorded so it can't be reused
+func (h *twoFactorHandler) EnableTotp(id string, step int64, codeHashes []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "user", "starting database transaction")
+	}
+
+	err = execOne(tx, `
+	UPDATE Users SET totpEnabled = TRUE, totpLastStep = $2
+	WHERE id = $1 AND totpSecret IS NOT NULL AND NOT totpEnabled`, id, step)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "user", "enabling totp")
+	}
+
+	if _, err = tx.Exec(`DELETE FROM RecoveryCodes WHERE id = $1`, id); err != nil {
+		tx.Rollback()
+		return formatError(err, "recovery code", "deleting recovery codes")
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec(`
+		INSERT INTO RecoveryCodes (id, codeHash)
+		VALUES ($1, $2)`, id, codeHash)
+		if err != nil {
+			tx.Rollback()
+			return formatError(err, "recovery code", "creating recovery code")
+		}
+	}
+
+	err = tx.Commit()
+	return formatError(err, "user", "committing database transaction")
+}
+
+func (h *twoFactorHandler) DisableTotp(id string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "user", "starting database transaction")
+	}
+
+	err = execOne(tx, `
+	UPDATE Users SET totpEnabled = FALSE, totpSecret = NULL, totpLastStep = 0
+	WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "user", "disabling totp")
+	}
+
+	if _, err = tx.Exec(`DELETE FROM RecoveryCodes WHERE id = $1`, id); err != nil {
+		tx.Rollback()
+		return formatError(err, "recovery code", "deleting recovery codes")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "user", "committing database transaction")
+}
+
+// UseTotpStep records that the code for the given time step has been used,
+// returning a NotFoundError if it or a later code has been used already
+func (h *twoFactorHandler) UseTotpStep(id string, step int64) error {
+	res, err := h.db.Exec(`
+	UPDATE Users SET totpLastStep = $2
+	WHERE id = $1 AND totpEnabled AND totpLastStep < $2`, id, step)
+	if err != nil {
+		return formatError(err, "totp code", "using totp code")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "totp code", "using totp code")
+	} else if n == 0 {
+		return &NotFoundError{"totp code"}
+	}
+
+	return nil
+}
+
+func (h *twoFactorHandler) UseRecoveryCode(id, codeHash string) error {
+	res, err := h.db.Exec(`
+	UPDATE RecoveryCodes SET used = TRUE
+	WHERE id = $1 AND codeHash = $2 AND NOT used`, id, codeHash)
+	if err != nil {
+		return formatError(err, "recovery code", "using recovery code")
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return formatError(err, "recovery code", "using recovery code")
+	} else if n == 0 {
+		return &NotFoundError{"recovery code"}
+	}
+
+	return nil
+}
+
+func (h *twoFactorHandler) CreatePendingLogin(id, tokenHash string, expiry time.Time) error {
+	_, err := h.db.Exec(`
+	INSERT INTO PendingLogins (tokenHash, id, expiry)
+	VALUES ($1, $2, $3)`, tokenHash, id, expiry)
+	return formatError(err, "pending login", "creating pending login")
+}
+
+// GetPendingLogin returns the ID of the user who is part way through logging
+// in, as long as the login hasn't expired or had too many failed attempts
+func (h *twoFactorHandler) GetPendingLogin(tokenHash string, maxAttempts int) (string, error) {
+	var id string
+	err := h.db.QueryRow(`
+	SELECT id FROM PendingLogins
+	WHERE tokenHash = $1 AND expiry > $2 AND attempts < $3`, tokenHash, time.Now(), maxAttempts).Scan(&id)
+	return id, formatError(err, "pending login", "getting pending login")
+}
+
+func (h *twoFactorHandler) FailPendingLogin(tokenHash string) error {
+	_, err := h.db.Exec(`
+	UPDATE PendingLogins SET attempts = attempts + 1
+	WHERE tokenHash = $1`, tokenHash)
+	return formatError(err, "pending login", "updating pending login")
+}
+
+// CompletePendingLogin swaps the pending login for a session, so the same
+// pending login can't be completed twice
+func (h *twoFactorHandler) CompletePendingLogin(tokenHash string, s models.Session) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "pending login", "starting database transaction")
+	}
+
+	err = execOne(tx, `
+	DELETE FROM PendingLogins
+	WHERE tokenHash = $1 AND id = $2`, tokenHash, s.Id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "pending login", "deleting pending login")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO Sessions (id, sessionId, expiry)
+	VALUES ($1, $2, $3)`, s.Id, s.SessionId, s.Expiry)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "session", "creating session")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "pending login", "committing database transaction")
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestTotp(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	err := h.SetTotpSecret("carol-id", "secret")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.EnableTotp("alice-id", 10, []string{"code"})
+	checkError(t, err, &NotFoundError{})
+
+	err = h.UseTotpStep("alice-id", 10)
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.SetTotpSecret("alice-id", "secret"); err != nil {
+		t.Fatalf("Could not set secret: %v", err)
+	}
+
+	// Enabling fails as a whole if a recovery code can't be stored
+	err = h.EnableTotp("alice-id", 10, []string{"code", "code"})
+	checkError(t, err, &UniquenessViolation{})
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || u.TwoFactor || u.TotpSecret != "secret" {
+		t.Fatalf("Got user %+v, %v, want two factor enrolling", u, err)
+	}
+
+	if err = h.EnableTotp("alice-id", 10, []string{"code", "other-code"}); err != nil {
+		t.Fatalf("Could not enable totp: %v", err)
+	}
+
+	u, err = h.GetUserFromId("alice-id")
+	if err != nil || !u.TwoFactor {
+		t.Errorf("Got user %+v, %v, want two factor enabled", u, err)
+	}
+
+	err = h.SetTotpSecret("alice-id", "other-secret")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.EnableTotp("alice-id", 11, nil)
+	checkError(t, err, &NotFoundError{})
+
+	// The step used to enable two factor authentication can't be used again
+	err = h.UseTotpStep("alice-id", 10)
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.UseTotpStep("alice-id", 12); err != nil {
+		t.Fatalf("Could not use step: %v", err)
+	}
+
+	err = h.UseTotpStep("alice-id", 11)
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.UseRecoveryCode("alice-id", "code"); err != nil {
+		t.Fatalf("Could not use recovery code: %v", err)
+	}
+
+	err = h.UseRecoveryCode("alice-id", "code")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.UseRecoveryCode("alice-id", "unknown-code")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.DisableTotp("alice-id"); err != nil {
+		t.Fatalf("Could not disable totp: %v", err)
+	}
+
+	u, err = h.GetUserFromId("alice-id")
+	if err != nil || u.TwoFactor || u.TotpSecret != "" {
+		t.Errorf("Got user %+v, %v, want two factor disabled", u, err)
+	}
+
+	err = h.UseRecoveryCode("alice-id", "other-code")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.DisableTotp("carol-id")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestPendingLogin(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	expiry := time.Now().Add(time.Minute)
+	if err := h.CreatePendingLogin("alice-id", "pending", expiry); err != nil {
+		t.Fatalf("Could not create pending login: %v", err)
+	}
+	if err := h.CreatePendingLogin("alice-id", "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Could not create pending login: %v", err)
+	}
+
+	err := h.CreatePendingLogin("bob-id", "pending", expiry)
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreatePendingLogin("carol-id", "carol-pending", expiry)
+	checkError(t, err, &ForeignKeyViolation{})
+
+	id, err := h.GetPendingLogin("pending", 2)
+	if err != nil || id != "alice-id" {
+		t.Errorf("Got %v, %v, want alice-id", id, err)
+	}
+
+	_, err = h.GetPendingLogin("expired", 2)
+	checkError(t, err, &NotFoundError{})
+
+	_, err = h.GetPendingLogin("unknown", 2)
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.FailPendingLogin("pending"); err != nil {
+		t.Fatalf("Could not fail pending login: %v", err)
+	}
+	if _, err = h.GetPendingLogin("pending", 2); err != nil {
+		t.Errorf("Pending login unavailable after one failure: %v", err)
+	}
+
+	if err = h.FailPendingLogin("pending"); err != nil {
+		t.Fatalf("Could not fail pending login: %v", err)
+	}
+	_, err = h.GetPendingLogin("pending", 2)
+	checkError(t, err, &NotFoundError{})
+
+	s := models.Session{Id: "alice-id", SessionId: "alice-new-session", Expiry: expiry}
+
+	err = h.CompletePendingLogin("pending", models.Session{Id: "bob-id", SessionId: "bob-new-session", Expiry: expiry})
+	checkError(t, err, &NotFoundError{})
+
+	// The pending login is kept if the session can't be created
+	err = h.CompletePendingLogin("pending", models.Session{Id: "alice-id", SessionId: "bob-session", Expiry: expiry})
+	checkError(t, err, &UniquenessViolation{})
+
+	if err = h.CompletePendingLogin("pending", s); err != nil {
+		t.Fatalf("Could not complete pending login: %v", err)
+	}
+
+	err = h.CompletePendingLogin("pending", s)
+	checkError(t, err, &NotFoundError{})
+
+	if _, err = h.GetUserFromSession("alice-new-session"); err != nil {
+		t.Errorf("Could not get user from new session: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/lib/pq"
 
@@ -25,6 +26,14 @@ func (h *userHandler) GetUserFromId(id string) (models.User, error) {
 	return h.getUser("Users.id = $1", id)
 }
 
+func (h *userHandler) GetUserFromEmail(email string) (models.User, error) {
+	return h.getUser("lower(email) = lower($1)", email)
+}
+
+func (h *userHandler) GetUserFromIdentity(provider, subject string) (models.User, error) {
+	return h.getUser("Users.id = (SELECT id FROM Identities WHERE provider = $1 AND subject = $2)", provider, subject)
+}
+
 func (h *userHandler) GetBasicUsers(ids []string) ([]models.User, error) {
 	us := []models.User{}
 
@@ -42,9 +51,6 @@ func (h *userHandler) GetBasicUsers(ids []string) ([]models.User, error) {
 		inQuery += fmt.Sprintf(", $%v", i)
 	}
 
-	fmt.Printf("In query is %s\n", inQuery)
-	fmt.Printf("Ids are %s\n", idsInterface)
-
 	rows, err := h.db.Query(`
     SELECT Users.id, username, email FROM Users
 	WHERE Users.id IN (`+inQuery+`)
@@ -75,7 +81,6 @@ func (h *userHandler) GetBasicUsers(ids []string) ([]models.User, error) {
 		}
 	}
 
-	fmt.Printf("Got to end!\n")
 	return us, nil
 }
 
@@ -83,7 +88,7 @@ func (h *userHandler) getUser(whereCondition string, whereArgs ...interface{}) (
 	var u models.User
 
 	s := fmt.Sprintf(`
-    SELECT Users.id, username, password, email, Sessions.sessionId, Sessions.expiry FROM Users
+    SELECT Users.id, username, password, email, private, role, suspended, verified, usernameChanged, totpSecret, totpEnabled, deletionScheduled, Sessions.sessionId, Sessions.expiry FROM Users
 	LEFT JOIN Sessions ON Users.id = Sessions.id
 	WHERE %v`, whereCondition)
 	rows, err := h.db.Query(s, whereArgs...)
@@ -99,9 +104,15 @@ func (h *userHandler) getUser(whereCondition string, whereArgs ...interface{}) (
 	for {
 		var sessionId sql.NullString
 		var expiry pq.NullTime
-		if err = rows.Scan(&u.Id, &u.Username, &u.Password, &u.Email, &sessionId, &expiry); err != nil {
+		var usernameChanged pq.NullTime
+		var totpSecret sql.NullString
+		var deletionScheduled pq.NullTime
+		if err = rows.Scan(&u.Id, &u.Username, &u.Password, &u.Email, &u.Private, &u.Role, &u.Suspended, &u.Verified, &usernameChanged, &totpSecret, &u.TwoFactor, &deletionScheduled, &sessionId, &expiry); err != nil {
 			break
 		}
+		u.UsernameChanged = usernameChanged.Time
+		u.TotpSecret = totpSecret.String
+		u.DeletionScheduled = deletionScheduled.Time
 
 		if sessionId.Valid && expiry.Valid {
 			u.Sessions = append(u.Sessions, models.Session{
@@ -163,11 +174,6 @@ func (h *userHandler) CreateSession(s models.Session) error {
 	return formatError(err, "session", "creating session")
 }
 
-func (h *userHandler) DeleteUser(id string) error {
-	_, err := h.db.Exec(`DELETE FROM Users WHERE id = $1`, id)
-	return formatError(err, "user", "deleting user")
-}
-
 func (h *userHandler) DeleteSession(sessionId string) error {
 	_, err := h.db.Exec(`DELETE FROM Sessions WHERE sessionId = $1`, sessionId)
 	return formatError(err, "session", "deleting session")
@@ -187,12 +193,176 @@ func (h *userHandler) ChangePassword(id string, password string) error {
 	return formatError(err, "user", "updating password")
 }
 
+// CreatePasswordReset stores the hash of a new reset token for the user,
+// replacing any tokens they haven't used yet
+func (h *userHandler) CreatePasswordReset(id, tokenHash string, expiry time.Time) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "password reset", "starting database transaction")
+	}
+
+	_, err = tx.Exec(`DELETE FROM PasswordResets WHERE id = $1 AND NOT used`, id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "password reset", "deleting password resets")
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO PasswordResets (tokenHash, id, expiry)
+	VALUES ($1, $2, $3)`, tokenHash, id, expiry)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "password reset", "creating password reset")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "password reset", "committing database transaction")
+}
+
+// ConfirmPasswordReset uses up the reset token, sets the new password, and
+// revokes all of the user's sessions and API tokens
+func (h *userHandler) ConfirmPasswordReset(tokenHash, password string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "password reset", "starting database transaction")
+	}
+
+	var id string
+	err = tx.QueryRow(`
+	UPDATE PasswordResets SET used = TRUE
+	WHERE tokenHash = $1 AND NOT used AND expiry > $2
+	RETURNING id`, tokenHash, time.Now()).Scan(&id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "password reset", "using password reset")
+	}
+
+	_, err = tx.Exec(`UPDATE Users SET password = $2 WHERE id = $1`, id, password)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "user", "updating password")
+	}
+
+	_, err = tx.Exec(`DELETE FROM Sessions WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "session", "deleting sessions")
+	}
+
+	_, err = tx.Exec(`DELETE FROM ApiTokens WHERE id = $1`, id)
+	if err != nil {
+		tx.Rollback()
+		return formatError(err, "api token", "deleting api tokens")
+	}
+
+	err = tx.Commit()
+	return formatError(err, "password reset", "committing database transaction")
+}
+
+// CreateEmailVerification stores the hash of a token that verifies the user
+// owns the given email
+func (h *userHandler) CreateEmailVerification(id, email, tokenHash string, expiry time.Time) error {
+	_, err := h.db.Exec(`
+	INSERT INTO EmailVerifications (tokenHash, id, email, time, expiry)
+	VALUES ($1, $2, $3, $4, $5)`, tokenHash, id, email, time.Now(), expiry)
+	return formatError(err, "email verification", "creating email verification")
+}
+
+// CountEmailVerifications counts the verifications created for the user since
+// the given time
+func (h *userHandler) CountEmailVerifications(id string, since time.Time) (int, error) {
+	var count int
+	err := h.db.QueryRow(`
+	SELECT COUNT(*) FROM EmailVerifications
+	WHERE id = $1 AND time > $2`, id, since).Scan(&count)
+	return count, formatError(err, "email verification", "counting email verifications")
+}
+
+// VerifyEmail uses up the token, marking the user as verified and setting
+// their email to the one that was verified
+func (h *userHandler) VerifyEmail(tokenHash string) (models.User, error) {
+	var u models.User
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return u, formatError(err, "email verification", "starting database transaction")
+	}
+
+	err = tx.QueryRow(`
+	DELETE FROM EmailVerifications
+	WHERE tokenHash = $1 AND expiry > $2
+	RETURNING id, email`, tokenHash, time.Now()).Scan(&u.Id, &u.Email)
+	if err != nil {
+		tx.Rollback()
+		return u, formatError(err, "email verification", "using email verification")
+	}
+
+	// Any other outstanding tokens are expired rather than deleted, so that
+	// resends can still be rate limited
+	_, err = tx.Exec(`
+	UPDATE EmailVerifications SET expiry = $2 WHERE id = $1 AND expiry > $2`, u.Id, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return u, formatError(err, "email verification", "expiring email verifications")
+	}
+
+	err = tx.QueryRow(`
+	UPDATE Users SET email = $2, verified = TRUE WHERE id = $1
+	RETURNING username`, u.Id, u.Email).Scan(&u.Username)
+	if err != nil {
+		tx.Rollback()
+		return u, formatError(err, "user", "verifying email")
+	}
+	u.Verified = true
+
+	err = tx.Commit()
+	return u, formatError(err, "email verification", "committing database transaction")
+}
+
+// UpdateAccount changes the username and creates a verification for a new
+// email in one transaction, so neither is kept if the other fails. The
+// username is left alone if it's empty, and no verification is created if the
+// email is
+func (h *userHandler) UpdateAccount(id, username, email, tokenHash string, expiry time.Time) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return formatError(err, "user", "starting database transaction")
+	}
+
+	if username != "" {
+		s := `UPDATE Users SET username = $2, usernameChanged = $3 WHERE id = $1`
+		if _, err = tx.Exec(s, id, username, time.Now()); err != nil {
+			tx.Rollback()
+			return formatError(err, "username", "updating username")
+		}
+	}
+
+	if email != "" {
+		_, err = tx.Exec(`
+		INSERT INTO EmailVerifications (tokenHash, id, email, time, expiry)
+		VALUES ($1, $2, $3, $4, $5)`, tokenHash, id, email, time.Now(), expiry)
+		if err != nil {
+			tx.Rollback()
+			return formatError(err, "email verification", "creating email verification")
+		}
+	}
+
+	err = tx.Commit()
+	return formatError(err, "user", "committing database transaction")
+}
+
+func (h *userHandler) SetPrivate(id string, private bool) error {
+	s := `UPDATE Users SET private = $2 WHERE id = $1`
+	_, err := h.db.Exec(s, id, private)
+	return formatError(err, "user", "updating privacy")
+}
+
 func (h *userHandler) SearchUsers(search string, limit int) ([]models.User, error) {
 	var users []models.User
 	usersMap := make(map[string]*models.User)
 
 	s := `
-    SELECT Users.id, username, password, email, Sessions.sessionId, Sessions.expiry FROM Users
+    SELECT Users.id, username, password, email, private, Sessions.sessionId, Sessions.expiry FROM Users
 	LEFT JOIN Sessions ON Users.id = Sessions.id
     WHERE similarity(username, $1) > 0.2
     ORDER BY similarity(username, $1) DESC
@@ -212,7 +382,7 @@ func (h *userHandler) SearchUsers(search string, limit int) ([]models.User, erro
 
 		var sessionId sql.NullString
 		var expiry pq.NullTime
-		if err = rows.Scan(&u.Id, &u.Username, &u.Password, &u.Email, &sessionId, &expiry); err != nil {
+		if err = rows.Scan(&u.Id, &u.Username, &u.Password, &u.Email, &u.Private, &sessionId, &expiry); err != nil {
 			break
 		}
 
@@ -0,0 +1,478 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/models"
+)
+
+func TestGetUser(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	err := h.CreateIdentity(models.Identity{Provider: "google", Subject: "alice-sub", Id: alice.Id, Time: time.Now()})
+	if err != nil {
+		t.Fatalf("Could not create identity: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		get  func() (models.User, error)
+		want error
+	}{{
+		name: "username",
+		get:  func() (models.User, error) { return h.GetUserFromUsername("alice") },
+	}, {
+		name: "session",
+		get:  func() (models.User, error) { return h.GetUserFromSession("alice-session") },
+	}, {
+		name: "id",
+		get:  func() (models.User, error) { return h.GetUserFromId("alice-id") },
+	}, {
+		name: "email ignoring case",
+		get:  func() (models.User, error) { return h.GetUserFromEmail("Alice@Example.com") },
+	}, {
+		name: "identity",
+		get:  func() (models.User, error) { return h.GetUserFromIdentity("google", "alice-sub") },
+	}, {
+		name: "unknown username",
+		get:  func() (models.User, error) { return h.GetUserFromUsername("carol") },
+		want: &NotFoundError{},
+	}, {
+		name: "unknown session",
+		get:  func() (models.User, error) { return h.GetUserFromSession("carol-session") },
+		want: &NotFoundError{},
+	}, {
+		name: "unknown id",
+		get:  func() (models.User, error) { return h.GetUserFromId("carol-id") },
+		want: &NotFoundError{},
+	}, {
+		name: "unknown email",
+		get:  func() (models.User, error) { return h.GetUserFromEmail("carol@example.com") },
+		want: &NotFoundError{},
+	}, {
+		name: "unknown identity",
+		get:  func() (models.User, error) { return h.GetUserFromIdentity("google", "carol-sub") },
+		want: &NotFoundError{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u, err := test.get()
+			checkError(t, err, test.want)
+			if test.want != nil {
+				return
+			}
+
+			if u.Id != alice.Id || u.Username != "alice" || u.Email != alice.Email {
+				t.Errorf("Got user %+v, want alice", u)
+			}
+			if u.Role != models.UserRole || u.Verified || u.TwoFactor || u.Suspended {
+				t.Errorf("Got user %+v, want a new user", u)
+			}
+			if len(u.Sessions) != 1 || u.Sessions[0].SessionId != "alice-session" {
+				t.Errorf("Got sessions %+v, want alice-session", u.Sessions)
+			}
+		})
+	}
+}
+
+func TestGetBasicUsers(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+	createTestUser(t, h, "carol")
+
+	us, err := h.GetBasicUsers(nil)
+	if err != nil || len(us) != 0 {
+		t.Errorf("Got %v, %v for no IDs, want no users", us, err)
+	}
+
+	us, err = h.GetBasicUsers([]string{"alice-id", "carol-id", "unknown-id"})
+	if err != nil {
+		t.Fatalf("Could not get users: %v", err)
+	}
+
+	usernames := map[string]bool{}
+	for _, u := range us {
+		usernames[u.Username] = true
+	}
+	if len(us) != 2 || !usernames["alice"] || !usernames["carol"] {
+		t.Errorf("Got users %+v, want alice and carol", us)
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	session := func(sessionId string) models.Session {
+		return models.Session{SessionId: sessionId, Expiry: time.Now().Add(time.Hour)}
+	}
+
+	tests := []struct {
+		name    string
+		user    models.User
+		session models.Session
+		want    error
+	}{{
+		name:    "new user",
+		user:    models.User{Id: "bob-id", Identification: models.Identification{Username: "bob"}, Email: "bob@example.com"},
+		session: session("bob-session"),
+	}, {
+		name:    "duplicate username",
+		user:    models.User{Id: "bob-id", Identification: models.Identification{Username: "alice"}, Email: "bob@example.com"},
+		session: session("bob-session"),
+		want:    &UniquenessViolation{},
+	}, {
+		name:    "duplicate email",
+		user:    models.User{Id: "bob-id", Identification: models.Identification{Username: "bob"}, Email: "alice@example.com"},
+		session: session("bob-session"),
+		want:    &UniquenessViolation{},
+	}, {
+		name:    "duplicate id",
+		user:    models.User{Id: "alice-id", Identification: models.Identification{Username: "bob"}, Email: "bob@example.com"},
+		session: session("bob-session"),
+		want:    &UniquenessViolation{},
+	}, {
+		name:    "duplicate session",
+		user:    models.User{Id: "bob-id", Identification: models.Identification{Username: "bob"}, Email: "bob@example.com"},
+		session: session("alice-session"),
+		want:    &UniquenessViolation{},
+	}, {
+		name:    "username too long",
+		user:    models.User{Id: "bob-id", Identification: models.Identification{Username: strings.Repeat("b", 129)}, Email: "bob@example.com"},
+		session: session("bob-session"),
+		want:    &DataViolation{},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := h.CreateUser(test.user, test.session)
+			checkError(t, err, test.want)
+
+			_, err = h.GetUserFromId("bob-id")
+			if test.want == nil {
+				checkError(t, err, nil)
+				h.db.Exec(`DELETE FROM Users WHERE id = 'bob-id'`)
+			} else {
+				checkError(t, err, &NotFoundError{})
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	expiry := time.Now().Add(time.Hour)
+	for _, sessionId := range []string{"alice-phone", "alice-laptop"} {
+		if err := h.CreateSession(models.Session{Id: "alice-id", SessionId: sessionId, Expiry: expiry}); err != nil {
+			t.Fatalf("Could not create session: %v", err)
+		}
+	}
+
+	err := h.CreateSession(models.Session{Id: "alice-id", SessionId: "alice-phone", Expiry: expiry})
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateSession(models.Session{Id: "carol-id", SessionId: "carol-session", Expiry: expiry})
+	checkError(t, err, &ForeignKeyViolation{})
+
+	if err = h.DeleteSession("alice-phone"); err != nil {
+		t.Fatalf("Could not delete session: %v", err)
+	}
+	_, err = h.GetUserFromSession("alice-phone")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.DeleteOtherSessions("alice-laptop"); err != nil {
+		t.Fatalf("Could not delete other sessions: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil {
+		t.Fatalf("Could not get user: %v", err)
+	}
+	if len(u.Sessions) != 1 || u.Sessions[0].SessionId != "alice-laptop" {
+		t.Errorf("Got sessions %+v, want alice-laptop", u.Sessions)
+	}
+
+	if _, err = h.GetUserFromSession("bob-session"); err != nil {
+		t.Errorf("Other user's session was deleted: %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	if err := h.ChangePassword("alice-id", "new-hash"); err != nil {
+		t.Fatalf("Could not change password: %v", err)
+	}
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil || u.Password != "new-hash" {
+		t.Errorf("Got password %v, %v, want new-hash", u.Password, err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	err := h.CreateApiToken(models.ApiToken{
+		TokenId:   "token-id",
+		Id:        "alice-id",
+		Name:      "token",
+		Scopes:    []string{models.ReadScope},
+		Time:      time.Now(),
+		Expiry:    time.Now().Add(time.Hour),
+		TokenHash: "token-hash",
+	})
+	if err != nil {
+		t.Fatalf("Could not create api token: %v", err)
+	}
+
+	expiry := time.Now().Add(time.Hour)
+	if err = h.CreatePasswordReset("alice-id", "old-reset", expiry); err != nil {
+		t.Fatalf("Could not create password reset: %v", err)
+	}
+	if err = h.CreatePasswordReset("alice-id", "reset", expiry); err != nil {
+		t.Fatalf("Could not create password reset: %v", err)
+	}
+	if err = h.CreatePasswordReset("bob-id", "expired-reset", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Could not create password reset: %v", err)
+	}
+
+	err = h.CreatePasswordReset("bob-id", "reset", expiry)
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreatePasswordReset("carol-id", "carol-reset", expiry)
+	checkError(t, err, &ForeignKeyViolation{})
+
+	err = h.ConfirmPasswordReset("old-reset", "new-hash")
+	checkError(t, err, &NotFoundError{})
+
+	err = h.ConfirmPasswordReset("expired-reset", "new-hash")
+	checkError(t, err, &NotFoundError{})
+
+	if err = h.ConfirmPasswordReset("reset", "new-hash"); err != nil {
+		t.Fatalf("Could not confirm password reset: %v", err)
+	}
+
+	err = h.ConfirmPasswordReset("reset", "newer-hash")
+	checkError(t, err, &NotFoundError{})
+
+	u, err := h.GetUserFromId("alice-id")
+	if err != nil {
+		t.Fatalf("Could not get user: %v", err)
+	}
+	if u.Password != "new-hash" {
+		t.Errorf("Got password %v, want new-hash", u.Password)
+	}
+	if len(u.Sessions) != 0 {
+		t.Errorf("Got sessions %+v, want none", u.Sessions)
+	}
+
+	_, err = h.UseApiToken("token-hash")
+	checkError(t, err, &NotFoundError{})
+}
+
+func TestEmailVerification(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	start := time.Now().Add(-time.Minute)
+	expiry := time.Now().Add(time.Hour)
+	if err := h.CreateEmailVerification("alice-id", "old@example.com", "old-verify", expiry); err != nil {
+		t.Fatalf("Could not create email verification: %v", err)
+	}
+	if err := h.CreateEmailVerification("alice-id", "new@example.com", "verify", expiry); err != nil {
+		t.Fatalf("Could not create email verification: %v", err)
+	}
+	if err := h.CreateEmailVerification("bob-id", "bob@example.com", "expired-verify", time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("Could not create email verification: %v", err)
+	}
+
+	err := h.CreateEmailVerification("bob-id", "bob@example.com", "verify", expiry)
+	checkError(t, err, &UniquenessViolation{})
+
+	err = h.CreateEmailVerification("carol-id", "carol@example.com", "carol-verify", expiry)
+	checkError(t, err, &ForeignKeyViolation{})
+
+	count, err := h.CountEmailVerifications("alice-id", start)
+	if err != nil || count != 2 {
+		t.Errorf("Got %v, %v verifications, want 2", count, err)
+	}
+
+	count, err = h.CountEmailVerifications("alice-id", time.Now().Add(time.Minute))
+	if err != nil || count != 0 {
+		t.Errorf("Got %v, %v verifications in the future, want 0", count, err)
+	}
+
+	_, err = h.VerifyEmail("expired-verify")
+	checkError(t, err, &NotFoundError{})
+
+	u, err := h.VerifyEmail("verify")
+	if err != nil {
+		t.Fatalf("Could not verify email: %v", err)
+	}
+	if u.Id != "alice-id" || u.Username != "alice" || u.Email != "new@example.com" || !u.Verified {
+		t.Errorf("Got user %+v, want alice verified with new@example.com", u)
+	}
+
+	_, err = h.VerifyEmail("verify")
+	checkError(t, err, &NotFoundError{})
+
+	// Verifying one token expires the others but still counts them
+	_, err = h.VerifyEmail("old-verify")
+	checkError(t, err, &NotFoundError{})
+
+	count, err = h.CountEmailVerifications("alice-id", start)
+	if err != nil || count != 1 {
+		t.Errorf("Got %v, %v verifications after verifying, want 1", count, err)
+	}
+
+	// Verifying an email another user already has fails without using the
+	// token
+	if err = h.CreateEmailVerification("bob-id", "new@example.com", "taken-verify", expiry); err != nil {
+		t.Fatalf("Could not create email verification: %v", err)
+	}
+	_, err = h.VerifyEmail("taken-verify")
+	checkError(t, err, &UniquenessViolation{})
+
+	count, err = h.CountEmailVerifications("bob-id", start)
+	if err != nil || count != 2 {
+		t.Errorf("Got %v, %v verifications after failed verify, want 2", count, err)
+	}
+}
+
+func TestUpdateAccount(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "bob")
+
+	start := time.Now().Add(-time.Minute)
+	expiry := time.Now().Add(time.Hour)
+	if err := h.CreateEmailVerification("bob-id", "bob2@example.com", "bob-verify", expiry); err != nil {
+		t.Fatalf("Could not create email verification: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		username  string
+		email     string
+		tokenHash string
+		want      error
+		wantName  string
+		wantCount int
+	}{{
+		name:      "username taken",
+		username:  "bob",
+		email:     "alice2@example.com",
+		tokenHash: "alice-verify",
+		want:      &UniquenessViolation{},
+		wantName:  "alice",
+	}, {
+		name:      "token taken",
+		username:  "alice2",
+		email:     "alice2@example.com",
+		tokenHash: "bob-verify",
+		want:      &UniquenessViolation{},
+		wantName:  "alice",
+	}, {
+		name:      "username too long",
+		username:  strings.Repeat("a", 129),
+		email:     "alice2@example.com",
+		tokenHash: "alice-verify",
+		want:      &DataViolation{},
+		wantName:  "alice",
+	}, {
+		name:     "username only",
+		username: "alice2",
+		wantName: "alice2",
+	}, {
+		name:      "email only",
+		email:     "alice2@example.com",
+		tokenHash: "alice-verify",
+		wantName:  "alice2",
+		wantCount: 1,
+	}, {
+		name:      "both",
+		username:  "alice3",
+		email:     "alice3@example.com",
+		tokenHash: "alice-verify-2",
+		wantName:  "alice3",
+		wantCount: 2,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := h.UpdateAccount("alice-id", test.username, test.email, test.tokenHash, expiry)
+			checkError(t, err, test.want)
+
+			u, err := h.GetUserFromId("alice-id")
+			if err != nil {
+				t.Fatalf("Could not get user: %v", err)
+			}
+			if u.Username != test.wantName {
+				t.Errorf("Got username %v, want %v", u.Username, test.wantName)
+			}
+			if test.username != "" && test.want == nil && u.UsernameChanged.IsZero() {
+				t.Errorf("Username change time wasn't set")
+			}
+
+			count, err := h.CountEmailVerifications("alice-id", start)
+			if err != nil || count != test.wantCount {
+				t.Errorf("Got %v, %v verifications, want %v", count, err, test.wantCount)
+			}
+		})
+	}
+}
+
+func TestSetPrivate(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+
+	for _, private := range []bool{true, false} {
+		if err := h.SetPrivate("alice-id", private); err != nil {
+			t.Fatalf("Could not set private: %v", err)
+		}
+
+		u, err := h.GetUserFromId("alice-id")
+		if err != nil || u.Private != private {
+			t.Errorf("Got private %v, %v, want %v", u.Private, err, private)
+		}
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	h := newTestHandler(t)
+	createTestUser(t, h, "alice")
+	createTestUser(t, h, "alicia")
+	createTestUser(t, h, "bob")
+
+	us, err := h.SearchUsers("alice", 10)
+	if err != nil {
+		t.Fatalf("Could not search users: %v", err)
+	}
+
+	usernames := map[string]bool{}
+	for _, u := range us {
+		usernames[u.Username] = true
+	}
+	if !usernames["alice"] || usernames["bob"] {
+		t.Errorf("Got users %+v, want alice and not bob", us)
+	}
+
+	us, err = h.SearchUsers("zzzzzz", 10)
+	if err != nil || len(us) != 0 {
+		t.Errorf("Got %+v, %v, want no users", us, err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package mail sends email to users, with implementations for delivering
+// over SMTP and for writing messages locally during development
+package mail
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	SMTPDriver = "smtp"
+	FileDriver = "file"
+	LogDriver  = "log"
+
+	defaultFrom = "Transient <noreply@transient.local>"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Message) error
+}
+
+// NewMailer creates the mailer selected by the MAIL_DRIVER environment
+// variable, defaulting to logging messages
+func NewMailer() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = defaultFrom
+	}
+
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case SMTPDriver:
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("MAIL_SMTP_HOST"),
+			Port:     os.Getenv("MAIL_SMTP_PORT"),
+			Username: os.Getenv("MAIL_SMTP_USERNAME"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+			From:     from,
+		})
+	case FileDriver:
+		return NewFileMailer(os.Getenv("MAIL_FILE"), from)
+	case LogDriver, "":
+		return NewLogMailer(from), nil
+	default:
+		return nil, fmt.Errorf("Unknown mail driver %v", driver)
+	}
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+type logMailer struct {
+	from string
+}
+
+// NewLogMailer creates a mailer that writes messages to the log instead of
+// sending them
+func NewLogMailer(from string) Mailer {
+	return &logMailer{from: from}
+}
+
+func (m *logMailer) Send(msg Message) error {
+	log.Printf("Mail to %v:\n%s\n", msg.To, format(m.from, msg))
+	return nil
+}
+
+type fileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+// NewFileMailer creates a mailer that appends messages to the file at path
+func NewFileMailer(path, from string) (Mailer, error) {
+	if path == "" {
+		return nil, errors.New("Mail file must be set")
+	}
+
+	return &fileMailer{path: path, from: from}, nil
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(format(m.from, msg), "\r\n\r\n"...))
+	return err
+}
@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type smtpMailer struct {
+	config SMTPConfig
+	auth   smtp.Auth
+}
+
+func NewSMTPMailer(config SMTPConfig) (Mailer, error) {
+	if config.Host == "" {
+		return nil, errors.New("SMTP host must be set")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	m := &smtpMailer{config: config}
+	if config.Username != "" {
+		m.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+
+	return m, nil
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	// Header injection would let the message be sent to anyone
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("Invalid message header")
+	}
+
+	from := m.config.From
+	if address, err := parseAddress(from); err == nil {
+		from = address
+	}
+
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	return smtp.SendMail(addr, m.auth, from, []string{msg.To}, format(m.config.From, msg))
+}
+
+func parseAddress(from string) (string, error) {
+	start := strings.LastIndex(from, "<")
+	end := strings.LastIndex(from, ">")
+	if start == -1 {
+		return from, nil
+	}
+	if end < start {
+		return "", fmt.Errorf("Invalid address %v", from)
+	}
+
+	return from[start+1 : end], nil
+}
+
+// format renders the message with the headers needed to send it
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
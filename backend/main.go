@@ -3,11 +3,16 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gorilla/mux"
 
 	"github.com/jbrunsting/transient/backend/api"
 	"github.com/jbrunsting/transient/backend/database"
+	"github.com/jbrunsting/transient/backend/mail"
+	"github.com/jbrunsting/transient/backend/oidc"
+	"github.com/jbrunsting/transient/backend/oidc/devprovider"
+	"github.com/jbrunsting/transient/common/apierror"
 )
 
 type response struct {
@@ -26,20 +31,72 @@ func main() {
 	}
 	defer databaseHandler.Close()
 
-	a := api.NewApi(databaseHandler)
+	mailer, err := mail.NewMailer()
+	if err != nil {
+		panic(err)
+	}
+
+	oidcConfig, err := oidc.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+
+	var idp *oidc.Provider
+	if oidcConfig != nil {
+		// The development provider is served by the backend itself, the
+		// issuer should point at /oidc-dev through the public URL
+		if os.Getenv("OIDC_DEV_PROVIDER") == "true" {
+			dev, err := devprovider.New(*oidcConfig)
+			if err != nil {
+				panic(err)
+			}
+			oidcConfig.Client = dev.Client()
+			r.PathPrefix("/oidc-dev/").Handler(http.StripPrefix("/oidc-dev", dev))
+			log.Printf("Serving the development identity provider at %v\n", oidcConfig.Issuer)
+		}
+		idp = oidc.NewProvider(*oidcConfig)
+	}
+
+	a := api.NewApi(databaseHandler, mailer, idp)
 
 	r.HandleFunc("/user", a.SelfGet).Methods("GET")
+	r.HandleFunc("/user/verify", a.UserVerifyGet).Methods("GET")
+	r.HandleFunc("/user/identities", a.UserIdentitiesGet).Methods("GET")
+	r.HandleFunc("/user/tokens", a.UserTokensGet).Methods("GET")
+	r.HandleFunc("/user/export/{id}", a.UserExportGet).Methods("GET")
 	r.HandleFunc("/user/{id}", a.UserGet).Methods("GET")
+	r.HandleFunc("/user/{id}/followers", a.UserFollowersGet).Methods("GET")
+	r.HandleFunc("/user/{id}/followers/known", a.UserKnownFollowersGet).Methods("GET")
+	r.HandleFunc("/user/{id}/followings", a.UserFollowingsGet).Methods("GET")
 	r.HandleFunc("/user", a.UserPost).Methods("POST")
+	r.HandleFunc("/user", a.UserPatch).Methods("PATCH")
+	r.HandleFunc("/user/profile", a.UserProfilePatch).Methods("PATCH")
 	r.HandleFunc("/user/login", a.UserLoginPost).Methods("POST")
+	r.HandleFunc("/user/login/2fa", a.UserLoginTwoFactorPost).Methods("POST")
 	r.HandleFunc("/user/logout", a.UserLogoutPost).Methods("POST")
 	r.HandleFunc("/user/invalidate", a.UserInvalidatePost).Methods("POST")
 	r.HandleFunc("/user/delete", a.UserDeletePost).Methods("POST")
 	r.HandleFunc("/user/password", a.UserPasswordPost).Methods("POST")
+	r.HandleFunc("/user/password/reset", a.UserPasswordResetPost).Methods("POST")
+	r.HandleFunc("/user/password/reset/confirm", a.UserPasswordResetConfirmPost).Methods("POST")
+	r.HandleFunc("/user/privacy", a.UserPrivacyPost).Methods("POST")
+	r.HandleFunc("/user/2fa/enroll", a.UserTwoFactorEnrollPost).Methods("POST")
+	r.HandleFunc("/user/2fa/confirm", a.UserTwoFactorConfirmPost).Methods("POST")
+	r.HandleFunc("/user/2fa/disable", a.UserTwoFactorDisablePost).Methods("POST")
+	r.HandleFunc("/user/identities/{provider}/{subject}", a.UserIdentityDelete).Methods("DELETE")
+	r.HandleFunc("/user/tokens", a.UserTokensPost).Methods("POST")
+	r.HandleFunc("/user/export", a.UserExportPost).Methods("POST")
+	r.HandleFunc("/user/tokens/{id}", a.UserTokenDelete).Methods("DELETE")
+	r.HandleFunc("/oidc/login", a.OidcLoginGet).Methods("GET")
+	r.HandleFunc("/oidc/link", a.OidcLinkGet).Methods("GET")
+	r.HandleFunc("/oidc/reauth", a.OidcReauthGet).Methods("GET")
+	r.HandleFunc("/oidc/callback", a.OidcCallbackGet).Methods("GET")
+	r.HandleFunc("/user/verify/resend", a.UserVerifyResendPost).Methods("POST")
 	r.HandleFunc("/users/search", a.UsersSearchGet).Methods("GET")
     r.HandleFunc("/users/exact/{username}", a.UsersExactGet).Methods("GET")
 	r.HandleFunc("/authenticated", a.UserAuthenticatedGet).Methods("GET")
 
+	r.HandleFunc("/posts/search", a.PostsSearchGet).Methods("GET")
 	r.HandleFunc("/posts/{id}", a.PostsGet).Methods("GET")
 	r.HandleFunc("/post", a.PostPost).Methods("POST")
 	r.HandleFunc("/post/{id}", a.PostDelete).Methods("DELETE")
@@ -49,12 +106,52 @@ func main() {
 
 	r.HandleFunc("/followings", a.FollowingsGet).Methods("GET")
 	r.HandleFunc("/followings/posts", a.FollowingsPostsGet).Methods("GET")
+	r.HandleFunc("/followings/export", a.FollowingsExportGet).Methods("GET")
+	r.HandleFunc("/followings/import", a.FollowingsImportPost).Methods("POST")
+	r.HandleFunc("/following/requests", a.FollowRequestsGet).Methods("GET")
+	r.HandleFunc("/following/requests/{id}/accept", a.FollowRequestAcceptPost).Methods("POST")
+	r.HandleFunc("/following/requests/{id}/reject", a.FollowRequestRejectPost).Methods("POST")
 	r.HandleFunc("/following/{id}", a.FollowingPost).Methods("POST")
 	r.HandleFunc("/following/{id}", a.FollowingDelete).Methods("DELETE")
 
+	r.HandleFunc("/block/{id}", a.BlockPost).Methods("POST")
+	r.HandleFunc("/block/{id}", a.BlockDelete).Methods("DELETE")
+	r.HandleFunc("/mute/{id}", a.MutePost).Methods("POST")
+	r.HandleFunc("/mute/{id}", a.MuteDelete).Methods("DELETE")
+
+	r.HandleFunc("/report", a.ReportPost).Methods("POST")
+	r.HandleFunc("/moderation/reports", a.ModerationReportsGet).Methods("GET")
+	r.HandleFunc("/moderation/reports/{id}/dismiss", a.ModerationReportDismissPost).Methods("POST")
+	r.HandleFunc("/moderation/hide", a.ModerationHidePost).Methods("POST")
+	r.HandleFunc("/moderation/suspend/{id}", a.ModerationSuspendPost).Methods("POST")
+	r.HandleFunc("/moderation/unsuspend/{id}", a.ModerationUnsuspendPost).Methods("POST")
+	r.HandleFunc("/moderation/actions", a.ModerationActionsGet).Methods("GET")
+
+	r.HandleFunc("/admin/users", a.AdminUsersGet).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/role", a.AdminUserRolePost).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/password/reset", a.AdminUserPasswordResetPost).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/posts", a.AdminUserPostsDelete).Methods("DELETE")
+	r.HandleFunc("/admin/recommends/rebuild", a.AdminRecommendsRebuildPost).Methods("POST")
+	r.HandleFunc("/admin/stats", a.AdminStatsGet).Methods("GET")
+
 	r.HandleFunc("/recommends/posts", a.RecommendsPostsGet).Methods("GET")
 	r.HandleFunc("/recommends/followings", a.RecommendsFollowingsGet).Methods("GET")
 
+	r.HandleFunc("/tags/trending", a.TagsTrendingGet).Methods("GET")
+	r.HandleFunc("/tags/{tag}/posts", a.TagPostsGet).Methods("GET")
+
+	r.HandleFunc("/notifications", a.NotificationsGet).Methods("GET")
+	r.HandleFunc("/notifications/unread", a.NotificationsUnreadGet).Methods("GET")
+	r.HandleFunc("/notifications/read", a.NotificationsReadPost).Methods("POST")
+
+	r.HandleFunc("/stream", a.StreamGet).Methods("GET")
+	r.HandleFunc("/stream/watch", a.StreamWatchPost).Methods("POST")
+	r.HandleFunc("/live", a.LiveGet).Methods("GET")
+
 	log.Println("Listening on port 3000")
-	http.ListenAndServe(":3000", r)
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	})
+
+	http.ListenAndServe(":3000", apierror.RequestId(r))
 }
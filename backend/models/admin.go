@@ -0,0 +1,40 @@
+package models
+
+type UserStats struct {
+	Id         string `json:"id"`
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Role       string `json:"role"`
+	Private    bool   `json:"private"`
+	Suspended  bool   `json:"suspended"`
+	Posts      int    `json:"posts"`
+	Followers  int    `json:"followers"`
+	Followings int    `json:"followings"`
+	Reports    int    `json:"reports"`
+	Sessions   int    `json:"sessions"`
+}
+
+type RoleChange struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type TemporaryPassword struct {
+	Password string `json:"password"`
+}
+
+type GraphStats struct {
+	Nodes int `json:"nodes"`
+	Edges int `json:"edges"`
+	Users int `json:"users"`
+	Posts int `json:"posts"`
+	Tags  int `json:"tags"`
+}
+
+type SystemStats struct {
+	Users       int         `json:"users"`
+	LivePosts   int         `json:"livePosts"`
+	Sessions    int         `json:"sessions"`
+	OpenReports int         `json:"openReports"`
+	Graph       *GraphStats `json:"graph"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+type UserExport struct {
+	ExportId string    `json:"exportId"`
+	Id       string    `json:"-"`
+	Status   string    `json:"status"`
+	Time     time.Time `json:"time"`
+	Expiry   time.Time `json:"expiry"`
+	Data     []byte    `json:"-"`
+}
+
+// ExportSession leaves out the session ID, so that the archive can't be used
+// to log in as the user
+type ExportSession struct {
+	Expiry time.Time `json:"expiry"`
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+	FollowRejected = "rejected"
+)
+
+type FollowRequest struct {
+	Id          string    `json:"id"`
+	Username    string    `json:"username"`
+	FollowingId string    `json:"followingId"`
+	Status      string    `json:"status"`
+	Time        time.Time `json:"time"`
+}
+
+type FollowStatus struct {
+	Status string `json:"status"`
+}
+
+// FollowEntry is a user in someone's followers or followings, Mutual is set
+// when they follow each other
+type FollowEntry struct {
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
+	AvatarUrl   string `json:"avatarUrl"`
+	Mutual      bool   `json:"mutual"`
+}
+
+type FollowList struct {
+	Users      []FollowEntry `json:"users"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// KnownFollowers are the followers of a user that the viewer follows, Total
+// counts all of them even if only some are listed
+type KnownFollowers struct {
+	Users []FollowEntry `json:"users"`
+	Total int           `json:"total"`
+}
+
+const (
+	ImportFollowed         = "followed"
+	ImportRequested        = "requested"
+	ImportAlreadyFollowing = "already following"
+	ImportNotFound         = "not found"
+	ImportBlocked          = "blocked"
+	ImportSelf             = "self"
+)
+
+type FollowImport struct {
+	Usernames []string `json:"usernames"`
+}
+
+type FollowImportResult struct {
+	Username string `json:"username"`
+	Status   string `json:"status"`
+}
+
+// ImportTarget is a user named in an import, along with whether the importer
+// already follows them and whether either has blocked the other
+type ImportTarget struct {
+	Id        string
+	Username  string
+	Private   bool
+	Following bool
+	Blocked   bool
+}
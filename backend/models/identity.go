@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// Identity is an account with an external identity provider that can be used
+// to log in as the user it's linked to
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Id       string    `json:"-"`
+	Email    string    `json:"email"`
+	Time     time.Time `json:"time"`
+}
+
+// OidcLogin is the state kept between sending the user to the identity
+// provider and them being redirected back. LinkId is set when a logged in
+// user is linking a new identity rather than logging in, or when they are
+// confirming who they are if Reauth is also set
+type OidcLogin struct {
+	StateHash string
+	Verifier  string
+	Nonce     string
+	LinkId    string
+	Reauth    bool
+	Expiry    time.Time
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	PostTarget    = "post"
+	CommentTarget = "comment"
+	UserTarget    = "user"
+
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+
+	HideAction      = "hide"
+	SuspendAction   = "suspend"
+	UnsuspendAction = "unsuspend"
+	DismissAction   = "dismiss"
+
+	RoleAction          = "role"
+	PasswordResetAction = "reset_password"
+	DeletePostsAction   = "delete_posts"
+)
+
+// ReportReasons are the reason codes that can be given for a report
+var ReportReasons = []string{
+	"spam",
+	"harassment",
+	"hate",
+	"violence",
+	"sexual",
+	"misinformation",
+	"other",
+}
+
+type Report struct {
+	ReportId   string    `json:"reportId"`
+	Id         string    `json:"id"`
+	Username   string    `json:"username,omitempty"`
+	TargetType string    `json:"targetType"`
+	TargetId   string    `json:"targetId"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details,omitempty"`
+	Status     string    `json:"status"`
+	Time       time.Time `json:"time"`
+}
+
+type ModerationAction struct {
+	ActionId    string    `json:"actionId"`
+	ModeratorId string    `json:"moderatorId"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"targetType"`
+	TargetId    string    `json:"targetId"`
+	ReportId    string    `json:"reportId,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Time        time.Time `json:"time"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	MentionNotification        = "mention"
+	FollowNotification         = "follow"
+	FollowRequestNotification  = "follow_request"
+	FollowAcceptedNotification = "follow_accepted"
+	CommentNotification        = "comment"
+	UpvoteNotification         = "upvote"
+)
+
+type Notification struct {
+	NotificationId string    `json:"notificationId"`
+	Id             string    `json:"id"`
+	ActorId        string    `json:"actorId"`
+	ActorUsername  string    `json:"actorUsername"`
+	Kind           string    `json:"kind"`
+	PostId         string    `json:"postId,omitempty"`
+	CommentId      string    `json:"commentId,omitempty"`
+	Time           time.Time `json:"time"`
+	Read           bool      `json:"read"`
+}
+
+type UnreadCount struct {
+	Count int `json:"count"`
+}
+
+type NotificationsRead struct {
+	NotificationIds []string `json:"notificationIds"`
+}
@@ -4,15 +4,53 @@ import (
 	"time"
 )
 
+const (
+	PlainFormat    = "plain"
+	MarkdownFormat = "markdown"
+
+	// Posts are only visible for PostLifetime after they are created
+	PostLifetime = 30 * 24 * time.Hour
+)
+
+type LinkPreview struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	ImageUrl    string `json:"imageUrl"`
+}
+
 type Post struct {
-	Id       string    `json:"id"`
-	Username string    `json:"username"`
-	PostId   string    `json:"postId"`
-	Time     time.Time `json:"time"`
-	Title    string    `json:"title"`
-	Content  string    `json:"content"`
-	PostUrl  string    `json:"postUrl"`
-	ImageUrl string    `json:"imageUrl"`
+	Id       string       `json:"id"`
+	Username string       `json:"username"`
+	PostId   string       `json:"postId"`
+	Time     time.Time    `json:"time"`
+	Title    string       `json:"title"`
+	Content  string       `json:"content"`
+	PostUrl  string       `json:"postUrl"`
+	ImageUrl string       `json:"imageUrl"`
+	Preview  *LinkPreview `json:"preview,omitempty"`
+	Tags     []string     `json:"tags"`
+
+	// Format is one of PlainFormat or MarkdownFormat, RenderedContent is the
+	// sanitised html form of Content, the handlers clear it when decoding
+	// requests so it is only ever set by renderContent
+	Format          string `json:"format"`
+	RenderedContent string `json:"renderedContent,omitempty"`
+}
+
+type SearchResult struct {
+	Post
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"-"`
+}
+
+type SearchResults struct {
+	Posts      []SearchResult `json:"posts"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+type TrendingTag struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 type Comment struct {
@@ -21,4 +59,7 @@ type Comment struct {
 	CommentId string    `json:"commentId"`
 	Time      time.Time `json:"time"`
 	Content   string    `json:"content"`
+
+	Format          string `json:"format"`
+	RenderedContent string `json:"renderedContent,omitempty"`
 }
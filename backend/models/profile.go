@@ -0,0 +1,27 @@
+package models
+
+// Profile is what anyone can see about a user
+type Profile struct {
+	Id          string `json:"id"`
+	Username    string `json:"username"`
+	Private     bool   `json:"private"`
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	AvatarUrl   string `json:"avatarUrl"`
+	Link        string `json:"link"`
+	Followers   int    `json:"followers"`
+	Followings  int    `json:"followings"`
+	// Only counts posts that haven't expired
+	Posts int `json:"posts"`
+	// Whether the user viewing the profile follows them
+	IsFollowing bool `json:"isFollowing"`
+}
+
+// ProfileUpdate changes the fields that are set, an empty string clears the
+// field
+type ProfileUpdate struct {
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	AvatarUrl   *string `json:"avatarUrl"`
+	Link        *string `json:"link"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+)
+
+// API tokens are limited to the actions in their scopes, anything that
+// changes the account itself needs a session
+const (
+	ReadScope   = "read"
+	PostScope   = "post"
+	VoteScope   = "vote"
+	FollowScope = "follow"
+)
+
+var ApiTokenScopes = []string{ReadScope, PostScope, VoteScope, FollowScope}
+
+type ApiToken struct {
+	TokenId  string     `json:"tokenId"`
+	Id       string     `json:"-"`
+	Name     string     `json:"name"`
+	Scopes   []string   `json:"scopes"`
+	Time     time.Time  `json:"time"`
+	Expiry   time.Time  `json:"expiry"`
+	LastUsed *time.Time `json:"lastUsed"`
+	// Only set when the token is created, only the hash is stored
+	Token     string `json:"token,omitempty"`
+	TokenHash string `json:"-"`
+}
+
+func (t ApiToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ApiTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Defaults to 30 days
+	ExpiryDays int `json:"expiryDays"`
+}
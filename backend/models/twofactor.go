@@ -0,0 +1,30 @@
+package models
+
+// TotpEnrollment is what an authenticator app needs to start generating codes,
+// the URI is usually shown as a QR code
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// TwoFactorCode is either a code from an authenticator app or a recovery code
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are only ever shown once, when two factor authentication is
+// enabled
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// Confirmation is required for sensitive changes to an account, the code is
+// only checked for users with two factor authentication enabled
+type Confirmation struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type LoginChallenge struct {
+	TwoFactorRequired bool `json:"twoFactorRequired"`
+}
@@ -4,6 +4,12 @@ import (
 	"time"
 )
 
+const (
+	UserRole      = "user"
+	ModeratorRole = "moderator"
+	AdminRole     = "admin"
+)
+
 type Identification struct {
 	Username string `json:"username"`
 	Password string `json:"password,omitempty"`
@@ -18,8 +24,44 @@ type Session struct {
 type User struct {
 	Id string `json:"id"`
 	Identification
-	Email    string    `json:"email"`
-	Sessions []Session `json:"sessions,omitempty"`
+	Email     string    `json:"email"`
+	Private   bool      `json:"private"`
+	Role      string    `json:"role,omitempty"`
+	Verified  bool      `json:"verified"`
+	TwoFactor bool      `json:"twoFactor"`
+	Suspended bool      `json:"-"`
+	Sessions  []Session `json:"sessions,omitempty"`
+
+	// Set when the user has asked to change their email but hasn't verified
+	// the new one yet
+	PendingEmail string `json:"pendingEmail,omitempty"`
+	// The zero time if the username has never been changed
+	UsernameChanged time.Time `json:"-"`
+	// Set once the user has started enrolling in two factor authentication
+	TotpSecret string `json:"-"`
+	// The zero time unless the user has asked for their account to be deleted
+	DeletionScheduled time.Time `json:"-"`
+}
+
+// UserUpdate changes the fields that are set, the password is required to
+// confirm the change
+type UserUpdate struct {
+	Password string `json:"password"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type Privacy struct {
+	Private bool `json:"private"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type PasswordReset struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
 }
 
 type PasswordChange struct {
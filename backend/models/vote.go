@@ -9,6 +9,11 @@ const (
     DOWNVOTE = -1
 )
 
+type VoteCounts struct {
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+}
+
 type Vote struct {
 	Id     string    `json:"id"`
 	PostId string    `json:"postId"`
@@ -0,0 +1,319 @@
+// Package devprovider is a stand-in OpenID provider for development. It lets
+// anyone log in as any username without a password, so it must never be
+// enabled in production
+package devprovider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbrunsting/transient/backend/oidc"
+)
+
+const (
+	keyId         = "dev"
+	keySize       = 2048
+	codeExpiry    = time.Minute
+	idTokenExpiry = 5 * time.Minute
+	emailDomain   = "example.com"
+)
+
+var usernameRegexp = regexp.MustCompile(`^\w{1,32}$`)
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Development login</title></head>
+<body>
+<p>This is a development identity provider, pick any username to log in as.</p>
+<form method="POST">
+{{range $name, $value := .}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<input name="username" placeholder="username" autofocus>
+<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+type grant struct {
+	claims        oidc.Claims
+	redirectUri   string
+	codeChallenge string
+	expiry        time.Time
+}
+
+// Provider serves the provider endpoints, and can also be used as the
+// transport for a relying party in the same process
+type Provider struct {
+	config oidc.Config
+	key    *rsa.PrivateKey
+	mux    *http.ServeMux
+	// The path the provider is served under, relative to the issuer's host
+	issuerPath string
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+// New creates a provider that issues tokens to the client in config, its
+// endpoints are relative to config.Issuer
+func New(config oidc.Config) (*Provider, error) {
+	issuer, err := url.Parse(config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		config:     config,
+		key:        key,
+		mux:        http.NewServeMux(),
+		issuerPath: strings.TrimSuffix(issuer.Path, "/"),
+		grants:     map[string]grant{},
+	}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+	p.mux.HandleFunc("/jwks", p.jwks)
+
+	return p, nil
+}
+
+// ServeHTTP expects the issuer's path to have been stripped from the request
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// RoundTrip serves requests to the issuer without going over the network, so
+// the backend doesn't need to reach itself through its public URL
+func (p *Provider) RoundTrip(r *http.Request) (*http.Response, error) {
+	u := *r.URL
+	u.Path = strings.TrimPrefix(u.Path, p.issuerPath)
+
+	served := r.WithContext(r.Context())
+	served.URL = &u
+	served.RequestURI = u.RequestURI()
+	if served.Body == nil {
+		served.Body = http.NoBody
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, served)
+	return rec.Result(), nil
+}
+
+// Client makes requests to the provider in process
+func (p *Provider) Client() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.config.Issuer,
+		"authorization_endpoint":                p.config.Issuer + "/authorize",
+		"token_endpoint":                        p.config.Issuer + "/token",
+		"jwks_uri":                              p.config.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(oidc.KeySet{Keys: []oidc.Jwk{oidc.NewJwk(keyId, &p.key.PublicKey)}})
+}
+
+func (p *Provider) checkAuthorizeRequest(params url.Values) error {
+	switch {
+	case params.Get("response_type") != "code":
+		return errors.New("response_type must be code")
+	case params.Get("client_id") != p.config.ClientId:
+		return errors.New("Unknown client_id")
+	case params.Get("redirect_uri") != p.config.RedirectUrl:
+		return errors.New("redirect_uri isn't registered")
+	case params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "":
+		return errors.New("A S256 code_challenge is required")
+	}
+
+	return nil
+}
+
+// authorize shows a form to pick a username on GET, and redirects back to the
+// client with a code once the form is submitted
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := p.checkAuthorizeRequest(r.Form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != "POST" {
+		hidden := map[string]string{}
+		for name := range r.Form {
+			if name != "username" {
+				hidden[name] = r.Form.Get(name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		authorizeTemplate.Execute(w, hidden)
+		return
+	}
+
+	username := r.Form.Get("username")
+	if !usernameRegexp.MatchString(username) {
+		http.Error(w, "Username must be up to 32 letters, numbers, or underscores", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, "Error generating code", http.StatusInternalServerError)
+		return
+	}
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		claims: oidc.Claims{
+			Issuer:            p.config.Issuer,
+			Subject:           "dev-" + strings.ToLower(username),
+			Audience:          oidc.Audience{p.config.ClientId},
+			Nonce:             r.Form.Get("nonce"),
+			Email:             strings.ToLower(username) + "@" + emailDomain,
+			EmailVerified:     true,
+			PreferredUsername: username,
+			Name:              username,
+		},
+		redirectUri:   r.Form.Get("redirect_uri"),
+		codeChallenge: r.Form.Get("code_challenge"),
+		expiry:        time.Now().Add(codeExpiry),
+	}
+	p.mu.Unlock()
+
+	redirect, _ := url.Parse(r.Form.Get("redirect_uri"))
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func (p *Provider) checkClient(r *http.Request) bool {
+	clientId, secret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	return clientId == p.config.ClientId &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(p.config.ClientSecret)) == 1
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	if !p.checkClient(r) {
+		tokenError(w, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "Only authorization_code is supported")
+		return
+	}
+
+	// Codes can only be used once, whether or not the exchange succeeds
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(g.expiry):
+		tokenError(w, "invalid_grant", "Code is invalid or has expired")
+		return
+	case r.PostForm.Get("redirect_uri") != g.redirectUri:
+		tokenError(w, "invalid_grant", "redirect_uri doesn't match")
+		return
+	case oidc.Challenge(r.PostForm.Get("code_verifier")) != g.codeChallenge:
+		tokenError(w, "invalid_grant", "code_verifier doesn't match")
+		return
+	}
+
+	now := time.Now()
+	g.claims.IssuedAt = now.Unix()
+	g.claims.Expiry = now.Add(idTokenExpiry).Unix()
+	idToken, err := oidc.Sign(g.claims, keyId, p.key)
+	if err != nil {
+		tokenError(w, "server_error", "Error signing token")
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		tokenError(w, "server_error", "Error generating token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenExpiry.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b), err
+}
@@ -0,0 +1,235 @@
+// Package oidc is a minimal OpenID Connect relying party. It supports the
+// authorization code flow with PKCE, and ID tokens signed with RS256
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultName    = "oidc"
+	requestTimeout = 10 * time.Second
+	verifierLength = 32
+)
+
+var defaultScopes = []string{"openid", "email", "profile"}
+
+type Config struct {
+	// Name identifies the provider in the identities linked to users
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	// Client makes the requests to the provider, it can be replaced so that a
+	// provider running in the same process is reached without the network
+	Client *http.Client
+}
+
+// ConfigFromEnv reads the provider from the OIDC_* environment variables, it
+// returns nil if OIDC_ISSUER isn't set
+func ConfigFromEnv() (*Config, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	c := &Config{
+		Name:         os.Getenv("OIDC_PROVIDER"),
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientId:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectUrl:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       defaultScopes,
+	}
+	if c.Name == "" {
+		c.Name = defaultName
+	}
+
+	if c.ClientId == "" || c.RedirectUrl == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set along with OIDC_ISSUER")
+	}
+
+	return c, nil
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// Provider talks to a single OpenID provider, its metadata and keys are
+// fetched the first time they are needed
+type Provider struct {
+	config Config
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        *KeySet
+	keysFetched time.Time
+}
+
+func NewProvider(c Config) *Provider {
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: requestTimeout}
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = defaultScopes
+	}
+
+	return &Provider{config: c}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// NewVerifier returns a random PKCE code verifier along with the S256 challenge
+// for it
+func NewVerifier() (string, string, error) {
+	b := make([]byte, verifierLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	return verifier, Challenge(verifier), nil
+}
+
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (p *Provider) getMetadata() (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(p.config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("Error discovering provider: %v", err)
+	}
+
+	if m.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("Provider issuer %v doesn't match %v", m.Issuer, p.config.Issuer)
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JwksUri == "" {
+		return nil, errors.New("Provider metadata is missing endpoints")
+	}
+
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+func (p *Provider) getJSON(u string, v interface{}) error {
+	resp, err := p.config.Client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, v)
+}
+
+func decodeResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Got status %v: %s", resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// AuthCodeUrl is where to send the user to log in with the provider
+func (p *Provider) AuthCodeUrl(state, nonce, challenge string) (string, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientId)
+	params.Set("redirect_uri", p.config.RedirectUrl)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", challenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IdToken     string `json:"id_token"`
+}
+
+// Exchange trades the code the provider redirected back with for the user's
+// ID token, and returns the verified claims from it
+func (p *Provider) Exchange(code, verifier, nonce string) (*Claims, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectUrl)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientId)
+
+	req, err := http.NewRequest("POST", m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err = decodeResponse(resp, &token); err != nil {
+		return nil, fmt.Errorf("Error exchanging code: %v", err)
+	}
+
+	if token.IdToken == "" {
+		return nil, errors.New("Provider didn't return an ID token")
+	}
+
+	return p.Verify(token.IdToken, nonce)
+}
@@ -0,0 +1,263 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	// Keys are refetched when a token is signed with one we don't know, but
+	// not more often than this so bad tokens can't hammer the provider
+	minKeyRefresh = time.Minute
+	clockSkew     = time.Minute
+)
+
+// Audience is a single string or an array of strings in a token
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = Audience(ss)
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a Audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Name              string   `json:"name,omitempty"`
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+// Jwk is a public key in a JSON Web Key Set, only RSA keys are supported
+type Jwk struct {
+	KeyType   string `json:"kty"`
+	KeyId     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type KeySet struct {
+	Keys []Jwk `json:"keys"`
+}
+
+func NewJwk(keyId string, key *rsa.PublicKey) Jwk {
+	return Jwk{
+		KeyType:   "RSA",
+		KeyId:     keyId,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k Jwk) publicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != "RSA" {
+		return nil, fmt.Errorf("Unsupported key type %v", k.KeyType)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("RSA exponent is too large")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// Sign creates an RS256 token with the claims, it is the inverse of Verify and
+// is used by providers rather than relying parties
+func Sign(claims Claims, keyId string, key *rsa.PrivateKey) (string, error) {
+	h, err := json.Marshal(struct {
+		Algorithm string `json:"alg"`
+		Type      string `json:"typ"`
+		KeyId     string `json:"kid"`
+	}{"RS256", "JWT", keyId})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks the ID token was signed by the provider for this client, and
+// that it belongs to the login that used the nonce
+func (p *Provider) Verify(token, nonce string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	if h.Algorithm != "RS256" {
+		return nil, fmt.Errorf("Unsupported ID token algorithm %v", h.Algorithm)
+	}
+
+	key, err := p.getKey(h.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, errors.New("ID token signature is invalid")
+	}
+
+	var c Claims
+	if err = decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case c.Issuer != p.config.Issuer:
+		return nil, fmt.Errorf("ID token issuer %v doesn't match", c.Issuer)
+	case !c.Audience.contains(p.config.ClientId):
+		return nil, errors.New("ID token wasn't issued for this client")
+	case now.Add(-clockSkew).After(time.Unix(c.Expiry, 0)):
+		return nil, errors.New("ID token has expired")
+	case c.Nonce != nonce:
+		return nil, errors.New("ID token nonce doesn't match")
+	case c.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return &c, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("Malformed ID token: %v", err)
+	}
+
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("Malformed ID token: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Provider) getKey(keyId string) (*rsa.PublicKey, error) {
+	m, err := p.getMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(keyId); key != nil {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetched) < minKeyRefresh {
+		return nil, fmt.Errorf("Unknown signing key %v", keyId)
+	}
+
+	var keys KeySet
+	if err = p.getJSON(m.JwksUri, &keys); err != nil {
+		return nil, fmt.Errorf("Error fetching signing keys: %v", err)
+	}
+	p.keys = &keys
+	p.keysFetched = time.Now()
+
+	if key := p.findKey(keyId); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key %v", keyId)
+}
+
+// findKey must be called with mu held
+func (p *Provider) findKey(keyId string) *rsa.PublicKey {
+	if p.keys == nil {
+		return nil
+	}
+
+	for _, k := range p.keys.Keys {
+		if k.KeyId != keyId || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		if key, err := k.publicKey(); err == nil {
+			return key
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Package apierror writes the JSON error responses shared by the backend and
+// recommends services. Every error has a stable code that clients can match
+// on, the message is for people and may change.
+//
+// Errors from the database map to codes as follows, by Postgres error class:
+//
+//	08 connection exception        connection            503
+//	22 data exception              data_violation        400
+//	23 unique_violation            uniqueness_violation  400
+//	23 foreign_key_violation       foreign_key_violation 404
+//	23 check_violation             check_violation       400
+//	no rows                        not_found             404
+//	anything else                  unexpected            500
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	BadRequest       = "bad_request"
+	InvalidField     = "invalid_field"
+	Unauthorized     = "unauthorized"
+	Forbidden        = "forbidden"
+	NotFound         = "not_found"
+	MethodNotAllowed = "method_not_allowed"
+	Conflict         = "conflict"
+	TooManyRequests  = "too_many_requests"
+	Internal         = "internal"
+	BadGateway       = "bad_gateway"
+	Unavailable      = "unavailable"
+	Unknown          = "unknown"
+
+	Connection          = "connection"
+	DataViolation       = "data_violation"
+	UniquenessViolation = "uniqueness_violation"
+	ForeignKeyViolation = "foreign_key_violation"
+	CheckViolation      = "check_violation"
+	Unexpected          = "unexpected"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          BadRequest,
+	http.StatusUnauthorized:        Unauthorized,
+	http.StatusForbidden:           Forbidden,
+	http.StatusNotFound:            NotFound,
+	http.StatusMethodNotAllowed:    MethodNotAllowed,
+	http.StatusConflict:            Conflict,
+	http.StatusTooManyRequests:     TooManyRequests,
+	http.StatusInternalServerError: Internal,
+	http.StatusBadGateway:          BadGateway,
+	http.StatusServiceUnavailable:  Unavailable,
+}
+
+// Field describes what's wrong with one field of the request
+type Field struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Response struct {
+	Code      string  `json:"code"`
+	Message   string  `json:"message"`
+	RequestId string  `json:"requestId,omitempty"`
+	Fields    []Field `json:"fields,omitempty"`
+}
+
+// CodeForStatus is the code used for errors that are only described by their
+// HTTP status
+func CodeForStatus(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return Unknown
+}
+
+// Write sends the error, filling in the request ID set by RequestId
+func Write(w http.ResponseWriter, status int, e Response) {
+	if e.RequestId == "" {
+		e.RequestId = w.Header().Get(RequestIdHeader)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// Error replaces http.Error, the code is picked from the status
+func Error(w http.ResponseWriter, message string, status int) {
+	Write(w, status, Response{Code: CodeForStatus(status), Message: message})
+}
+
+// Invalid sends a 400 listing the fields that failed validation
+func Invalid(w http.ResponseWriter, message string, fields ...Field) {
+	Write(w, http.StatusBadRequest, Response{Code: InvalidField, Message: message, Fields: fields})
+}
@@ -0,0 +1,34 @@
+package apierror
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+)
+
+const RequestIdHeader = "X-Request-Id"
+
+// Request IDs from clients are kept if they look like an ID, so that clients
+// can match errors to the requests they sent
+var requestIdRegexp = regexp.MustCompile(`^[\w-]{1,64}$`)
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestId gives each request an ID, which is sent back in the response's
+// X-Request-Id header and included in any errors
+func RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIdHeader)
+		if !requestIdRegexp.MatchString(id) {
+			id = newRequestId()
+		}
+
+		w.Header().Set(RequestIdHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
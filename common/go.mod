@@ -0,0 +1,3 @@
+module github.com/jbrunsting/transient/common
+
+go 1.27.1
@@ -4,21 +4,144 @@ CREATE TABLE IF NOT EXISTS Users (
     id VARCHAR(36) NOT NULL PRIMARY KEY,
     username VARCHAR(128) NOT NULL UNIQUE,
     password TEXT NOT NULL,
-    email VARCHAR(254) NOT NULL UNIQUE
+    email VARCHAR(254) NOT NULL UNIQUE,
+    private BOOLEAN NOT NULL DEFAULT FALSE,
+    role VARCHAR(16) NOT NULL DEFAULT 'user',
+    suspended BOOLEAN NOT NULL DEFAULT FALSE,
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+    usernameChanged TIMESTAMP,
+    totpSecret VARCHAR(32),
+    totpEnabled BOOLEAN NOT NULL DEFAULT FALSE,
+    totpLastStep BIGINT NOT NULL DEFAULT 0,
+    deletionScheduled TIMESTAMP,
+    displayName VARCHAR(64),
+    bio TEXT,
+    avatarUrl TEXT,
+    link TEXT
 );
 
+CREATE INDEX IF NOT EXISTS Users_deletionScheduled ON Users (deletionScheduled) WHERE deletionScheduled IS NOT NULL;
+
 CREATE TABLE IF NOT EXISTS Followings (
     id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
     followingId VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
-    PRIMARY KEY (id, followingId)
+    PRIMARY KEY (id, followingId),
+    CONSTRAINT Followings_not_self CHECK (id <> followingId)
+);
+
+CREATE INDEX IF NOT EXISTS Followings_followingId ON Followings (followingId);
+
+CREATE TABLE IF NOT EXISTS FollowRequests (
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    followingId VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    time TIMESTAMP NOT NULL,
+    PRIMARY KEY (id, followingId),
+    CONSTRAINT FollowRequests_not_self CHECK (id <> followingId)
+);
+
+CREATE INDEX IF NOT EXISTS FollowRequests_followingId ON FollowRequests (followingId, status);
+
+CREATE TABLE IF NOT EXISTS Blocks (
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    blockedId VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    time TIMESTAMP NOT NULL,
+    PRIMARY KEY (id, blockedId)
+);
+
+CREATE INDEX IF NOT EXISTS Blocks_blockedId ON Blocks (blockedId);
+
+CREATE TABLE IF NOT EXISTS Mutes (
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    mutedId VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    time TIMESTAMP NOT NULL,
+    PRIMARY KEY (id, mutedId)
 );
 
 CREATE TABLE IF NOT EXISTS Sessions (
     id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
     sessionId VARCHAR(36) NOT NULL PRIMARY KEY,
+    expiry TIMESTAMP NOT NULL,
+    reauthenticated TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS PasswordResets (
+    tokenHash VARCHAR(64) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    expiry TIMESTAMP NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS PasswordResets_id ON PasswordResets (id);
+
+CREATE TABLE IF NOT EXISTS EmailVerifications (
+    tokenHash VARCHAR(64) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    email VARCHAR(254) NOT NULL,
+    time TIMESTAMP NOT NULL,
+    expiry TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS EmailVerifications_id_time ON EmailVerifications (id, time);
+
+CREATE TABLE IF NOT EXISTS RecoveryCodes (
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    codeHash VARCHAR(64) NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (id, codeHash)
+);
+
+CREATE TABLE IF NOT EXISTS Identities (
+    provider VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    email VARCHAR(254) NOT NULL DEFAULT '',
+    time TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS Identities_id ON Identities (id);
+
+CREATE TABLE IF NOT EXISTS OidcLogins (
+    stateHash VARCHAR(64) NOT NULL PRIMARY KEY,
+    verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    linkId VARCHAR(36) REFERENCES Users(id) ON DELETE CASCADE,
+    reauth BOOLEAN NOT NULL DEFAULT FALSE,
     expiry TIMESTAMP NOT NULL
 );
 
+CREATE TABLE IF NOT EXISTS ApiTokens (
+    tokenId VARCHAR(36) NOT NULL PRIMARY KEY,
+    tokenHash VARCHAR(64) NOT NULL UNIQUE,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    time TIMESTAMP NOT NULL,
+    expiry TIMESTAMP NOT NULL,
+    lastUsed TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ApiTokens_id ON ApiTokens (id);
+
+CREATE TABLE IF NOT EXISTS UserExports (
+    exportId VARCHAR(36) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    data BYTEA,
+    time TIMESTAMP NOT NULL,
+    expiry TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS UserExports_pending ON UserExports (id) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS PendingLogins (
+    tokenHash VARCHAR(64) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    expiry TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0
+);
+
 CREATE TABLE IF NOT EXISTS Posts (
     id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
     postId VARCHAR(36) NOT NULL PRIMARY KEY,
@@ -26,12 +149,33 @@ CREATE TABLE IF NOT EXISTS Posts (
     title TEXT NOT NULL,
     content TEXT,
     postUrl TEXT,
-    imageUrl TEXT
+    imageUrl TEXT,
+    previewTitle TEXT,
+    previewDescription TEXT,
+    previewImageUrl TEXT,
+    format VARCHAR(16) NOT NULL DEFAULT 'plain',
+    hidden BOOLEAN NOT NULL DEFAULT FALSE,
+    searchVector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('english', title), 'A') ||
+        setweight(to_tsvector('english', coalesce(content, '')), 'B')
+    ) STORED
 );
 
+CREATE INDEX IF NOT EXISTS Posts_searchVector ON Posts USING GIN (searchVector);
+
+CREATE TABLE IF NOT EXISTS PostTags (
+    postId VARCHAR(36) NOT NULL REFERENCES Posts(postId) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL,
+    time TIMESTAMP NOT NULL,
+    PRIMARY KEY (postId, tag)
+);
+
+CREATE INDEX IF NOT EXISTS PostTags_tag_time ON PostTags (tag, time DESC);
+CREATE INDEX IF NOT EXISTS PostTags_time ON PostTags (time);
+
 CREATE TABLE IF NOT EXISTS Votes (
     id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
-    postId VARCHAR(36) NOT NULL REFERENCES Posts(postId),
+    postId VARCHAR(36) NOT NULL REFERENCES Posts(postId) ON DELETE CASCADE,
     time TIMESTAMP NOT NULL,
     vote INTEGER NOT NULL,
     PRIMARY KEY (id, postId)
@@ -39,8 +183,52 @@ CREATE TABLE IF NOT EXISTS Votes (
 
 CREATE TABLE IF NOT EXISTS Comments (
     id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
-    postId VARCHAR(36) NOT NULL REFERENCES Posts(postId),
+    postId VARCHAR(36) NOT NULL REFERENCES Posts(postId) ON DELETE CASCADE,
     commentId VARCHAR(36) NOT NULL PRIMARY KEY,
     time TIMESTAMP NOT NULL,
-    content TEXT NOT NULL
+    content TEXT NOT NULL,
+    format VARCHAR(16) NOT NULL DEFAULT 'plain',
+    hidden BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS Notifications (
+    notificationId VARCHAR(36) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    actorId VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL,
+    postId VARCHAR(36) REFERENCES Posts(postId) ON DELETE CASCADE,
+    commentId VARCHAR(36) REFERENCES Comments(commentId) ON DELETE CASCADE,
+    time TIMESTAMP NOT NULL,
+    read BOOLEAN NOT NULL DEFAULT FALSE
 );
+
+CREATE INDEX IF NOT EXISTS Notifications_id_time ON Notifications (id, time DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS Notifications_upvote ON Notifications (actorId, postId) WHERE kind = 'upvote';
+
+CREATE TABLE IF NOT EXISTS Reports (
+    reportId VARCHAR(36) NOT NULL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    targetType VARCHAR(16) NOT NULL,
+    targetId VARCHAR(36) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    details TEXT,
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    time TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS Reports_status_time ON Reports (status, time);
+CREATE INDEX IF NOT EXISTS Reports_target ON Reports (targetType, targetId);
+CREATE UNIQUE INDEX IF NOT EXISTS Reports_open ON Reports (id, targetType, targetId) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS ModerationActions (
+    actionId VARCHAR(36) NOT NULL PRIMARY KEY,
+    moderatorId VARCHAR(36) REFERENCES Users(id) ON DELETE SET NULL,
+    action VARCHAR(16) NOT NULL,
+    targetType VARCHAR(16) NOT NULL,
+    targetId VARCHAR(36) NOT NULL,
+    reportId VARCHAR(36) REFERENCES Reports(reportId) ON DELETE SET NULL,
+    reason TEXT,
+    time TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ModerationActions_time ON ModerationActions (time DESC);
@@ -16,8 +16,9 @@ Vue.prototype.$http.getProtected = (...args) => axios.get(...args)
 
 Vue.prototype.CONNECTION = 'connection';
 Vue.prototype.NOT_FOUND = 'not_found';
-Vue.prototype.DATA_VIOLATION = 'data_volation';
+Vue.prototype.DATA_VIOLATION = 'data_violation';
 Vue.prototype.UNIQUENESS_VIOLATION = 'uniqueness_violation';
+Vue.prototype.INVALID_FIELD = 'invalid_field';
 Vue.prototype.UNEXPECTED = 'unexpected';
 
 new Vue({
@@ -41,6 +41,20 @@ export default new Router({
                 './views/Profile.vue'
             )
         },
+        {
+            path: '/reset-password',
+            name: 'resetpassword',
+            component: () => import(/* webpackChunkName: "resetpassword" */
+                './views/ResetPassword.vue'
+            )
+        },
+        {
+            path: '/verify',
+            name: 'verify',
+            component: () => import(/* webpackChunkName: "verify" */
+                './views/Verify.vue'
+            )
+        },
         // TODO: Don't allow unless authenticated
         {
             path: '/following',
@@ -8,7 +8,14 @@ import (
 
 type Api interface {
 	NodePost(w http.ResponseWriter, r *http.Request)
+	NodeDelete(w http.ResponseWriter, r *http.Request)
 	EdgePost(w http.ResponseWriter, r *http.Request)
+	EdgeDelete(w http.ResponseWriter, r *http.Request)
+	PrivacyPost(w http.ResponseWriter, r *http.Request)
+	ExclusionPost(w http.ResponseWriter, r *http.Request)
+	ExclusionDelete(w http.ResponseWriter, r *http.Request)
+	RebuildPost(w http.ResponseWriter, r *http.Request)
+	StatsGet(w http.ResponseWriter, r *http.Request)
 	PostsGet(w http.ResponseWriter, r *http.Request)
 	FollowingsGet(w http.ResponseWriter, r *http.Request)
 }
@@ -17,6 +24,6 @@ type api struct {
 	recommendsApi
 }
 
-func NewApi(graph map[string]*models.Node) Api {
-	return &api{recommendsApi: recommendsApi{graph: graph}}
+func NewApi(graph map[string]*models.Node, generator GraphGenerator) Api {
+	return &api{recommendsApi: recommendsApi{graph: graph, generator: generator}}
 }
@@ -7,10 +7,12 @@ import (
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/jbrunsting/transient/common/apierror"
 	"github.com/jbrunsting/transient/recommends/models"
 )
 
@@ -26,54 +28,105 @@ var typeFractions = map[int]float64{
 	models.CreationEdge: 0.1,
 	models.UpvoteEdge:   0.004,
 	models.DownvoteEdge: -0.02,
-	models.FollowEdge:   0.2, // TODO: support
+	models.FollowEdge:   0.2,
+	models.TagEdge:      0.05,
+}
+
+// GraphGenerator builds the recommends graph from scratch
+type GraphGenerator interface {
+	GenerateGraph() (map[string]*models.Node, error)
 }
 
 type recommendsApi struct {
-	graph map[string]*models.Node
+	// Generating recommends updates the weights of the nodes, so anything
+	// other than reading the graph's structure needs the write lock
+	mu        sync.RWMutex
+	graph     map[string]*models.Node
+	generator GraphGenerator
 }
 
 func (a *recommendsApi) NodePost(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	var n models.NodeResource
 	err := json.NewDecoder(r.Body).Decode(&n)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if n.Type != models.UserNode && n.Type != models.PostNode {
-		http.Error(w,
-			fmt.Sprintf("Invalid type, must be one of [%v, %v]", models.UserNode, models.PostNode),
+	if n.Type != models.UserNode && n.Type != models.PostNode && n.Type != models.TagNode {
+		apierror.Error(w,
+			fmt.Sprintf("Invalid type, must be one of [%v, %v, %v]", models.UserNode, models.PostNode, models.TagNode),
 			http.StatusBadRequest)
 		return
 	}
 
-	var node models.Node
-	node.Id = n.Id
-	node.Type = n.Type
-	node.Timestamp = n.Timestamp
-	a.graph[node.Id] = &node
+	// Tag nodes are shared between posts, so the node may already exist and
+	// we don't want to drop the edges it already has
+	if _, ok := a.graph[n.Id]; !ok {
+		var node models.Node
+		node.Id = n.Id
+		node.Type = n.Type
+		node.Timestamp = n.Timestamp
+		node.Weights = map[string]float64{}
+		a.graph[node.Id] = &node
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// NodeDelete removes the node and every edge to it from the graph
+func (a *recommendsApi) NodeDelete(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	vars := mux.Vars(r)
+
+	id, ok := vars["id"]
+	if !ok {
+		apierror.Error(w, "Must provide an id", http.StatusBadRequest)
+		return
+	}
+
+	node, ok := a.graph[id]
+	if !ok {
+		apierror.Error(w, "Unknown ID", http.StatusBadRequest)
+		return
+	}
+
+	// Not every edge has a reverse edge, so every node has to be checked
+	for _, other := range a.graph {
+		models.RemoveEdge(other, node)
+	}
+	delete(a.graph, id)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 }
 
 func (a *recommendsApi) EdgePost(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	var e models.EdgeResource
 	err := json.NewDecoder(r.Body).Decode(&e)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if _, ok := a.graph[e.DestinationId]; !ok {
-		http.Error(w, "Invalid destination id", http.StatusBadRequest)
+		apierror.Error(w, "Invalid destination id", http.StatusBadRequest)
 		return
 	}
 
-	if e.Type != models.UpvoteEdge && e.Type != models.DownvoteEdge && e.Type != models.CreationEdge {
-		http.Error(w,
-			fmt.Sprintf("Invalid type, must be one of [%v, %v, %v]", models.UpvoteEdge, models.DownvoteEdge, models.CreationEdge),
+	if !validEdgeType(e.Type) {
+		apierror.Error(w,
+			fmt.Sprintf("Invalid type, must be one of [%v, %v, %v, %v, %v]",
+				models.UpvoteEdge, models.DownvoteEdge, models.CreationEdge, models.FollowEdge, models.TagEdge),
 			http.StatusBadRequest)
 		return
 	}
@@ -86,17 +139,187 @@ func (a *recommendsApi) EdgePost(w http.ResponseWriter, r *http.Request) {
 			edge.Type = e.Type
 			edge.Timestamp = e.Timestamp
 			models.AddEdge(edge)
-			edge.Destination, edge.Source = edge.Source, edge.Destination
-			models.AddEdge(edge)
+			// Following isn't mutual, so follow edges only go one way
+			if edge.Type != models.FollowEdge {
+				edge.Destination, edge.Source = edge.Source, edge.Destination
+				models.AddEdge(edge)
+			}
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 		} else {
-			http.Error(w, "Invalid destination id", http.StatusBadRequest)
+			apierror.Error(w, "Invalid destination id", http.StatusBadRequest)
 		}
 	} else {
-		http.Error(w, "Invalid source id", http.StatusBadRequest)
+		apierror.Error(w, "Invalid source id", http.StatusBadRequest)
+	}
+}
+
+func validEdgeType(t int) bool {
+	return t == models.UpvoteEdge || t == models.DownvoteEdge || t == models.CreationEdge ||
+		t == models.FollowEdge || t == models.TagEdge
+}
+
+func (a *recommendsApi) EdgeDelete(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var e models.EdgeResource
+	err := json.NewDecoder(r.Body).Decode(&e)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sourceNode, ok := a.graph[e.SourceId]
+	if !ok {
+		apierror.Error(w, "Invalid source id", http.StatusBadRequest)
+		return
+	}
+
+	destinationNode, ok := a.graph[e.DestinationId]
+	if !ok {
+		apierror.Error(w, "Invalid destination id", http.StatusBadRequest)
+		return
+	}
+
+	models.RemoveEdge(sourceNode, destinationNode)
+	if e.Type != models.FollowEdge {
+		models.RemoveEdge(destinationNode, sourceNode)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *recommendsApi) PrivacyPost(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var p models.PrivacyResource
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	node, ok := a.graph[p.Id]
+	if !ok || node.Type != models.UserNode {
+		apierror.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	node.Private = p.Private
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *recommendsApi) decodeExclusion(w http.ResponseWriter, r *http.Request) (*models.Node, *models.Node, string, bool) {
+	var e models.ExclusionResource
+	err := json.NewDecoder(r.Body).Decode(&e)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	if e.Type != models.BlockExclusion && e.Type != models.MuteExclusion {
+		apierror.Error(w,
+			fmt.Sprintf("Invalid type, must be one of [%v, %v]", models.BlockExclusion, models.MuteExclusion),
+			http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	node, ok := a.graph[e.Id]
+	if !ok || node.Type != models.UserNode {
+		apierror.Error(w, "Invalid id", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	excluded, ok := a.graph[e.ExcludedId]
+	if !ok || excluded.Type != models.UserNode {
+		apierror.Error(w, "Invalid excluded id", http.StatusBadRequest)
+		return nil, nil, "", false
+	}
+
+	return node, excluded, e.Type, true
+}
+
+func (a *recommendsApi) ExclusionPost(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	node, excluded, exclusionType, ok := a.decodeExclusion(w, r)
+	if !ok {
+		return
+	}
+
+	if exclusionType == models.BlockExclusion {
+		models.Block(node, excluded)
+	} else {
+		node.Mute(excluded)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *recommendsApi) ExclusionDelete(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	node, excluded, exclusionType, ok := a.decodeExclusion(w, r)
+	if !ok {
+		return
+	}
+
+	if exclusionType == models.BlockExclusion {
+		models.Unblock(node, excluded)
+	} else {
+		node.Unmute(excluded)
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+// RebuildPost regenerates the graph from the database, the old graph keeps
+// serving requests until the new one is ready
+func (a *recommendsApi) RebuildPost(w http.ResponseWriter, r *http.Request) {
+	graph, err := a.generator.GenerateGraph()
+	if err != nil {
+		log.Printf("Error generating graph: %v\n", err)
+		apierror.Error(w, "Could not generate graph", http.StatusInternalServerError)
+		return
+	}
+
+	a.mu.Lock()
+	a.graph = graph
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *recommendsApi) StatsGet(w http.ResponseWriter, r *http.Request) {
+	a.mu.RLock()
+	var stats models.GraphStats
+	stats.Nodes = len(a.graph)
+	for _, node := range a.graph {
+		stats.Edges += len(node.Edges)
+		switch node.Type {
+		case models.UserNode:
+			stats.Users++
+		case models.PostNode:
+			stats.Posts++
+		case models.TagNode:
+			stats.Tags++
+		}
+	}
+	a.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
 }
 
 func formatEdges(edges []models.Edge) string {
@@ -107,6 +330,8 @@ func formatEdges(edges []models.Edge) string {
 			d = "u"
 		} else if edge.Destination.Type == models.PostNode {
 			d = "p"
+		} else if edge.Destination.Type == models.TagNode {
+			d = "t"
 		} else {
 			d = "?"
 		}
@@ -120,6 +345,8 @@ func formatEdges(edges []models.Edge) string {
 			t = "-"
 		} else if edge.Type == models.FollowEdge {
 			t = "f"
+		} else if edge.Type == models.TagEdge {
+			t = "#"
 		} else {
 			t = "?"
 		}
@@ -192,7 +419,7 @@ func GenerateRecommends(start *models.Node, nodeType int) []*models.Node {
 
 	recommends := []*models.Node{}
 	for node, _ := range seen {
-		if node.Type == nodeType && node.Weights[id] > 0 {
+		if node.Type == nodeType && node.Weights[id] > 0 && node.VisibleTo(start) && !start.Excludes(node) {
 			recommends = append(recommends, node)
 		}
 	}
@@ -233,17 +460,20 @@ func (a *recommendsApi) printGraph() {
 }
 
 func (a *recommendsApi) PostsGet(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	vars := mux.Vars(r)
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide an id", http.StatusBadRequest)
+		apierror.Error(w, "Must provide an id", http.StatusBadRequest)
 		return
 	}
 
 	node, ok := a.graph[id]
 	if !ok {
-		http.Error(w, "Unknown ID", http.StatusBadRequest)
+		apierror.Error(w, "Unknown ID", http.StatusBadRequest)
 		return
 	}
 
@@ -256,7 +486,7 @@ func (a *recommendsApi) PostsGet(w http.ResponseWriter, r *http.Request) {
 	recommendsIds := []string{}
 	log.Printf("Recommends:")
 	for _, node := range recommends {
-		log.Printf(node.Id[0:5])
+		log.Print(node.Id[0:5])
         log.Printf("%v\n", node.Weights)
 		recommendsIds = append(recommendsIds, node.Id)
 	}
@@ -267,17 +497,20 @@ func (a *recommendsApi) PostsGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *recommendsApi) FollowingsGet(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	vars := mux.Vars(r)
 
 	id, ok := vars["id"]
 	if !ok {
-		http.Error(w, "Must provide an id", http.StatusBadRequest)
+		apierror.Error(w, "Must provide an id", http.StatusBadRequest)
 		return
 	}
 
 	node, ok := a.graph[id]
 	if !ok {
-		http.Error(w, "Unknown ID", http.StatusBadRequest)
+		apierror.Error(w, "Unknown ID", http.StatusBadRequest)
 		return
 	}
 
@@ -290,7 +523,7 @@ func (a *recommendsApi) FollowingsGet(w http.ResponseWriter, r *http.Request) {
 	recommendsIds := []string{}
 	log.Printf("Recommends:")
 	for _, node := range recommends {
-		log.Printf(node.Id[0:5])
+		log.Print(node.Id[0:5])
 		recommendsIds = append(recommendsIds, node.Id)
 	}
 
@@ -20,7 +20,7 @@ type recommendsHandler struct {
 func (h *recommendsHandler) GenerateGraph() (map[string]*models.Node, error) {
 	nodes := make(map[string]*models.Node, 100000)
 
-	s := `SELECT id FROM Users`
+	s := `SELECT id, private FROM Users`
 	userRows, err := h.db.Query(s)
 	if err != nil {
 		return nodes, formatError(err, "user", "querying user ID's")
@@ -29,7 +29,7 @@ func (h *recommendsHandler) GenerateGraph() (map[string]*models.Node, error) {
 
 	for userRows.Next() {
 		var node models.Node
-		if err = userRows.Scan(&node.Id); err != nil {
+		if err = userRows.Scan(&node.Id, &node.Private); err != nil {
 			// Don't return error because we don't want a single error to abort
 			// the whole operation. In the future, we may want to handle this
 			// better
@@ -69,9 +69,57 @@ func (h *recommendsHandler) GenerateGraph() (map[string]*models.Node, error) {
         }
 	}
 
+	s = `SELECT id, blockedId FROM Blocks`
+	blockRows, err := h.db.Query(s)
+	if err != nil {
+		return nodes, formatError(err, "block", "querying blocks")
+	}
+	defer blockRows.Close()
+
+	for blockRows.Next() {
+		var id string
+		var blockedId string
+		if err = blockRows.Scan(&id, &blockedId); err != nil {
+			log.Printf("Error reading block row: %s\n", err)
+			continue
+		}
+
+		user, ok := nodes[id]
+		blocked, blockedOk := nodes[blockedId]
+		if !ok || !blockedOk {
+			log.Printf("Unknown user in block, got ids %v, %v\n", id, blockedId)
+			continue
+		}
+		models.Block(user, blocked)
+	}
+
+	s = `SELECT id, mutedId FROM Mutes`
+	muteRows, err := h.db.Query(s)
+	if err != nil {
+		return nodes, formatError(err, "mute", "querying mutes")
+	}
+	defer muteRows.Close()
+
+	for muteRows.Next() {
+		var id string
+		var mutedId string
+		if err = muteRows.Scan(&id, &mutedId); err != nil {
+			log.Printf("Error reading mute row: %s\n", err)
+			continue
+		}
+
+		user, ok := nodes[id]
+		muted, mutedOk := nodes[mutedId]
+		if !ok || !mutedOk {
+			log.Printf("Unknown user in mute, got ids %v, %v\n", id, mutedId)
+			continue
+		}
+		user.Mute(muted)
+	}
+
 	lookback := time.Now().AddDate(0, 0, -lookbackDays)
 	s = `SELECT Posts.id, Posts.postId, Posts.time, Votes.id, Votes.vote, Votes.time FROM Posts
-    LEFT JOIN Votes ON Votes.postId = Posts.postId WHERE Posts.time > $1`
+    LEFT JOIN Votes ON Votes.postId = Posts.postId WHERE Posts.time > $1 AND NOT Posts.hidden`
 	voteRows, err := h.db.Query(s, lookback)
 	if err != nil {
 		return nodes, formatError(err, "vote", "querying votes")
@@ -139,5 +187,49 @@ func (h *recommendsHandler) GenerateGraph() (map[string]*models.Node, error) {
 		}
 	}
 
+	s = `SELECT PostTags.postId, PostTags.tag FROM PostTags
+    INNER JOIN Posts ON Posts.postId = PostTags.postId WHERE Posts.time > $1 AND NOT Posts.hidden`
+	tagRows, err := h.db.Query(s, lookback)
+	if err != nil {
+		return nodes, formatError(err, "tag", "querying post tags")
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var tag string
+		if err = tagRows.Scan(&postId, &tag); err != nil {
+			log.Printf("Error reading post tag row: %s\n", err)
+			continue
+		}
+
+		postNode, ok := nodes[postId]
+		if !ok {
+			log.Printf("Unknown post id from tag, got id %v\n", postId)
+			continue
+		}
+
+		// Tag node IDs are prefixed so that they can't collide with user or
+		// post IDs
+		tagId := "tag:" + tag
+		if _, ok := nodes[tagId]; !ok {
+			nodes[tagId] = &models.Node{
+				Id:        tagId,
+				Type:      models.TagNode,
+				Timestamp: postNode.Timestamp,
+				Weights:   map[string]float64{},
+			}
+		}
+
+		edge := models.Edge{
+			Source:      postNode,
+			Destination: nodes[tagId],
+			Type:        models.TagEdge,
+			Timestamp:   postNode.Timestamp,
+		}
+		models.AddEdge(edge)
+		edge.Source, edge.Destination = edge.Destination, edge.Source
+		models.AddEdge(edge)
+	}
+
 	return nodes, nil
 }
@@ -1,14 +1,19 @@
 module github.com/jbrunsting/transient/recommends
 
+go 1.27.1
+
 require (
 	github.com/0xAX/notificator v0.0.0-20181105090803-d81462e38c21 // indirect
 	github.com/codegangsta/envy v0.0.0-20141216192214-4b78388c8ce4 // indirect
 	github.com/codegangsta/gin v0.0.0-20171026143024-cafe2ce98974 // indirect
 	github.com/gofrs/uuid v3.1.0+incompatible
 	github.com/gorilla/mux v1.6.2
+	github.com/jbrunsting/transient/common v0.0.0
 	github.com/lib/pq v1.0.0
 	github.com/mattn/go-shellwords v1.0.3 // indirect
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	golang.org/x/crypto v0.0.0-20181203042331-505ab145d0a9
 	gopkg.in/urfave/cli.v1 v1.20.0 // indirect
 )
+
+replace github.com/jbrunsting/transient/common => ../common
@@ -6,6 +6,7 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/jbrunsting/transient/common/apierror"
 	"github.com/jbrunsting/transient/recommends/api"
 	"github.com/jbrunsting/transient/recommends/database"
 )
@@ -31,13 +32,24 @@ func main() {
         panic(err)
     }
 
-	a := api.NewApi(graph)
+	a := api.NewApi(graph, databaseHandler)
 
 	r.HandleFunc("/posts/{id}", a.PostsGet).Methods("GET")
 	r.HandleFunc("/followings/{id}", a.FollowingsGet).Methods("GET")
 	r.HandleFunc("/edge", a.EdgePost).Methods("POST")
+	r.HandleFunc("/edge", a.EdgeDelete).Methods("DELETE")
 	r.HandleFunc("/node", a.NodePost).Methods("POST")
+	r.HandleFunc("/node/{id}", a.NodeDelete).Methods("DELETE")
+	r.HandleFunc("/privacy", a.PrivacyPost).Methods("POST")
+	r.HandleFunc("/exclusion", a.ExclusionPost).Methods("POST")
+	r.HandleFunc("/exclusion", a.ExclusionDelete).Methods("DELETE")
+	r.HandleFunc("/rebuild", a.RebuildPost).Methods("POST")
+	r.HandleFunc("/stats", a.StatsGet).Methods("GET")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	})
 
 	log.Println("Listening on port 4000")
-	http.ListenAndServe(":4000", r)
+	http.ListenAndServe(":4000", apierror.RequestId(r))
 }
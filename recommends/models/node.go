@@ -8,33 +8,43 @@ import (
 const (
 	UserNode = 0
 	PostNode = 1
+	TagNode  = 2
 
 	UpvoteEdge   = 0
 	DownvoteEdge = 1
 	CreationEdge = 2
 	FollowEdge   = 3
+	TagEdge      = 4
+
+	BlockExclusion = "block"
+	MuteExclusion  = "mute"
 
 	// Edges will be given priority over edges which are hourDiffForPriority
 	// hours older, regardless of type
 	hourDiffForPriority = 100
 )
 
-var edgeRankings = []int{FollowEdge, CreationEdge, DownvoteEdge, UpvoteEdge}
+var edgeRankings = []int{FollowEdge, CreationEdge, DownvoteEdge, UpvoteEdge, TagEdge}
 
 type Edge struct {
 	Source      *Node
 	Destination *Node
-	Type        int // One of Upvote, Downvote, Creation
+	Type        int // One of Upvote, Downvote, Creation, Follow, Tag
 	Timestamp   time.Time
 }
 
 type Node struct {
 	Id           string
-	Type         int // One of UserNode, PostNode
+	Type         int // One of UserNode, PostNode, TagNode
 	Edges        []Edge
 	Destinations map[string]bool
 	Timestamp    time.Time
 	Weights      map[string]float64 // TODO: Use map that allows concurrent access
+	Private      bool               // Only set for user nodes
+	Creator      *Node              // Only set for post nodes
+	Blocked      map[string]bool    // Users blocked by this user
+	BlockedBy    map[string]bool    // Users blocking this user
+	Muted        map[string]bool    // Users muted by this user
 }
 
 type EdgeResource struct {
@@ -44,6 +54,25 @@ type EdgeResource struct {
 	Timestamp     time.Time `json:"timestamp"`
 }
 
+type PrivacyResource struct {
+	Id      string `json:"id"`
+	Private bool   `json:"private"`
+}
+
+type ExclusionResource struct {
+	Id         string `json:"id"`
+	ExcludedId string `json:"excludedId"`
+	Type       string `json:"type"` // One of BlockExclusion, MuteExclusion
+}
+
+type GraphStats struct {
+	Nodes int `json:"nodes"`
+	Edges int `json:"edges"`
+	Users int `json:"users"`
+	Posts int `json:"posts"`
+	Tags  int `json:"tags"`
+}
+
 type NodeResource struct {
 	Id        string    `json:"id"`
 	Type      int       `json:"type"`
@@ -86,5 +115,84 @@ func AddEdge(e Edge) {
 		e.Source.Destinations[e.Destination.Id] = true
 	}
 
+	if e.Type == CreationEdge && e.Source.Type == UserNode && e.Destination.Type == PostNode {
+		e.Destination.Creator = e.Source
+	}
+
 	e.Source.SortEdges()
 }
+
+func RemoveEdge(source, destination *Node) {
+	if !source.Destinations[destination.Id] {
+		return
+	}
+
+	for i, edge := range source.Edges {
+		if edge.Destination == destination {
+			source.Edges = append(source.Edges[:i], source.Edges[i+1:]...)
+			break
+		}
+	}
+	delete(source.Destinations, destination.Id)
+}
+
+// Block records that a blocked b, which excludes each user from the other's
+// recommends
+func Block(a, b *Node) {
+	if a.Blocked == nil {
+		a.Blocked = map[string]bool{}
+	}
+	if b.BlockedBy == nil {
+		b.BlockedBy = map[string]bool{}
+	}
+	a.Blocked[b.Id] = true
+	b.BlockedBy[a.Id] = true
+}
+
+// Unblock removes a's block of b, a block of a by b is kept
+func Unblock(a, b *Node) {
+	delete(a.Blocked, b.Id)
+	delete(b.BlockedBy, a.Id)
+}
+
+func (n *Node) Mute(other *Node) {
+	if n.Muted == nil {
+		n.Muted = map[string]bool{}
+	}
+	n.Muted[other.Id] = true
+}
+
+func (n *Node) Unmute(other *Node) {
+	delete(n.Muted, other.Id)
+}
+
+// Excludes returns true if other, or the creator of other if it is a post,
+// shouldn't be recommended to n
+func (n *Node) Excludes(other *Node) bool {
+	if other.Creator != nil {
+		other = other.Creator
+	}
+
+	return n.Blocked[other.Id] || n.BlockedBy[other.Id] || n.Muted[other.Id]
+}
+
+// Follows returns true if n has a follow edge to other
+func (n *Node) Follows(other *Node) bool {
+	for _, edge := range n.Edges {
+		if edge.Type == FollowEdge && edge.Destination == other {
+			return true
+		}
+	}
+
+	return false
+}
+
+// VisibleTo returns false for posts by private users that viewer doesn't
+// follow
+func (n *Node) VisibleTo(viewer *Node) bool {
+	if n.Creator == nil || !n.Creator.Private || n.Creator == viewer {
+		return true
+	}
+
+	return viewer.Follows(n.Creator)
+}